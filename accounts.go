@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pyk/relieve/database"
+)
+
+// Account deletion is self-service: DELETE /v1/users/{id} schedules it
+// config.DeletionGrace ahead, the user can cancel it until then, and
+// deleteDueAccounts carries it out following config.DeletionPolicy. Each
+// step is written to the audit log. Until users log in, the request proves
+// the account is theirs with its email, see requireOwner.
+
+// audit append an entry to the audit log. A failure is logged only, the
+// change it records is already done.
+func audit(ctx context.Context, action string, userID int, details string) {
+	a := database.AuditEntry{Action: action, UserId: userID, Details: details}
+	if err := db.InsertAuditEntry(ctx, &a); err != nil {
+		slog.ErrorContext(ctx, "Audit log write failed", "action", action, "user_id", userID, "err", err)
+	}
+}
+
+// ownership is the body of the requests changing an account.
+type ownership struct {
+	Email string `json:"user_email" validate:"required,email,maxlen=254"`
+}
+
+// requireOwner answer 403 unless the body of r holds the email of the user
+// id. Admins need no body.
+func requireOwner(tag string, r *http.Request, id int) *apiError {
+	if isAdmin(r) {
+		return nil
+	}
+	var o ownership
+	if apiErr := decodeJSON(tag+" Decode", r, &o); apiErr != nil {
+		return apiErr
+	}
+	u, err := db.GetUser(r.Context(), id)
+	if err != nil {
+		return storeError(tag+" db.GetUser", err, "user.not_found")
+	}
+	if !strings.EqualFold(strings.TrimSpace(o.Email), u.Email) {
+		return forbidden(tag, fmt.Errorf("user_email is not the one of user %d", id), "account.not_owner")
+	}
+	return nil
+}
+
+// DELETE /v1/users/{id} {"user_email": EMAIL} schedule the deletion of the
+// account. It answers 202 with the deletion, the one already pending if any.
+func v1DeleteUser(w http.ResponseWriter, r *http.Request) *apiError {
+	id, apiErr := pathID("v1DeleteUser", r, "id")
+	if apiErr != nil {
+		return apiErr
+	}
+	setUserID(r, strconv.Itoa(id))
+	if apiErr := requireOwner("v1DeleteUser", r, id); apiErr != nil {
+		return apiErr
+	}
+	location := fmt.Sprintf("/v1/users/%d/deletion", id)
+	d, err := db.GetUserDeletion(r.Context(), id)
+	if err == nil {
+		w.Header().Set("Location", location)
+		return writeJSON("v1DeleteUser", w, http.StatusAccepted, d)
+	}
+	if !errors.Is(err, database.ErrNotFound) {
+		return storeError("v1DeleteUser db.GetUserDeletion", err, "")
+	}
+	after := time.Now().Add(config.DeletionGrace)
+	d = database.UserDeletion{UserId: id, DeleteAfter: &after}
+	if err := db.ScheduleUserDeletion(r.Context(), &d); err != nil {
+		return storeError("v1DeleteUser db.ScheduleUserDeletion", err, "user.not_found")
+	}
+	audit(r.Context(), "account.deletion_requested", id, "delete after "+d.DeleteAfter.UTC().Format(time.RFC3339))
+	w.Header().Set("Location", location)
+	return writeJSON("v1DeleteUser", w, http.StatusAccepted, d)
+}
+
+// GET /v1/users/{id}/deletion
+func v1GetDeletion(w http.ResponseWriter, r *http.Request) *apiError {
+	id, apiErr := pathID("v1GetDeletion", r, "id")
+	if apiErr != nil {
+		return apiErr
+	}
+	d, err := db.GetUserDeletion(r.Context(), id)
+	if err != nil {
+		return storeError("v1GetDeletion db.GetUserDeletion", err, "deletion.not_scheduled")
+	}
+	return writeJSON("v1GetDeletion", w, http.StatusOK, d)
+}
+
+// DELETE /v1/users/{id}/deletion {"user_email": EMAIL} cancel the deletion
+// of the account.
+func v1CancelDeletion(w http.ResponseWriter, r *http.Request) *apiError {
+	id, apiErr := pathID("v1CancelDeletion", r, "id")
+	if apiErr != nil {
+		return apiErr
+	}
+	setUserID(r, strconv.Itoa(id))
+	if apiErr := requireOwner("v1CancelDeletion", r, id); apiErr != nil {
+		return apiErr
+	}
+	if err := db.CancelUserDeletion(r.Context(), id); err != nil {
+		return storeError("v1CancelDeletion db.CancelUserDeletion", err, "deletion.not_scheduled")
+	}
+	audit(r.Context(), "account.deletion_cancelled", id, "")
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// GET /v1/admin/audit?user_id=ID list the audit log of a user, oldest
+// first, for admins.
+func v1ListAudit(w http.ResponseWriter, r *http.Request) *apiError {
+	if apiErr := requireAdmin("v1ListAudit", r); apiErr != nil {
+		return apiErr
+	}
+	userID, apiErr := queryID("v1ListAudit", r, "user_id")
+	if apiErr != nil {
+		return apiErr
+	}
+	entries, err := db.GetAuditEntries(r.Context(), userID)
+	if err != nil {
+		return storeError("v1ListAudit db.GetAuditEntries", err, "")
+	}
+	return writeJSON("v1ListAudit", w, http.StatusOK, entries)
+}
+
+// deleteDueAccounts carry out the deletions due at now.
+func deleteDueAccounts(ctx context.Context, now time.Time) {
+	ids, err := db.GetDueUserDeletions(ctx, now)
+	if err != nil {
+		if !errors.Is(err, database.ErrNotReady) {
+			slog.Error("List due account deletions", "err", err)
+		}
+		return
+	}
+	anonymize := config.DeletionPolicy == "anonymize"
+	for _, id := range ids {
+		err := db.CompleteUserDeletion(ctx, id, now, anonymize)
+		if errors.Is(err, database.ErrNotFound) {
+			// cancelled in the meantime
+			continue
+		}
+		if err != nil {
+			slog.Error("Delete account", "user_id", id, "err", err)
+			continue
+		}
+		audit(ctx, "account.deleted", id, "policy "+config.DeletionPolicy)
+		slog.Info("Deleted account", "user_id", id, "policy", config.DeletionPolicy)
+	}
+}
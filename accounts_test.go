@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pyk/relieve/database"
+)
+
+func TestAccountDeletion(t *testing.T) {
+	for _, policy := range []string{"anonymize", "delete"} {
+		t.Run(policy, func(t *testing.T) {
+			testAccountDeletion(t, policy)
+		})
+	}
+}
+
+func testAccountDeletion(t *testing.T, policy string) {
+	ts := newTestServer(t)
+	defer func(c Config) { config = c }(config)
+	config.DeletionPolicy = policy
+	config.AdminToken = "admin-secret"
+	get := func(path string) (*http.Response, string) {
+		return do(t, "GET", ts.URL+path, "", "")
+	}
+
+	var user database.User
+	resp, body := postJSON(t, ts.URL+"/v1/users", database.User{Email: uniqueEmail("gone"), Age: 25})
+	userLoc := checkCreated(t, resp, body, &user)
+	var psikolog database.Psikolog
+	resp, body = postJSON(t, ts.URL+"/v1/psikologs", database.Psikolog{Email: uniqueEmail("gone"), Name: "Dr. Tuesday"})
+	checkCreated(t, resp, body, &psikolog)
+	pid := strconv.Itoa(psikolog.Id)
+	var post database.Post
+	resp, body = postJSON(t, ts.URL+"/v1/posts", database.Post{UserId: strconv.Itoa(user.Id), PsikologId: pid, Title: "t", Category: "c", Content: "text"})
+	postLoc := checkCreated(t, resp, body, &post)
+	resp, body = postJSON(t, ts.URL+postLoc+"/comments", map[string]interface{}{
+		"comment_user_id": user.Id, "comment_psikolog_id": psikolog.Id, "comment_text": "hang in there",
+	})
+	checkStatus(t, resp, body, http.StatusCreated)
+	resp, body = postJSON(t, ts.URL+"/v1/psikologs/"+pid+"/wisdom", map[string]int{"user_id": user.Id})
+	checkStatus(t, resp, body, http.StatusCreated)
+
+	// only the owner of the account, who knows its email
+	owner := `{"user_email": "` + strings.ToUpper(user.Email) + `"}`
+	resp, body = do(t, "DELETE", ts.URL+userLoc, "", "")
+	checkV1Error(t, resp, body, http.StatusBadRequest, "request.malformed_json")
+	resp, body = do(t, "DELETE", ts.URL+userLoc, "application/json", `{"user_email": "someone@example.com"}`)
+	checkV1Error(t, resp, body, http.StatusForbidden, "account.not_owner")
+
+	// requested, cancelled and requested again
+	resp, body = do(t, "DELETE", ts.URL+userLoc, "application/json", owner)
+	checkStatus(t, resp, body, http.StatusAccepted)
+	var d database.UserDeletion
+	if err := json.Unmarshal([]byte(body), &d); err != nil || d.DeleteAfter == nil || resp.Header.Get("Location") != userLoc+"/deletion" {
+		t.Fatalf("DELETE %s = %s", userLoc, body)
+	}
+	resp, body = do(t, "DELETE", ts.URL+userLoc, "application/json", owner)
+	var again database.UserDeletion
+	if err := json.Unmarshal([]byte(body), &again); err != nil || !again.DeleteAfter.Equal(*d.DeleteAfter) {
+		t.Errorf("second DELETE moved the deletion: %s", body)
+	}
+	resp, body = get(userLoc + "/deletion")
+	checkStatus(t, resp, body, http.StatusOK)
+	resp, body = do(t, "DELETE", ts.URL+userLoc+"/deletion", "application/json", `{"user_email": "someone@example.com"}`)
+	checkV1Error(t, resp, body, http.StatusForbidden, "account.not_owner")
+	resp, body = do(t, "DELETE", ts.URL+userLoc+"/deletion", "application/json", owner)
+	checkStatus(t, resp, body, http.StatusNoContent)
+	resp, body = get(userLoc + "/deletion")
+	checkV1Error(t, resp, body, http.StatusNotFound, "deletion.not_scheduled")
+	resp, body = do(t, "DELETE", ts.URL+userLoc+"/deletion", "application/json", owner)
+	checkV1Error(t, resp, body, http.StatusNotFound, "deletion.not_scheduled")
+	resp, body = doAdmin(t, "DELETE", ts.URL+userLoc, config.AdminToken)
+	checkStatus(t, resp, body, http.StatusAccepted)
+
+	// nothing happens during the grace period
+	deleteDueAccounts(context.Background(), time.Now())
+	resp, body = doAdmin(t, "GET", ts.URL+userLoc, config.AdminToken)
+	checkStatus(t, resp, body, http.StatusOK)
+
+	deleteDueAccounts(context.Background(), time.Now().Add(config.DeletionGrace+time.Minute))
+	resp, body = doAdmin(t, "GET", ts.URL+userLoc, config.AdminToken)
+	checkV1Error(t, resp, body, http.StatusNotFound, "user.not_found")
+	resp, body = get("/v1/psikologs/" + pid + "/wisdom")
+	var points database.PsikologPoint
+	json.Unmarshal([]byte(body), &points)
+	if policy == "anonymize" {
+		resp, body = get(postLoc)
+		var got database.Post
+		if err := json.Unmarshal([]byte(body), &got); err != nil || got.UserId != "" || got.Content != "text" {
+			t.Errorf("anonymized post = %s", body)
+		}
+		resp, body = get(postLoc + "/comments")
+		var comments []database.Comment
+		decodeArray(t, body, &comments)
+		if len(comments) != 1 || comments[0].UserId != 0 {
+			t.Errorf("comments of an anonymized post = %s", body)
+		}
+		if points.Point != "10" {
+			t.Errorf("wisdom after anonymization = %+v", points)
+		}
+	} else {
+		resp, body = get(postLoc)
+		checkV1Error(t, resp, body, http.StatusNotFound, "post.not_found")
+		if points.Point != "0" {
+			t.Errorf("wisdom after deletion = %+v", points)
+		}
+	}
+
+	resp, body = doAdmin(t, "GET", ts.URL+"/v1/admin/audit?user_id="+strconv.Itoa(user.Id), "admin-secret")
+	var entries []database.AuditEntry
+	decodeArray(t, body, &entries)
+	var actions []string
+	for _, a := range entries {
+		actions = append(actions, a.Action)
+	}
+	want := []string{"account.deletion_requested", "account.deletion_cancelled", "account.deletion_requested", "account.deleted"}
+	if len(actions) != len(want) || actions[3] != want[3] || actions[1] != want[1] {
+		t.Errorf("audit log = %q, want %q", actions, want)
+	}
+	resp, body = get("/v1/admin/audit?user_id=" + strconv.Itoa(user.Id))
+	checkV1Error(t, resp, body, http.StatusUnauthorized, "admin.unauthorized")
+}
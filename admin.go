@@ -0,0 +1,29 @@
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// isAdmin report whether r carries the admin token as a bearer token.
+// Moderation and the other admin routes are closed while no token is set.
+func isAdmin(r *http.Request) bool {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && config.AdminToken != "" &&
+		subtle.ConstantTimeCompare([]byte(token), []byte(config.AdminToken)) == 1
+}
+
+// requireAdmin answer 401 unless r is from an admin.
+func requireAdmin(tag string, r *http.Request) *apiError {
+	if isAdmin(r) {
+		return nil
+	}
+	return &apiError{
+		Tag:   tag,
+		Error: errors.New("admin token missing or invalid"),
+		ID:    "admin.unauthorized",
+		Code:  http.StatusUnauthorized,
+	}
+}
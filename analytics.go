@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/csv"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pyk/relieve/database"
+)
+
+// The analytics routes answer the questions we used to run ad-hoc SQL for.
+// Both take ?from=YYYY-MM-DD&to=YYYY-MM-DD, the last 30 days by default
+// with to included, and ?format=json (the default) or csv.
+
+// maxAnalyticsDays is the longest range analytics are computed for.
+const maxAnalyticsDays = 731
+
+// dateLayout is the layout of the from and to parameters.
+const dateLayout = "2006-01-02"
+
+// oneOf return the value of the query parameter name, def when it is not
+// given, or a 422 when it is not one of values.
+func oneOf(tag string, r *http.Request, name, def string, values ...string) (string, *apiError) {
+	v := r.FormValue(name)
+	if v == "" {
+		return def, nil
+	}
+	for _, allowed := range values {
+		if v == allowed {
+			return v, nil
+		}
+	}
+	return "", invalidRequest(tag, []fieldError{{Field: name, ID: "field.one_of", arg: strings.Join(values, ", ")}})
+}
+
+// dateRange return the range of the from and to parameters as from
+// (included) and the day after to (excluded), in UTC.
+func dateRange(tag string, r *http.Request) (time.Time, time.Time, *apiError) {
+	now := time.Now().UTC()
+	to := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	from := to.AddDate(0, 0, -29)
+	var errs []fieldError
+	for _, p := range []struct {
+		name string
+		t    *time.Time
+	}{{"from", &from}, {"to", &to}} {
+		v := r.FormValue(p.name)
+		if v == "" {
+			continue
+		}
+		t, err := time.Parse(dateLayout, v)
+		if err != nil {
+			errs = append(errs, fieldError{Field: p.name, ID: "field.date"})
+			continue
+		}
+		*p.t = t
+	}
+	if errs == nil && (from.After(to) || to.Sub(from) >= maxAnalyticsDays*24*time.Hour) {
+		errs = append(errs, fieldError{Field: "from", ID: "field.date_range", arg: strconv.Itoa(maxAnalyticsDays)})
+	}
+	if errs != nil {
+		return from, to, invalidRequest(tag, errs)
+	}
+	return from, to.AddDate(0, 0, 1), nil
+}
+
+// writeCSV answer rows under header as a CSV attachment named name.csv.
+func writeCSV(w http.ResponseWriter, name string, header []string, rows [][]string) *apiError {
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+name+`.csv"`)
+	w.WriteHeader(http.StatusOK)
+	cw := csv.NewWriter(w)
+	cw.Write(header)
+	cw.WriteAll(rows)
+	return nil
+}
+
+// GET /v1/admin/analytics/activity?interval=day|week the posts, comments,
+// reports and new users of every day or week.
+func v1AnalyticsActivity(w http.ResponseWriter, r *http.Request) *apiError {
+	tag := "v1AnalyticsActivity"
+	if apiErr := requireAdmin(tag, r); apiErr != nil {
+		return apiErr
+	}
+	interval, apiErr := oneOf(tag, r, "interval", database.IntervalDay, database.IntervalDay, database.IntervalWeek)
+	if apiErr != nil {
+		return apiErr
+	}
+	format, apiErr := oneOf(tag, r, "format", "json", "json", "csv")
+	if apiErr != nil {
+		return apiErr
+	}
+	from, to, apiErr := dateRange(tag, r)
+	if apiErr != nil {
+		return apiErr
+	}
+	points, err := db.GetActivity(r.Context(), interval, from, to)
+	if err != nil {
+		return storeError(tag+" db.GetActivity", err, "")
+	}
+	if format == "json" {
+		return writeJSON(tag, w, http.StatusOK, points)
+	}
+	rows := make([][]string, len(points))
+	for i, p := range points {
+		rows[i] = []string{p.Period.Format(dateLayout), strconv.Itoa(p.Posts), strconv.Itoa(p.Comments), strconv.Itoa(p.Reports), strconv.Itoa(p.Users)}
+	}
+	return writeCSV(w, "activity", []string{"period", "posts", "comments", "reports", "users"}, rows)
+}
+
+// GET /v1/admin/analytics/posts?by=category|gender|age|profession the posts
+// of each group, with their authors, comments and reports.
+func v1AnalyticsPosts(w http.ResponseWriter, r *http.Request) *apiError {
+	tag := "v1AnalyticsPosts"
+	if apiErr := requireAdmin(tag, r); apiErr != nil {
+		return apiErr
+	}
+	by, apiErr := oneOf(tag, r, "by", database.ByCategory, database.ByCategory, database.ByGender, database.ByAge, database.ByProfession)
+	if apiErr != nil {
+		return apiErr
+	}
+	format, apiErr := oneOf(tag, r, "format", "json", "json", "csv")
+	if apiErr != nil {
+		return apiErr
+	}
+	from, to, apiErr := dateRange(tag, r)
+	if apiErr != nil {
+		return apiErr
+	}
+	breakdown, err := db.GetPostBreakdown(r.Context(), by, from, to)
+	if err != nil {
+		return storeError(tag+" db.GetPostBreakdown", err, "")
+	}
+	if format == "json" {
+		return writeJSON(tag, w, http.StatusOK, breakdown)
+	}
+	rows := make([][]string, len(breakdown))
+	for i, b := range breakdown {
+		rows[i] = []string{b.Group, strconv.Itoa(b.Posts), strconv.Itoa(b.Authors), strconv.Itoa(b.Comments), strconv.Itoa(b.Reports)}
+	}
+	return writeCSV(w, "posts-by-"+by, []string{by, "posts", "authors", "comments", "reports"}, rows)
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pyk/relieve/database"
+)
+
+func TestAnalytics(t *testing.T) {
+	ts := newTestServer(t)
+	defer func(c Config) { config = c }(config)
+	config.AdminToken = "admin-secret"
+	admin := func(path string) (*http.Response, string) {
+		return doAdmin(t, "GET", ts.URL+path, "admin-secret")
+	}
+
+	var psikolog database.Psikolog
+	resp, body := postJSON(t, ts.URL+"/v1/psikologs", database.Psikolog{Email: uniqueEmail("stats"), Name: "Dr. Stats"})
+	checkCreated(t, resp, body, &psikolog)
+	for i, u := range []database.User{
+		{Email: uniqueEmail("stats"), Age: 17, Gender: "Female", Profession: "student"},
+		{Email: uniqueEmail("stats"), Age: 30, Gender: " female", Profession: "Nurse"},
+		{Email: uniqueEmail("stats"), Age: 0},
+	} {
+		var user database.User
+		resp, body = postJSON(t, ts.URL+"/v1/users", u)
+		checkCreated(t, resp, body, &user)
+		var post database.Post
+		category := []string{"family", "work", "work"}[i]
+		resp, body = postJSON(t, ts.URL+"/v1/posts", database.Post{UserId: strconv.Itoa(user.Id), PsikologId: strconv.Itoa(psikolog.Id), Title: "t", Category: category, Content: "c"})
+		checkCreated(t, resp, body, &post)
+		resp, body = postJSON(t, ts.URL+"/v1/reports", database.Report{UserId: user.Id, PostId: post.Id})
+		checkStatus(t, resp, body, http.StatusCreated)
+	}
+
+	today := time.Now().UTC().Format(dateLayout)
+	resp, body = admin("/v1/admin/analytics/activity?from=" + today + "&to=" + today)
+	checkStatus(t, resp, body, http.StatusOK)
+	var points []database.ActivityPoint
+	decodeArray(t, body, &points)
+	if len(points) != 1 || points[0].Posts != 3 || points[0].Reports != 3 || points[0].Users != 3 || points[0].Comments != 0 {
+		t.Errorf("activity of today = %s", body)
+	}
+	resp, body = admin("/v1/admin/analytics/activity?interval=week")
+	decodeArray(t, body, &points)
+	if n := len(points); n < 5 || n > 6 || points[0].Period.Weekday() != time.Monday || points[n-1].Posts != 3 {
+		t.Errorf("weekly activity = %s", body)
+	}
+	resp, body = admin("/v1/admin/analytics/activity?format=csv&from=" + today)
+	checkStatus(t, resp, body, http.StatusOK)
+	if want := "period,posts,comments,reports,users\n" + today + ",3,0,3,3\n"; body != want || !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/csv") {
+		t.Errorf("activity as CSV = %q", body)
+	}
+
+	for by, want := range map[string]map[string]int{
+		"category":   {"work": 2, "family": 1},
+		"gender":     {"female": 2, "unknown": 1},
+		"age":        {"13-17": 1, "25-34": 1, "unknown": 1},
+		"profession": {"student": 1, "nurse": 1, "unknown": 1},
+	} {
+		resp, body = admin("/v1/admin/analytics/posts?by=" + by)
+		checkStatus(t, resp, body, http.StatusOK)
+		var breakdown []database.PostBreakdown
+		decodeArray(t, body, &breakdown)
+		got := make(map[string]int)
+		for _, b := range breakdown {
+			got[b.Group] = b.Posts
+			if b.Reports != b.Posts || b.Authors != b.Posts {
+				t.Errorf("by %s: group %+v", by, b)
+			}
+		}
+		if len(got) != len(want) {
+			t.Errorf("by %s = %s", by, body)
+		}
+		for group, n := range want {
+			if got[group] != n {
+				t.Errorf("by %s: %s has %d posts, want %d", by, group, got[group], n)
+			}
+		}
+	}
+	resp, body = admin("/v1/admin/analytics/posts?by=category&format=csv")
+	if !strings.HasPrefix(body, "category,posts,authors,comments,reports\nwork,2,2,0,2\n") {
+		t.Errorf("posts by category as CSV = %q", body)
+	}
+
+	for _, path := range []string{
+		"activity?interval=month",
+		"activity?from=yesterday",
+		"activity?from=2024-01-02&to=2024-01-01",
+		"activity?from=2020-01-01&to=2024-01-01",
+		"activity?format=xml",
+		"posts?by=city",
+	} {
+		resp, body = admin("/v1/admin/analytics/" + path)
+		checkV1Error(t, resp, body, http.StatusUnprocessableEntity, "request.invalid")
+		var e struct {
+			Fields []fieldError `json:"fields"`
+		}
+		if json.Unmarshal([]byte(body), &e); len(e.Fields) != 1 {
+			t.Errorf("%s: %s", path, body)
+		}
+	}
+	resp, body = do(t, "GET", ts.URL+"/v1/admin/analytics/posts", "", "")
+	checkV1Error(t, resp, body, http.StatusUnauthorized, "admin.unauthorized")
+}
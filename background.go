@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+)
+
+// jobs run background work that must finish before the process exits.
+// Jobs receive a context that is cancelled when shutdown starts; they
+// should stop taking new work and return once what they hold is flushed.
+type jobs struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func newJobs() *jobs {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &jobs{ctx: ctx, cancel: cancel}
+}
+
+// Go run fn in its own goroutine.
+func (j *jobs) Go(name string, fn func(ctx context.Context)) {
+	j.wg.Add(1)
+	go func() {
+		defer j.wg.Done()
+		fn(j.ctx)
+		slog.Info("background job stopped", "job", name)
+	}()
+}
+
+// Shutdown cancel every job and wait for them to return, or for ctx to be
+// done, whichever comes first.
+func (j *jobs) Shutdown(ctx context.Context) error {
+	j.cancel()
+	done := make(chan struct{})
+	go func() {
+		j.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/pyk/relieve/database"
+)
+
+// pgStore return the postgres store behind db, or nil in demo mode.
+func pgStore() *database.Database {
+	store := db
+	if c, ok := store.(*database.Cache); ok {
+		store = c.Store
+	}
+	pg, _ := store.(*database.Database)
+	return pg
+}
+
+// setupCache wrap store with the read cache configured in config. With
+// postgres, writes are published to the other dynos and, for the postgres
+// backend, local misses are served from the shared cache table.
+func setupCache(store database.Store) *database.Cache {
+	c := database.NewCache(store, config.CacheSize, config.CacheTTL)
+	register(cacheStatsCollector{c})
+	pg, ok := store.(*database.Database)
+	if !ok {
+		return c
+	}
+	c.Publish = pg.NotifyInvalidation
+	if config.CacheBackend == "postgres" {
+		c.Shared = pg
+		bg.Go("purge cache", func(ctx context.Context) { purgeCache(ctx, pg) })
+	}
+	bg.Go("cache invalidations", func(ctx context.Context) {
+		for ctx.Err() == nil {
+			if err := database.ListenInvalidations(ctx, config.DatabaseURL, c); err != nil {
+				slog.Error("Listen cache invalidations", "err", err)
+				// missed invalidations expire with the TTL
+				select {
+				case <-ctx.Done():
+				case <-time.After(config.CacheTTL):
+				}
+			}
+		}
+	})
+	return c
+}
+
+// purgeCache delete expired entries of the shared cache every hour.
+func purgeCache(ctx context.Context, pg *database.Database) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Hour):
+		}
+		if !pg.Prepared() {
+			continue
+		}
+		n, err := pg.PurgeCache(ctx)
+		if err != nil {
+			slog.Error("Purge cache", "err", err)
+			continue
+		}
+		slog.Info("Purged cache", "entries", n)
+	}
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/pyk/relieve/database"
+)
+
+// Posts and psikolog specializations name their category by its slug.
+// The category rule accepts the active categories, any category while the
+// categories table is empty. Category values are turned into slugs before
+// they are saved, so "Family " is family.
+
+// categoryTTL is how long the active categories are kept before being read
+// again; changes made on another dyno show up within it.
+const categoryTTL = time.Minute
+
+var slugSeparators = regexp.MustCompile(`[^a-z0-9]+`)
+
+// slugify lowercase s and join its runs of letters and digits with
+// dashes, "Love & Dating!" is love-dating.
+func slugify(s string) string {
+	return strings.Trim(slugSeparators.ReplaceAllString(strings.ToLower(s), "-"), "-")
+}
+
+// categorySlug turn free text into a slug, as the category_slug function
+// of migration 0011 does.
+func categorySlug(s string) string {
+	if slug := slugify(s); slug != "" {
+		return slug
+	}
+	return "other"
+}
+
+// normalizeCategory turn the category of p into its slug. The category
+// rule check the slug already, so a valid post stays valid.
+func normalizeCategory(p *database.Post) {
+	p.Category = categorySlug(p.Category)
+}
+
+// normalizeSpecializations turn the specializations of p into slugs,
+// without duplicates.
+func normalizeSpecializations(p *database.Psikolog) {
+	var slugs []string
+	seen := map[string]bool{}
+	for _, s := range p.Specializations {
+		if slug := categorySlug(s); !seen[slug] {
+			seen[slug] = true
+			slugs = append(slugs, slug)
+		}
+	}
+	p.Specializations = slugs
+}
+
+// categoryCache hold the slugs of the active categories of a store.
+type categoryCache struct {
+	mu     sync.Mutex
+	store  database.Store
+	slugs  []string
+	loaded time.Time
+}
+
+// activeCategories is read by the category rule.
+var activeCategories = &categoryCache{}
+
+// get return the active slugs of the store db. When they cannot be read the
+// last ones known are kept.
+func (c *categoryCache) get() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.store == db && time.Since(c.loaded) < categoryTTL {
+		return c.slugs
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	categories, err := db.GetCategories(ctx)
+	if err != nil {
+		if !errors.Is(err, database.ErrNotReady) {
+			slog.Error("Load categories", "err", err)
+		}
+		return c.slugs
+	}
+	var slugs []string
+	for _, cat := range categories {
+		if cat.Active {
+			slugs = append(slugs, cat.Slug)
+		}
+	}
+	c.store, c.slugs, c.loaded = db, slugs, time.Now()
+	return slugs
+}
+
+// invalidate read the categories again on the next get.
+func (c *categoryCache) invalidate() {
+	c.mu.Lock()
+	c.loaded = time.Time{}
+	c.mu.Unlock()
+}
+
+// localizedCategory is a category in the language of the request.
+type localizedCategory struct {
+	Slug        string `json:"category_slug"`
+	Name        string `json:"category_name"`
+	Description string `json:"category_description"`
+	Icon        string `json:"category_icon"`
+}
+
+// localize pick the text of lang in texts, else the one of defaultLanguage,
+// else def.
+func localize(texts map[string]string, lang, def string) string {
+	if t, ok := texts[lang]; ok && t != "" {
+		return t
+	}
+	if t, ok := texts[defaultLanguage]; ok && t != "" {
+		return t
+	}
+	return def
+}
+
+// categoriesHandler list the active categories in the language of the
+// request, in their order.
+// GET /v0/categories
+func categoriesHandler(w http.ResponseWriter, r *http.Request) *apiError {
+	if r.Method != "GET" {
+		http.Redirect(w, r, config.RedirectURL, 302)
+		return nil
+	}
+	categories, err := db.GetCategories(r.Context())
+	if err != nil {
+		return storeError("categoriesHandler db.GetCategories", err, "")
+	}
+	lang := language(r)
+	w.Header().Set("Content-Language", lang)
+	w.Header().Add("Vary", "Accept-Language")
+	list := []localizedCategory{}
+	for _, c := range categories {
+		if !c.Active {
+			continue
+		}
+		list = append(list, localizedCategory{
+			Slug:        c.Slug,
+			Name:        localize(c.Names, lang, c.Slug),
+			Description: localize(c.Descriptions, lang, ""),
+			Icon:        c.Icon,
+		})
+	}
+	return writeJSON("categoriesHandler", w, http.StatusOK, list)
+}
+
+// GET /v1/admin/categories every category, inactive ones too.
+func v1ListCategories(w http.ResponseWriter, r *http.Request) *apiError {
+	tag := "v1ListCategories"
+	if apiErr := requireAdmin(tag, r); apiErr != nil {
+		return apiErr
+	}
+	categories, err := db.GetCategories(r.Context())
+	if err != nil {
+		return storeError(tag+" db.GetCategories", err, "")
+	}
+	return writeJSON(tag, w, http.StatusOK, categories)
+}
+
+// POST /v1/admin/categories
+func v1CreateCategory(w http.ResponseWriter, r *http.Request) *apiError {
+	tag := "v1CreateCategory"
+	if apiErr := requireAdmin(tag, r); apiErr != nil {
+		return apiErr
+	}
+	c := database.Category{Active: true}
+	if apiErr := decodeJSON(tag+" Decode", r, &c); apiErr != nil {
+		return apiErr
+	}
+	if err := db.InsertCategory(r.Context(), &c); err != nil {
+		return storeError(tag+" db.InsertCategory", err, "")
+	}
+	activeCategories.invalidate()
+	return created(tag, w, "/v1/admin/categories/"+c.Slug, c)
+}
+
+// GET /v1/admin/categories/{slug}
+func v1GetCategory(w http.ResponseWriter, r *http.Request) *apiError {
+	tag := "v1GetCategory"
+	if apiErr := requireAdmin(tag, r); apiErr != nil {
+		return apiErr
+	}
+	c, err := db.GetCategory(r.Context(), mux.Vars(r)["slug"])
+	if err != nil {
+		return storeError(tag+" db.GetCategory", err, "category.not_found")
+	}
+	return writeJSON(tag, w, http.StatusOK, c)
+}
+
+// PATCH /v1/admin/categories/{slug} change the names, descriptions, icon,
+// position or active flag of a category; the slug stays.
+func v1UpdateCategory(w http.ResponseWriter, r *http.Request) *apiError {
+	tag := "v1UpdateCategory"
+	if apiErr := requireAdmin(tag, r); apiErr != nil {
+		return apiErr
+	}
+	slug := mux.Vars(r)["slug"]
+	c, err := db.GetCategory(r.Context(), slug)
+	if err != nil {
+		return storeError(tag+" db.GetCategory", err, "category.not_found")
+	}
+	if apiErr := decodeJSON(tag+" Decode", r, &c); apiErr != nil {
+		return apiErr
+	}
+	c.Slug = slug
+	if err := db.UpdateCategory(r.Context(), &c); err != nil {
+		return storeError(tag+" db.UpdateCategory", err, "category.not_found")
+	}
+	activeCategories.invalidate()
+	return writeJSON(tag, w, http.StatusOK, c)
+}
+
+// DELETE /v1/admin/categories/{slug}?replace=SLUG delete a category. Its
+// posts move to the category replace, which is required while posts use
+// it; deactivate the category to keep them where they are.
+func v1DeleteCategory(w http.ResponseWriter, r *http.Request) *apiError {
+	tag := "v1DeleteCategory"
+	if apiErr := requireAdmin(tag, r); apiErr != nil {
+		return apiErr
+	}
+	slug, replace := mux.Vars(r)["slug"], r.FormValue("replace")
+	if replace != "" {
+		if _, err := db.GetCategory(r.Context(), replace); replace == slug || errors.Is(err, database.ErrNotFound) {
+			return invalidRequest(tag, []fieldError{{Field: "replace", ID: "field.category_unknown"}})
+		} else if err != nil {
+			return storeError(tag+" db.GetCategory", err, "")
+		}
+	}
+	if err := db.DeleteCategory(r.Context(), slug, replace); err != nil {
+		return storeError(tag+" db.DeleteCategory", err, "category.not_found")
+	}
+	activeCategories.invalidate()
+	slog.InfoContext(r.Context(), "Category deleted", "category", slug, "replace", replace)
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
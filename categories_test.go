@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"testing"
+
+	"github.com/pyk/relieve/database"
+)
+
+// withCategories add active categories to the store for the length of the
+// test.
+func withCategories(t *testing.T, slugs ...string) {
+	t.Helper()
+	ctx := context.Background()
+	for _, slug := range slugs {
+		c := database.Category{Slug: slug, Names: map[string]string{"en": slug}, Active: true}
+		if err := db.InsertCategory(ctx, &c); err != nil {
+			t.Fatal(err)
+		}
+	}
+	activeCategories.invalidate()
+	t.Cleanup(func() {
+		for _, slug := range slugs {
+			db.DeleteCategory(ctx, slug, "")
+		}
+		activeCategories.invalidate()
+	})
+}
+
+func TestCategorySlug(t *testing.T) {
+	for in, want := range map[string]string{
+		"family":         "family",
+		"Family ":        "family",
+		"Love & Dating!": "love-dating",
+		"--exam--":       "exam",
+		"Kerja/Karir 2":  "kerja-karir-2",
+		"???":            "other",
+	} {
+		if got := categorySlug(in); got != want {
+			t.Errorf("categorySlug(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestCategories(t *testing.T) {
+	ts := newTestServer(t)
+	if _, ok := db.(*database.Memory); !ok {
+		// the categories would apply to the posts of the other tests
+		t.Skip("needs the in-memory store")
+	}
+	defer func(c Config) { config = c }(config)
+	config.AdminToken = "admin-secret"
+	defer activeCategories.invalidate()
+	f := newFixtures(t)
+
+	// any category is accepted while there is none
+	newPost := func(category string) (*http.Response, string) {
+		return postJSON(t, ts.URL+"/v1/posts", database.Post{
+			UserId: strconv.Itoa(f.user.Id), PsikologId: strconv.Itoa(f.psikolog.Id), Title: "t", Category: category, Content: "c",
+		})
+	}
+	resp, body := newPost("anything")
+	checkStatus(t, resp, body, http.StatusCreated)
+
+	resp, body = doAdmin(t, "POST", ts.URL+"/v1/admin/categories", config.AdminToken, database.Category{
+		Slug:         "family",
+		Names:        map[string]string{"en": "Family", "id": "Keluarga"},
+		Descriptions: map[string]string{"en": "Parents, siblings and home"},
+		Position:     20,
+		Active:       true,
+	})
+	checkCreated(t, resp, body, &database.Category{})
+	resp, body = doAdmin(t, "POST", ts.URL+"/v1/admin/categories", config.AdminToken, database.Category{Slug: "study", Names: map[string]string{"en": "Study"}, Position: 10, Active: true})
+	checkCreated(t, resp, body, &database.Category{})
+	resp, body = doAdmin(t, "POST", ts.URL+"/v1/admin/categories", config.AdminToken, database.Category{Slug: "study", Names: map[string]string{"en": "Study"}})
+	checkV1Error(t, resp, body, http.StatusConflict, "category.exists")
+	resp, body = doAdmin(t, "POST", ts.URL+"/v1/admin/categories", config.AdminToken, database.Category{Slug: "Love Life", Names: map[string]string{"en": "Love"}})
+	checkV1Error(t, resp, body, http.StatusUnprocessableEntity, "request.invalid")
+	resp, body = postJSON(t, ts.URL+"/v1/admin/categories", database.Category{Slug: "love", Names: map[string]string{"en": "Love"}})
+	checkV1Error(t, resp, body, http.StatusUnauthorized, "admin.unauthorized")
+
+	listed := func(lang string) []localizedCategory {
+		t.Helper()
+		req, err := http.NewRequest("GET", ts.URL+"/v0/categories", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Accept-Language", lang)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		b, _ := ioutil.ReadAll(resp.Body)
+		checkStatus(t, resp, string(b), http.StatusOK)
+		var list []localizedCategory
+		decodeArray(t, string(b), &list)
+		return list
+	}
+	if got := listed("id"); len(got) != 2 || got[0].Slug != "study" || got[0].Name != "Study" || got[1].Name != "Keluarga" || got[1].Description != "Parents, siblings and home" {
+		t.Errorf("GET /v0/categories in id = %+v", got)
+	}
+
+	// categories are turned into slugs, unknown ones refused
+	resp, body = newPost(" Family")
+	var p database.Post
+	checkCreated(t, resp, body, &p)
+	if p.Category != "family" {
+		t.Errorf("category = %q, want family", p.Category)
+	}
+	resp, body = newPost("gossip")
+	checkV1Error(t, resp, body, http.StatusUnprocessableEntity, "request.invalid")
+
+	// an inactive category is not listed nor accepted
+	resp, body = doAdmin(t, "PATCH", ts.URL+"/v1/admin/categories/study", config.AdminToken, map[string]bool{"category_active": false})
+	checkStatus(t, resp, body, http.StatusOK)
+	if got := listed("en"); len(got) != 1 || got[0].Slug != "family" {
+		t.Errorf("GET /v0/categories = %+v", got)
+	}
+	resp, body = newPost("study")
+	checkV1Error(t, resp, body, http.StatusUnprocessableEntity, "request.invalid")
+	resp, body = doAdmin(t, "PATCH", ts.URL+"/v1/admin/categories/exam", config.AdminToken, map[string]bool{"category_active": false})
+	checkV1Error(t, resp, body, http.StatusNotFound, "category.not_found")
+
+	// the posts of a deleted category need another one
+	resp, body = doAdmin(t, "DELETE", ts.URL+"/v1/admin/categories/family", config.AdminToken)
+	checkV1Error(t, resp, body, http.StatusConflict, "category.in_use")
+	resp, body = doAdmin(t, "DELETE", ts.URL+"/v1/admin/categories/family?replace=exam", config.AdminToken)
+	checkV1Error(t, resp, body, http.StatusUnprocessableEntity, "request.invalid")
+	resp, body = doAdmin(t, "DELETE", ts.URL+"/v1/admin/categories/family?replace=study", config.AdminToken)
+	checkStatus(t, resp, body, http.StatusNoContent)
+	if moved, err := db.GetPost(context.Background(), p.Id); err != nil || moved.Category != "study" {
+		t.Errorf("post after delete = %+v, %v", moved, err)
+	}
+	resp, body = doAdmin(t, "GET", ts.URL+"/v1/admin/categories/family", config.AdminToken)
+	checkV1Error(t, resp, body, http.StatusNotFound, "category.not_found")
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// cachePolicies is the Cache-Control of the successful reads of each
+// route. Profiles and wisdom totals are the same for everyone and can sit
+// in a CDN; anything about a user (posts, comments, reports) is private and
+// revalidated on every use. Routes without a policy get neither ETag nor
+// Cache-Control.
+var cachePolicies = map[string]string{
+	"/v0/reliever":              "public, max-age=300",
+	"/v1/psikologs/{id}":        "public, max-age=300",
+	"/v0/wisdom":                "public, max-age=60",
+	"/v1/psikologs/{id}/wisdom": "public, max-age=60",
+	"/v0/categories":            "public, max-age=300",
+	"/v1/tags":                  "public, max-age=60",
+	"/v1/tags/trending":         "public, max-age=300",
+
+	"/v0/checkwisdom":                     "private, no-cache",
+	"/v1/psikologs/{id}/wisdom/{user_id}": "private, no-cache",
+	"/v1/psikologs/{id}/response-times":   "private, no-cache",
+	"/v0/posts":                           "private, no-cache",
+	"/v1/posts":                           "private, no-cache",
+	"/v1/posts/{id}":                      "private, no-cache",
+	"/v1/posts/{id}/comments":             "private, no-cache",
+	"/v1/posts/{id}/revisions":            "private, no-cache",
+	"/v1/psikologs/{id}/notifications":    "private, no-cache",
+	"/v1/pool":                            "private, no-cache",
+	"/v1/tags/{tag}/posts":                "private, no-cache",
+	"/v1/comments/{id}":                   "private, no-cache",
+	"/v1/comments/{id}/revisions":         "private, no-cache",
+	"/v1/users/{id}":                      "private, no-cache",
+	"/v1/users/{id}/deletion":             "private, no-cache",
+	"/v1/users/{id}/exports/{export_id}":  "private, no-cache",
+	"/v1/reports/{id}":                    "private, no-cache",
+
+	"/openapi.json": "public, max-age=3600",
+	"/docs":         "public, max-age=3600",
+}
+
+// bufferedWriter hold the response so its ETag can be computed before
+// anything is sent.
+type bufferedWriter struct {
+	http.ResponseWriter
+	status int
+	buf    bytes.Buffer
+}
+
+func (w *bufferedWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+}
+
+func (w *bufferedWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.buf.Write(b)
+}
+
+// weakETag return a weak validator of body.
+func weakETag(body []byte) string {
+	sum := sha256.Sum256(body)
+	return `W/"` + hex.EncodeToString(sum[:12]) + `"`
+}
+
+// etagMatch report whether the If-None-Match header value matches etag,
+// using the weak comparison.
+func etagMatch(header, etag string) bool {
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, t := range strings.Split(header, ",") {
+		t = strings.TrimSpace(t)
+		if t == "*" || strings.TrimPrefix(t, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// notModified evaluate the conditional headers of r against the validators
+// of the response. If-None-Match wins over If-Modified-Since.
+func notModified(r *http.Request, h http.Header) bool {
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		return etagMatch(inm, h.Get("ETag"))
+	}
+	ims, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil {
+		return false
+	}
+	lm, err := http.ParseTime(h.Get("Last-Modified"))
+	if err != nil {
+		return false
+	}
+	return !lm.After(ims)
+}
+
+// conditional add the ETag and the Cache-Control of route to successful
+// GET and HEAD responses, and answer 304 Not Modified when the client
+// already has them. A handler may set its own ETag (e.g. from a row
+// version) or Last-Modified; otherwise the ETag is computed from the body.
+func conditional(route string, h http.Handler) http.Handler {
+	policy, ok := cachePolicies[route]
+	if !ok {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" && r.Method != "HEAD" {
+			h.ServeHTTP(w, r)
+			return
+		}
+		bw := &bufferedWriter{ResponseWriter: w}
+		h.ServeHTTP(bw, r)
+		if bw.status == 0 {
+			bw.status = http.StatusOK
+		}
+		if bw.status != http.StatusOK {
+			w.WriteHeader(bw.status)
+			w.Write(bw.buf.Bytes())
+			return
+		}
+
+		header := w.Header()
+		if header.Get("ETag") == "" {
+			header.Set("ETag", weakETag(bw.buf.Bytes()))
+		}
+		header.Set("Cache-Control", policy)
+		if notModified(r, header) {
+			// a 304 has the validators but neither body nor its headers
+			header.Del("Content-Type")
+			header.Del("Content-Length")
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write(bw.buf.Bytes())
+	})
+}
+
+// setLastModified set the Last-Modified header from t, if known.
+func setLastModified(w http.ResponseWriter, t *time.Time) {
+	if t != nil && !t.IsZero() {
+		w.Header().Set("Last-Modified", t.UTC().Format(http.TimeFormat))
+	}
+}
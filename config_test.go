@@ -0,0 +1,98 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadConfigPrecedence(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "relieve.toml")
+	err := ioutil.WriteFile(file, []byte(`
+port = "5000" # overridden by PORT
+[database]
+url = "postgres://relieve@localhost/relieve"
+max_open_conns = 20
+[http]
+read_timeout = "3s"
+cors_origins = ["https://app.example.com", "https://admin.example.com"]
+`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	env := map[string]string{
+		"RELIEVE_CONFIG":            file,
+		"PORT":                      "6000",
+		"RELIEVE_DB_MAX_IDLE_CONNS": "7",
+	}
+	c, err := loadConfig([]string{"-read-timeout", "4s"}, func(k string) string { return env[k] })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Port != "6000" {
+		t.Errorf("Port = %q, want env value", c.Port)
+	}
+	if c.DatabaseURL != "postgres://relieve@localhost/relieve" || c.MaxOpenConns != 20 {
+		t.Errorf("database settings from file not applied: %+v", c)
+	}
+	if c.MaxIdleConns != 7 {
+		t.Errorf("MaxIdleConns = %d, want 7", c.MaxIdleConns)
+	}
+	if c.ReadTimeout != 4*time.Second {
+		t.Errorf("ReadTimeout = %v, want flag value", c.ReadTimeout)
+	}
+	if len(c.CORSOrigins) != 2 || c.CORSOrigins[1] != "https://admin.example.com" {
+		t.Errorf("CORSOrigins = %v", c.CORSOrigins)
+	}
+	if c.WriteTimeout != defaultConfig().WriteTimeout {
+		t.Errorf("WriteTimeout = %v, want default", c.WriteTimeout)
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	tests := []struct {
+		args []string
+		env  map[string]string
+		want string
+	}{
+		{nil, nil, "database.url (DATABASE_URL) is required"},
+		{[]string{"-demo", "-port", "http"}, nil, `port "http" must be a number`},
+		{[]string{"-demo"}, map[string]string{"RELIEVE_READ_TIMEOUT": "soon"}, "RELIEVE_READ_TIMEOUT: \"soon\" is not a duration"},
+		{[]string{"-demo", "-db-max-open-conns", "2", "-db-max-idle-conns", "3"}, nil, "must not exceed"},
+		{[]string{"-demo", "-cors-origins", "example.com"}, nil, "must be * or scheme://host"},
+		{[]string{"-database-url", "mysql://localhost"}, nil, "must be a postgres:// URL"},
+		{[]string{"-demo", "-smtp-url", "smtp://mail.example.com"}, nil, "http.public_url \"\" must be an absolute http(s) URL"},
+	}
+	for _, tt := range tests {
+		_, err := loadConfig(tt.args, func(k string) string { return tt.env[k] })
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("loadConfig(%v, %v) error = %v, want %q", tt.args, tt.env, err, tt.want)
+		}
+	}
+}
+
+func TestParseRateLimits(t *testing.T) {
+	env := map[string]string{"RELIEVE_RATE_LIMITS": "/v0/posts=5/m, /v0/wisdom=100/24h"}
+	c, err := loadConfig([]string{"-demo"}, func(k string) string { return env[k] })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(c.RateLimits) != 2 {
+		t.Fatalf("RateLimits = %v", c.RateLimits)
+	}
+	if l := c.RateLimits["/v0/posts"]; l.Burst != 5 || l.Rate != 5.0/60 {
+		t.Errorf("/v0/posts limit = %+v", l)
+	}
+	if l := c.RateLimits["/v0/wisdom"]; l.Burst != 100 || l.Rate != 100.0/86400 {
+		t.Errorf("/v0/wisdom limit = %+v", l)
+	}
+
+	for _, bad := range []string{"/v0/posts", "/v0/posts=0/h", "/v0/posts=5/fortnight"} {
+		env["RELIEVE_RATE_LIMITS"] = bad
+		if _, err := loadConfig([]string{"-demo"}, func(k string) string { return env[k] }); err == nil {
+			t.Errorf("RELIEVE_RATE_LIMITS=%s: no error", bad)
+		}
+	}
+}
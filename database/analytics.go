@@ -0,0 +1,240 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Analytics count what happened between from (included) and to (excluded),
+// by UTC day or by week starting on Monday. Deleted posts and comments
+// still count, they happened; users and reports created before they were
+// dated do not.
+
+// Analytics intervals.
+const (
+	IntervalDay  = "day"
+	IntervalWeek = "week"
+)
+
+// Dimensions posts are broken down by. Gender and profession are the ones
+// of the author, lower-cased; age is bucketed.
+const (
+	ByCategory   = "category"
+	ByGender     = "gender"
+	ByAge        = "age"
+	ByProfession = "profession"
+)
+
+// ActivityPoint is what happened during the day or week starting at
+// Period.
+type ActivityPoint struct {
+	Period   time.Time `json:"period"`
+	Posts    int       `json:"posts"`
+	Comments int       `json:"comments"`
+	Reports  int       `json:"reports"`
+	Users    int       `json:"users"`
+}
+
+// PostBreakdown is the posts of one group, with the comments and reports
+// they got.
+type PostBreakdown struct {
+	Group    string `json:"group"`
+	Posts    int    `json:"posts"`
+	Authors  int    `json:"authors"`
+	Comments int    `json:"comments"`
+	Reports  int    `json:"reports"`
+}
+
+// ResponseTime is when a post got its first comment, nil while it has
+// none. Hidden comments do not count as a reply.
+type ResponseTime struct {
+	PostId       int
+	PsikologId   int
+	PostDate     time.Time
+	FirstComment *time.Time
+}
+
+// truncate return the start of the day or week of t, in UTC.
+func truncate(t time.Time, interval string) time.Time {
+	t = t.UTC()
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	if interval == IntervalWeek {
+		// Monday is the first day, as date_trunc('week') has it
+		return day.AddDate(0, 0, -(int(day.Weekday())+6)%7)
+	}
+	return day
+}
+
+// periods return a point for every day or week between from and to.
+func periods(interval string, from, to time.Time) ([]ActivityPoint, map[time.Time]int) {
+	var points []ActivityPoint
+	index := make(map[time.Time]int)
+	step := 1
+	if interval == IntervalWeek {
+		step = 7
+	}
+	for p := truncate(from, interval); p.Before(to); p = p.AddDate(0, 0, step) {
+		index[p] = len(points)
+		points = append(points, ActivityPoint{Period: p})
+	}
+	return points, index
+}
+
+// ageBucket mirror the age buckets of breakdownGroups.
+func ageBucket(age int) string {
+	switch {
+	case age <= 0:
+		return "unknown"
+	case age < 18:
+		return "13-17"
+	case age < 25:
+		return "18-24"
+	case age < 35:
+		return "25-34"
+	case age < 45:
+		return "35-44"
+	case age < 55:
+		return "45-54"
+	}
+	return "55+"
+}
+
+// breakdownGroups is the group expression of each dimension.
+var breakdownGroups = map[string]string{
+	ByCategory:   `COALESCE(NULLIF(post_category, ''), 'unknown')`,
+	ByGender:     `COALESCE(NULLIF(lower(trim(user_gender)), ''), 'unknown')`,
+	ByProfession: `COALESCE(NULLIF(lower(trim(user_profession)), ''), 'unknown')`,
+	ByAge: `CASE WHEN COALESCE(user_age, 0) <= 0 THEN 'unknown' WHEN user_age < 18 THEN '13-17'
+    WHEN user_age < 25 THEN '18-24' WHEN user_age < 35 THEN '25-34' WHEN user_age < 45 THEN '35-44'
+    WHEN user_age < 55 THEN '45-54' ELSE '55+' END`,
+}
+
+// sortBreakdown order the groups by posts, most first.
+func sortBreakdown(rows []PostBreakdown) {
+	sort.Slice(rows, func(i, j int) bool {
+		if rows[i].Posts != rows[j].Posts {
+			return rows[i].Posts > rows[j].Posts
+		}
+		return rows[i].Group < rows[j].Group
+	})
+}
+
+// GetActivity count the posts, comments, reports and new users of every
+// day or week between from and to. Every period is listed, empty or not.
+func (db *Database) GetActivity(ctx context.Context, interval string, from, to time.Time) ([]ActivityPoint, error) {
+	defer db.observe("GetActivity", time.Now())
+	if !db.Prepared() {
+		return nil, ErrNotReady
+	}
+	if interval != IntervalDay && interval != IntervalWeek {
+		return nil, &Error{Kind: ErrInvalidInput, Err: fmt.Errorf("unknown interval %q", interval)}
+	}
+	points, index := periods(interval, from, to)
+	counts := []struct {
+		column string
+		table  string
+		count  func(p *ActivityPoint) *int
+	}{
+		{"post_date", "posts", func(p *ActivityPoint) *int { return &p.Posts }},
+		{"comment_date", "comments", func(p *ActivityPoint) *int { return &p.Comments }},
+		{"report_date", "reports", func(p *ActivityPoint) *int { return &p.Reports }},
+		{"user_created_at", "users", func(p *ActivityPoint) *int { return &p.Users }},
+	}
+	for _, c := range counts {
+		q := fmt.Sprintf(`SELECT date_trunc($1, %[1]s AT TIME ZONE 'UTC'), count(*) FROM %[2]s WHERE %[1]s >= $2 AND %[1]s < $3 GROUP BY 1`, c.column, c.table)
+		rows, err := db.Conn.QueryContext(ctx, q, interval, from, to)
+		if err != nil {
+			return nil, translate(err)
+		}
+		for rows.Next() {
+			var period time.Time
+			var n int
+			if err := rows.Scan(&period, &n); err != nil {
+				rows.Close()
+				return nil, translate(err)
+			}
+			// timestamp without time zone comes back as UTC already
+			if i, ok := index[period.UTC()]; ok {
+				*c.count(&points[i]) = n
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, translate(err)
+		}
+	}
+	return points, nil
+}
+
+// GetPostBreakdown count the posts written between from and to by group of
+// the dimension by, with their authors, comments and reports.
+func (db *Database) GetPostBreakdown(ctx context.Context, by string, from, to time.Time) ([]PostBreakdown, error) {
+	defer db.observe("GetPostBreakdown", time.Now())
+	if !db.Prepared() {
+		return nil, ErrNotReady
+	}
+	group, ok := breakdownGroups[by]
+	if !ok {
+		return nil, &Error{Kind: ErrInvalidInput, Err: fmt.Errorf("unknown dimension %q", by)}
+	}
+	q := `
+SELECT ` + group + ` AS grp, count(*), count(DISTINCT post_user_id), COALESCE(sum(c.n), 0), COALESCE(sum(r.n), 0)
+FROM posts
+LEFT JOIN users ON user_id=post_user_id
+LEFT JOIN (SELECT comment_post_id, count(*) AS n FROM comments GROUP BY 1) c ON c.comment_post_id=post_id
+LEFT JOIN (SELECT report_post_id, count(*) AS n FROM reports GROUP BY 1) r ON r.report_post_id=post_id
+WHERE post_date >= $1 AND post_date < $2
+GROUP BY grp`
+	rows, err := db.Conn.QueryContext(ctx, q, from, to)
+	if err != nil {
+		return nil, translate(err)
+	}
+	defer rows.Close()
+	breakdown := []PostBreakdown{}
+	for rows.Next() {
+		var b PostBreakdown
+		if err := rows.Scan(&b.Group, &b.Posts, &b.Authors, &b.Comments, &b.Reports); err != nil {
+			return nil, translate(err)
+		}
+		breakdown = append(breakdown, b)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, translate(err)
+	}
+	sortBreakdown(breakdown)
+	return breakdown, nil
+}
+
+// GetResponseTimes get the response time of the posts written between from
+// and to, of psikologID or of every psikolog when it is 0. Deleted posts
+// are left out.
+func (db *Database) GetResponseTimes(ctx context.Context, psikologID int, from, to time.Time) ([]ResponseTime, error) {
+	defer db.observe("GetResponseTimes", time.Now())
+	if !db.Prepared() {
+		return nil, ErrNotReady
+	}
+	rows, err := db.Conn.QueryContext(ctx, `
+SELECT post_id, COALESCE(post_psikolog_id, 0), post_date,
+    (SELECT min(comment_date) FROM comments WHERE comment_post_id=post_id AND comment_deleted_at IS NULL AND comment_hidden_at IS NULL)
+FROM posts
+WHERE post_deleted_at IS NULL AND post_date >= $1 AND post_date < $2 AND ($3::integer = 0 OR post_psikolog_id=$3)
+ORDER BY post_id`, from, to, psikologID)
+	if err != nil {
+		return nil, translate(err)
+	}
+	defer rows.Close()
+	times := []ResponseTime{}
+	for rows.Next() {
+		var rt ResponseTime
+		if err := rows.Scan(&rt.PostId, &rt.PsikologId, &rt.PostDate, &rt.FirstComment); err != nil {
+			return nil, translate(err)
+		}
+		times = append(times, rt)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, translate(err)
+	}
+	return times, nil
+}
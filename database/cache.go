@@ -0,0 +1,337 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// SharedCache is a cache every instance reads, behind the in-process one.
+// Database implements it with an unlogged table.
+type SharedCache interface {
+	CacheGet(ctx context.Context, key string) ([]byte, bool, error)
+	CacheSet(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	CacheDelete(ctx context.Context, keys []string) error
+}
+
+// Cache is a read-through cache in front of a Store. It keeps psikolog
+// profiles, wisdom totals, the posts of a user and the comments of a post
+// in an LRU with a TTL, and in Shared when set. Writes through the Cache
+// invalidate the entries they change, and Publish tells the other
+// instances to do the same (see ListenInvalidations).
+//
+// Values are kept encoded as JSON so callers never share them.
+type Cache struct {
+	Store
+
+	// Shared, if set, is checked on a local miss and filled on a store read.
+	Shared SharedCache
+	// Publish, if set, is called with the keys invalidated by a write.
+	Publish func(ctx context.Context, keys []string) error
+
+	local *lru
+	ttl   time.Duration
+
+	hits, misses atomic.Int64
+}
+
+// NewCache wrap store with a cache of at most size entries, each kept for
+// ttl.
+func NewCache(store Store, size int, ttl time.Duration) *Cache {
+	return &Cache{Store: store, local: newLRU(size, ttl), ttl: ttl}
+}
+
+var _ Store = (*Cache)(nil)
+
+// cacheKey return the key of the record id of kind. IDs are normalized so
+// "007" and "7" share an entry; an ID that is not a number is not cached.
+func cacheKey(kind, id string) (string, bool) {
+	n, err := strconv.Atoi(id)
+	if err != nil {
+		return "", false
+	}
+	return kind + ":" + strconv.Itoa(n), true
+}
+
+// Stats return the number of hits and misses so far.
+func (c *Cache) Stats() (hits, misses int64) {
+	return c.hits.Load(), c.misses.Load()
+}
+
+// Len return the number of entries in the in-process cache.
+func (c *Cache) Len() int {
+	return c.local.len()
+}
+
+// allKeys invalidate every entry, for writes that change too many to list.
+const allKeys = "*"
+
+// Invalidate drop keys from the in-process cache. It is called for the
+// keys published by other instances.
+func (c *Cache) Invalidate(keys ...string) {
+	for _, k := range keys {
+		if k == allKeys {
+			c.Flush()
+			return
+		}
+		c.local.remove(k)
+	}
+}
+
+// Flush drop every entry of the in-process cache, e.g. after invalidations
+// may have been missed.
+func (c *Cache) Flush() {
+	c.local.clear()
+}
+
+// get decode the cached value of key into v.
+func (c *Cache) get(ctx context.Context, key string, v interface{}) bool {
+	b, ok := c.local.get(key)
+	if !ok && c.Shared != nil {
+		var err error
+		b, ok, err = c.Shared.CacheGet(ctx, key)
+		if err != nil {
+			slog.WarnContext(ctx, "Shared cache read failed", "key", key, "err", err)
+			ok = false
+		}
+		if ok {
+			c.local.set(key, b)
+		}
+	}
+	if ok && json.Unmarshal(b, v) == nil {
+		c.hits.Add(1)
+		return true
+	}
+	c.misses.Add(1)
+	return false
+}
+
+func (c *Cache) set(ctx context.Context, key string, v interface{}) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return
+	}
+	c.local.set(key, b)
+	if c.Shared != nil {
+		if err := c.Shared.CacheSet(ctx, key, b, c.ttl); err != nil {
+			slog.WarnContext(ctx, "Shared cache write failed", "key", key, "err", err)
+		}
+	}
+}
+
+// invalidate drop keys here, in Shared and, through Publish, in the other
+// instances. Failures are logged only: the entries expire with the TTL.
+func (c *Cache) invalidate(ctx context.Context, keys ...string) {
+	c.Invalidate(keys...)
+	if c.Shared != nil {
+		if err := c.Shared.CacheDelete(ctx, keys); err != nil {
+			slog.WarnContext(ctx, "Shared cache invalidation failed", "keys", keys, "err", err)
+		}
+	}
+	if c.Publish != nil {
+		if err := c.Publish(ctx, keys); err != nil {
+			slog.WarnContext(ctx, "Publishing cache invalidation failed", "keys", keys, "err", err)
+		}
+	}
+}
+
+func (c *Cache) GetPsikologByID(ctx context.Context, psikolog_id string) (Reliever, error) {
+	var r Reliever
+	key, ok := cacheKey("reliever", psikolog_id)
+	if ok && c.get(ctx, key, &r) {
+		return r, nil
+	}
+	r, err := c.Store.GetPsikologByID(ctx, psikolog_id)
+	if err == nil && ok {
+		c.set(ctx, key, r)
+	}
+	return r, err
+}
+
+func (c *Cache) GetPsikolog(ctx context.Context, id int) (Psikolog, error) {
+	var p Psikolog
+	key, _ := cacheKey("psikolog", strconv.Itoa(id))
+	if c.get(ctx, key, &p) {
+		return p, nil
+	}
+	p, err := c.Store.GetPsikolog(ctx, id)
+	if err == nil {
+		c.set(ctx, key, p)
+	}
+	return p, err
+}
+
+func (c *Cache) UpdatePsikolog(ctx context.Context, p *Psikolog) error {
+	if err := c.Store.UpdatePsikolog(ctx, p); err != nil {
+		return err
+	}
+	id := strconv.Itoa(p.Id)
+	c.invalidate(ctx, "psikolog:"+id, "reliever:"+id)
+	return nil
+}
+
+func (c *Cache) SetPsikologVerified(ctx context.Context, id int, verified bool) error {
+	if err := c.Store.SetPsikologVerified(ctx, id, verified); err != nil {
+		return err
+	}
+	key := strconv.Itoa(id)
+	c.invalidate(ctx, "psikolog:"+key, "reliever:"+key)
+	return nil
+}
+
+func (c *Cache) GetWisdomPointByID(ctx context.Context, id string) (PsikologPoint, error) {
+	var p PsikologPoint
+	key, ok := cacheKey("wisdom", id)
+	if ok && c.get(ctx, key, &p) {
+		// the total is shared, the ID is echoed as asked
+		p.PsikologID = id
+		return p, nil
+	}
+	p, err := c.Store.GetWisdomPointByID(ctx, id)
+	if err == nil && ok {
+		c.set(ctx, key, p)
+	}
+	return p, err
+}
+
+func (c *Cache) InsertWisdomPoint(ctx context.Context, w *WisdomPoint) error {
+	if err := c.Store.InsertWisdomPoint(ctx, w); err != nil {
+		return err
+	}
+	c.invalidate(ctx, "wisdom:"+strconv.Itoa(w.PsikologID))
+	return nil
+}
+
+func (c *Cache) GetAllPostsByUserID(ctx context.Context, userID string) ([]Post, error) {
+	var posts []Post
+	key, ok := cacheKey("posts", userID)
+	if ok && c.get(ctx, key, &posts) {
+		return posts, nil
+	}
+	posts, err := c.Store.GetAllPostsByUserID(ctx, userID)
+	if err == nil && ok {
+		c.set(ctx, key, posts)
+	}
+	return posts, err
+}
+
+func (c *Cache) InsertPost(ctx context.Context, p *Post) error {
+	if err := c.Store.InsertPost(ctx, p); err != nil {
+		return err
+	}
+	if key, ok := cacheKey("posts", p.UserId); ok {
+		c.invalidate(ctx, key)
+	}
+	return nil
+}
+
+func (c *Cache) UpdatePost(ctx context.Context, p *Post) error {
+	if err := c.Store.UpdatePost(ctx, p); err != nil {
+		return err
+	}
+	if key, ok := cacheKey("posts", p.UserId); ok {
+		c.invalidate(ctx, key)
+	}
+	return nil
+}
+
+func (c *Cache) DeletePost(ctx context.Context, p *Post) error {
+	if err := c.Store.DeletePost(ctx, p); err != nil {
+		return err
+	}
+	keys := []string{"comments:" + strconv.Itoa(p.Id)}
+	if key, ok := cacheKey("posts", p.UserId); ok {
+		keys = append(keys, key)
+	}
+	c.invalidate(ctx, keys...)
+	return nil
+}
+
+func (c *Cache) GetCommentsByPostID(ctx context.Context, postID int) ([]Comment, error) {
+	var comments []Comment
+	key, _ := cacheKey("comments", strconv.Itoa(postID))
+	if c.get(ctx, key, &comments) {
+		return comments, nil
+	}
+	comments, err := c.Store.GetCommentsByPostID(ctx, postID)
+	if err == nil {
+		c.set(ctx, key, comments)
+	}
+	return comments, err
+}
+
+func (c *Cache) UpdateComment(ctx context.Context, cm *Comment) error {
+	if err := c.Store.UpdateComment(ctx, cm); err != nil {
+		return err
+	}
+	c.invalidate(ctx, "comments:"+strconv.Itoa(cm.PostId))
+	return nil
+}
+
+func (c *Cache) DeleteComment(ctx context.Context, cm *Comment) error {
+	if err := c.Store.DeleteComment(ctx, cm); err != nil {
+		return err
+	}
+	c.invalidate(ctx, "comments:"+strconv.Itoa(cm.PostId))
+	return nil
+}
+
+func (c *Cache) SetCommentHidden(ctx context.Context, cm *Comment, hidden bool) error {
+	if err := c.Store.SetCommentHidden(ctx, cm, hidden); err != nil {
+		return err
+	}
+	c.invalidate(ctx, "comments:"+strconv.Itoa(cm.PostId))
+	return nil
+}
+
+func (c *Cache) InsertComment(ctx context.Context, cm *Comment) error {
+	if err := c.Store.InsertComment(ctx, cm); err != nil {
+		return err
+	}
+	c.invalidate(ctx, "comments:"+strconv.Itoa(cm.PostId))
+	return nil
+}
+
+func (c *Cache) EscalatePost(ctx context.Context, e *Escalation) error {
+	if err := c.Store.EscalatePost(ctx, e); err != nil {
+		return err
+	}
+	if key, ok := cacheKey("posts", strconv.Itoa(e.UserId)); ok {
+		c.invalidate(ctx, key)
+	}
+	return nil
+}
+
+func (c *Cache) ClaimPost(ctx context.Context, p *Post, psikologID int, reason string) error {
+	if err := c.Store.ClaimPost(ctx, p, psikologID, reason); err != nil {
+		return err
+	}
+	if key, ok := cacheKey("posts", p.UserId); ok {
+		c.invalidate(ctx, key)
+	}
+	return nil
+}
+
+// CompleteUserDeletion invalidate everything: the posts, comments and
+// wisdom totals of the user are spread over many entries.
+func (c *Cache) CompleteUserDeletion(ctx context.Context, userID int, now time.Time, anonymize bool) error {
+	if err := c.Store.CompleteUserDeletion(ctx, userID, now, anonymize); err != nil {
+		return err
+	}
+	c.invalidate(ctx, allKeys)
+	return nil
+}
+
+// DeleteCategory invalidate everything: the posts and psikologs of the
+// category move to its replacement. Updating a category keeps its slug,
+// which is all the cached posts and psikologs hold of it.
+func (c *Cache) DeleteCategory(ctx context.Context, slug, replace string) error {
+	if err := c.Store.DeleteCategory(ctx, slug, replace); err != nil {
+		return err
+	}
+	c.invalidate(ctx, allKeys)
+	return nil
+}
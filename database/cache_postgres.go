@@ -0,0 +1,98 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// invalidationChannel is the LISTEN/NOTIFY channel cache invalidations are
+// published on. The payload is the keys separated by spaces; keys never
+// contain one.
+const invalidationChannel = "relieve_cache"
+
+// CacheGet return the value of key in the shared cache, if not expired.
+func (db *Database) CacheGet(ctx context.Context, key string) ([]byte, bool, error) {
+	var b []byte
+	err := db.Conn.QueryRowContext(ctx, `SELECT cache_value FROM cache_entries WHERE cache_key=$1 AND cache_expires_at > now()`, key).Scan(&b)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return b, true, nil
+}
+
+// CacheSet store value under key in the shared cache for ttl.
+func (db *Database) CacheSet(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	_, err := db.Conn.ExecContext(ctx, `
+INSERT INTO cache_entries (cache_key, cache_value, cache_expires_at)
+VALUES ($1, $2, now() + $3::float8 * interval '1 second')
+ON CONFLICT (cache_key) DO UPDATE SET cache_value = EXCLUDED.cache_value, cache_expires_at = EXCLUDED.cache_expires_at`,
+		key, value, ttl.Seconds())
+	return err
+}
+
+// CacheDelete drop keys from the shared cache, every key for allKeys.
+func (db *Database) CacheDelete(ctx context.Context, keys []string) error {
+	for _, k := range keys {
+		if k == allKeys {
+			_, err := db.Conn.ExecContext(ctx, `DELETE FROM cache_entries`)
+			return err
+		}
+	}
+	_, err := db.Conn.ExecContext(ctx, `DELETE FROM cache_entries WHERE cache_key = ANY(string_to_array($1, ' '))`, strings.Join(keys, " "))
+	return err
+}
+
+// PurgeCache delete the expired entries of the shared cache.
+func (db *Database) PurgeCache(ctx context.Context) (int64, error) {
+	res, err := db.Conn.ExecContext(ctx, `DELETE FROM cache_entries WHERE cache_expires_at <= now()`)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// NotifyInvalidation publish keys to every instance listening with
+// ListenInvalidations.
+func (db *Database) NotifyInvalidation(ctx context.Context, keys []string) error {
+	_, err := db.Conn.ExecContext(ctx, `SELECT pg_notify($1, $2)`, invalidationChannel, strings.Join(keys, " "))
+	return err
+}
+
+// ListenInvalidations drop from c the keys published by NotifyInvalidation
+// until ctx is done. The listener reconnects by itself; as notifications
+// sent while it was disconnected are lost, c is flushed on reconnection.
+func ListenInvalidations(ctx context.Context, url string, c *Cache) error {
+	l := pq.NewListener(url, time.Second, time.Minute, func(ev pq.ListenerEventType, err error) {
+		if err != nil {
+			slog.Warn("Cache invalidation listener", "event", ev, "err", err)
+		}
+	})
+	defer l.Close()
+	if err := l.Listen(invalidationChannel); err != nil {
+		return err
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case n := <-l.Notify:
+			if n == nil {
+				c.Flush()
+				continue
+			}
+			c.Invalidate(strings.Fields(n.Extra)...)
+		case <-time.After(90 * time.Second):
+			// check the connection is alive, Ping reconnects it if not
+			go l.Ping()
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package database
+
+import (
+	"context"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestLRU(t *testing.T) {
+	now := time.Date(2016, 1, 2, 15, 4, 5, 0, time.UTC)
+	c := newLRU(2, time.Minute)
+	c.now = func() time.Time { return now }
+
+	c.set("a", []byte("1"))
+	c.set("b", []byte("2"))
+	c.get("a") // b is now the least recently used
+	c.set("c", []byte("3"))
+	if _, ok := c.get("b"); ok {
+		t.Error("b was not evicted")
+	}
+	if v, ok := c.get("a"); !ok || string(v) != "1" {
+		t.Errorf("get(a) = %q, %v", v, ok)
+	}
+
+	now = now.Add(2 * time.Minute)
+	if _, ok := c.get("c"); ok {
+		t.Error("c did not expire")
+	}
+	if c.len() != 1 {
+		t.Errorf("len = %d, want 1", c.len())
+	}
+}
+
+func TestCache(t *testing.T) {
+	ctx := context.Background()
+	mem := NewMemory()
+	c := NewCache(mem, 100, time.Minute)
+	var published []string
+	c.Publish = func(ctx context.Context, keys []string) error {
+		published = append(published, keys...)
+		return nil
+	}
+
+	u := User{Email: "user@example.com"}
+	p := Psikolog{Email: "psikolog@example.com", Name: "Dr. Tuesday", Specializations: []string{"stress"}}
+	if err := c.InsertUser(ctx, &u); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.InsertPsikolog(ctx, &p); err != nil {
+		t.Fatal(err)
+	}
+
+	// the second read is a hit, and serves a copy
+	for i := 0; i < 2; i++ {
+		got, err := c.GetPsikolog(ctx, p.Id)
+		if err != nil || !reflect.DeepEqual(got, p) {
+			t.Fatalf("GetPsikolog = %+v, %v", got, err)
+		}
+		got.Name = "changed by the caller"
+		got.Specializations[0] = "changed by the caller"
+	}
+	if hits, misses := c.Stats(); hits != 1 || misses != 1 {
+		t.Errorf("hits, misses = %d, %d, want 1, 1", hits, misses)
+	}
+
+	// a write through the cache invalidates the profile
+	p.Name = "Dr. Wednesday"
+	if err := c.UpdatePsikolog(ctx, &p); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := c.GetPsikolog(ctx, p.Id); got.Name != p.Name {
+		t.Errorf("GetPsikolog after update = %+v", got)
+	}
+	id := strconv.Itoa(p.Id)
+	if len(published) != 2 || published[0] != "psikolog:"+id || published[1] != "reliever:"+id {
+		t.Errorf("published %q", published)
+	}
+
+	// wisdom totals, padded IDs share the entry
+	if got, err := c.GetWisdomPointByID(ctx, id); err != nil || got.Point != "0" {
+		t.Fatalf("GetWisdomPointByID = %+v, %v", got, err)
+	}
+	if err := c.InsertWisdomPoint(ctx, &WisdomPoint{UserID: u.Id, PsikologID: p.Id}); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := c.GetWisdomPointByID(ctx, "0"+id); got.Point != "10" || got.PsikologID != "0"+id {
+		t.Errorf("GetWisdomPointByID after insert = %+v", got)
+	}
+
+	// posts and comments
+	uid := strconv.Itoa(u.Id)
+	if posts, _ := c.GetAllPostsByUserID(ctx, uid); len(posts) != 0 {
+		t.Fatalf("posts = %+v", posts)
+	}
+	post := Post{UserId: uid, PsikologId: id, Title: "t", Category: "c", Content: "text"}
+	if err := c.InsertPost(ctx, &post); err != nil {
+		t.Fatal(err)
+	}
+	if posts, _ := c.GetAllPostsByUserID(ctx, uid); len(posts) != 1 {
+		t.Errorf("posts after insert = %+v", posts)
+	}
+	if comments, _ := c.GetCommentsByPostID(ctx, post.Id); len(comments) != 0 {
+		t.Fatalf("comments = %+v", comments)
+	}
+	if err := c.InsertComment(ctx, &Comment{UserId: u.Id, PsikologId: p.Id, PostId: post.Id, Text: "hi"}); err != nil {
+		t.Fatal(err)
+	}
+	if comments, _ := c.GetCommentsByPostID(ctx, post.Id); len(comments) != 1 {
+		t.Errorf("comments after insert = %+v", comments)
+	}
+
+	// the posts of a deleted category move to its replacement
+	if err := mem.InsertCategory(ctx, &Category{Slug: "c", Names: map[string]string{"en": "C"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.DeleteCategory(ctx, "c", "other"); err != nil {
+		t.Fatal(err)
+	}
+	if posts, _ := c.GetAllPostsByUserID(ctx, uid); len(posts) != 1 || posts[0].Category != "other" {
+		t.Errorf("posts after DeleteCategory = %+v", posts)
+	}
+
+	// an invalidation from another instance
+	mem.UpdatePsikolog(ctx, &Psikolog{Id: p.Id, Email: p.Email, Name: "Dr. Thursday"})
+	c.Invalidate("psikolog:" + id)
+	if got, _ := c.GetPsikolog(ctx, p.Id); got.Name != "Dr. Thursday" {
+		t.Errorf("GetPsikolog after Invalidate = %+v", got)
+	}
+}
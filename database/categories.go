@@ -0,0 +1,161 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Category is a category posts are written in. Names and Descriptions are
+// by language; inactive categories are kept for the posts already in them
+// but refused for new ones.
+type Category struct {
+	Slug         string            `json:"category_slug" validate:"required,slug,maxlen=50"`
+	Names        map[string]string `json:"category_names" validate:"required"`
+	Descriptions map[string]string `json:"category_descriptions"`
+	Icon         string            `json:"category_icon" validate:"maxlen=200"`
+	Position     int               `json:"category_position"`
+	Active       bool              `json:"category_active"`
+}
+
+// categoryInUse is the constraint of the ErrConflict of DeleteCategory.
+const categoryInUse = "categories_in_use"
+
+const categoryColumns = `category_slug, category_names::text, category_descriptions::text, category_icon, category_position, category_active`
+
+func scanCategory(row interface{ Scan(...interface{}) error }) (Category, error) {
+	var c Category
+	var names, descriptions string
+	if err := row.Scan(&c.Slug, &names, &descriptions, &c.Icon, &c.Position, &c.Active); err != nil {
+		return c, translate(err)
+	}
+	if err := json.Unmarshal([]byte(names), &c.Names); err != nil {
+		return c, err
+	}
+	return c, json.Unmarshal([]byte(descriptions), &c.Descriptions)
+}
+
+// jsonText encode the names or descriptions of a category for a jsonb
+// column.
+func jsonText(m map[string]string) string {
+	if len(m) == 0 {
+		return "{}"
+	}
+	b, _ := json.Marshal(m)
+	return string(b)
+}
+
+// GetCategories get every category, active or not, by position then slug.
+func (db *Database) GetCategories(ctx context.Context) ([]Category, error) {
+	defer db.observe("GetCategories", time.Now())
+	if !db.Prepared() {
+		return nil, ErrNotReady
+	}
+	rows, err := db.Conn.QueryContext(ctx, `SELECT `+categoryColumns+` FROM categories ORDER BY category_position, category_slug`)
+	if err != nil {
+		return nil, translate(err)
+	}
+	defer rows.Close()
+	categories := []Category{}
+	for rows.Next() {
+		c, err := scanCategory(rows)
+		if err != nil {
+			return nil, err
+		}
+		categories = append(categories, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, translate(err)
+	}
+	return categories, nil
+}
+
+// GetCategory get the category with the given slug.
+func (db *Database) GetCategory(ctx context.Context, slug string) (Category, error) {
+	defer db.observe("GetCategory", time.Now())
+	if !db.Prepared() {
+		return Category{}, ErrNotReady
+	}
+	return scanCategory(db.Conn.QueryRowContext(ctx, `SELECT `+categoryColumns+` FROM categories WHERE category_slug=$1`, slug))
+}
+
+// InsertCategory add c, its slug must be new.
+func (db *Database) InsertCategory(ctx context.Context, c *Category) error {
+	defer db.observe("InsertCategory", time.Now())
+	if !db.Prepared() {
+		return ErrNotReady
+	}
+	_, err := db.Conn.ExecContext(ctx, `INSERT INTO categories(category_slug, category_names, category_descriptions, category_icon, category_position, category_active) VALUES ($1, $2::jsonb, $3::jsonb, $4, $5, $6)`,
+		c.Slug, jsonText(c.Names), jsonText(c.Descriptions), c.Icon, c.Position, c.Active)
+	return translate(err)
+}
+
+// UpdateCategory save every field of c on the category with its slug.
+func (db *Database) UpdateCategory(ctx context.Context, c *Category) error {
+	defer db.observe("UpdateCategory", time.Now())
+	if !db.Prepared() {
+		return ErrNotReady
+	}
+	res, err := db.Conn.ExecContext(ctx, `UPDATE categories SET category_names=$2::jsonb, category_descriptions=$3::jsonb, category_icon=$4, category_position=$5, category_active=$6 WHERE category_slug=$1`,
+		c.Slug, jsonText(c.Names), jsonText(c.Descriptions), c.Icon, c.Position, c.Active)
+	if err != nil {
+		return translate(err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return &Error{Kind: ErrNotFound, Err: sql.ErrNoRows}
+	}
+	return nil
+}
+
+// DeleteCategory delete the category slug. Its posts and the psikologs
+// specialized in it are moved to the category replace; without one, a
+// category still used by posts is an ErrConflict and the specialization is
+// dropped.
+func (db *Database) DeleteCategory(ctx context.Context, slug, replace string) error {
+	defer db.observe("DeleteCategory", time.Now())
+	if !db.Prepared() {
+		return ErrNotReady
+	}
+	tx, err := db.Conn.BeginTx(ctx, nil)
+	if err != nil {
+		return translate(err)
+	}
+	defer tx.Rollback()
+
+	if replace == "" {
+		var used bool
+		if err := tx.QueryRowContext(ctx, `SELECT EXISTS(SELECT 1 FROM posts WHERE post_category=$1)`, slug).Scan(&used); err != nil {
+			return translate(err)
+		}
+		if used {
+			return &Error{Kind: ErrConflict, Constraint: categoryInUse, Err: fmt.Errorf("category %q is used by posts", slug)}
+		}
+	} else if _, err := tx.ExecContext(ctx, `UPDATE posts SET post_category=$2 WHERE post_category=$1`, slug, replace); err != nil {
+		return translate(err)
+	}
+	_, err = tx.ExecContext(ctx, `UPDATE psikologs SET psikolog_specializations=COALESCE((
+    SELECT jsonb_agg(DISTINCT CASE WHEN s=$1 THEN $2 ELSE s END) FILTER (WHERE s<>$1 OR $2<>'')
+    FROM jsonb_array_elements_text(psikolog_specializations) s
+), '[]') WHERE psikolog_specializations ? $1`, slug, replace)
+	if err != nil {
+		return translate(err)
+	}
+	res, err := tx.ExecContext(ctx, `DELETE FROM categories WHERE category_slug=$1`, slug)
+	if err != nil {
+		return translate(err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return &Error{Kind: ErrNotFound, Err: sql.ErrNoRows}
+	}
+	return translate(tx.Commit())
+}
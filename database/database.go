@@ -1,87 +1,232 @@
 package database
 
 import (
+	"context"
 	"database/sql"
+	"encoding/json"
 	"errors"
-	"log"
-	"os"
+	"log/slog"
+	"sync/atomic"
 	"time"
 
 	_ "github.com/lib/pq"
 )
 
-var (
-	DATABASE_URL = os.Getenv("DATABASE_URL")
-)
-
-// statement
-var (
-	stmtInsertUser    *sql.Stmt
-	stmtInsertPost    *sql.Stmt
-	stmtInsertComment *sql.Stmt
-	stmtInsertReport  *sql.Stmt
-
-	stmtGetAllPostsByUserID *sql.Stmt
-
-	stmtGetWisdomPointByID *sql.Stmt
-	stmtCheckWisdomPoint   *sql.Stmt
-	stmtInsertWisdomPoint  *sql.Stmt
-
-	stmtGetPsikologByID *sql.Stmt
-	stmtInsertPsikolog  *sql.Stmt
-)
+// The validate tags on the types below declare the rules a request must
+// pass before it reaches the database; see validate in package main.
 
 type User struct {
 	Id         int    `json:"user_id"`
-	Email      string `json:"user_email"`
-	Gender     string `json:"user_gender"`
-	Age        int    `json:"user_age"`
-	Profession string `json:"user_profession"`
+	Email      string `json:"user_email" validate:"required,email,maxlen=254"`
+	Gender     string `json:"user_gender" validate:"maxlen=20"`
+	Age        int    `json:"user_age" validate:"min=13,max=120"`
+	Profession string `json:"user_profession" validate:"maxlen=100"`
 }
 
 type Psikolog struct {
 	Id       int    `json:"psikolog_id"`
-	Email    string `json:"psikolog_email"`
-	Name     string `json:"psikolog_name"`
-	ImageURL string `json:"psikolog_image_url"`
-	Wisdom   int    `json:"psikolog_wisdom,string"`
-	Bio      string `json:"psikolog_bio"`
+	Email    string `json:"psikolog_email" validate:"required,email,maxlen=254"`
+	Name     string `json:"psikolog_name" validate:"required,maxlen=100"`
+	ImageURL string `json:"psikolog_image_url" validate:"url,maxlen=2048"`
+	Wisdom   int    `json:"psikolog_wisdom,string" validate:"min=0"`
+	Bio      string `json:"psikolog_bio" validate:"maxlen=2000"`
+	// Specializations are the post categories routed to the psikolog,
+	// every category when empty
+	Specializations []string `json:"psikolog_specializations" validate:"maxlen=100,category"`
+	// Available psikologs are routed posts. Verified is set by admins
+	// only, see SetPsikologVerified.
+	Available bool `json:"psikolog_available"`
+	Verified  bool `json:"psikolog_verified"`
+}
+
+// Assignment is a step of the routing of a post: the psikolog it was given
+// to and why.
+type Assignment struct {
+	Id         int        `json:"assignment_id"`
+	PostId     int        `json:"assignment_post_id"`
+	PsikologId int        `json:"assignment_psikolog_id"`
+	Date       *time.Time `json:"assignment_date"`
+	Reason     string     `json:"assignment_reason"`
+}
+
+// RoutingCandidate is a psikolog with the number of their posts still
+// waiting for a first comment.
+type RoutingCandidate struct {
+	Psikolog
+	OpenPosts int
 }
 
 type Post struct {
-	Id          int        `json:"post_id"`
-	UserId      string     `json:"post_user_id"`
-	PsikologId  string     `json:"post_psikolog_id"`
+	Id     int    `json:"post_id"`
+	UserId string `json:"post_user_id" validate:"required,id"`
+	// PsikologId is "auto" when the client let the server route the post
+	PsikologId  string     `json:"post_psikolog_id" validate:"required,assignee"`
 	Date        *time.Time `json:"post_date"`
-	Title       string     `json:"post_title"`
-	Category    string     `json:"post_category"`
-	Content     string     `json:"post_content"`
+	Title       string     `json:"post_title" validate:"required,maxlen=200"`
+	Category    string     `json:"post_category" validate:"required,category"`
+	Content     string     `json:"post_content" validate:"required,maxlen=10000"`
 	ImageURL    string     `json:"post_image_url"`
 	ReportCount int        `json:"post_report_count"`
+	// Tags are normalized by the server, see tagName
+	Tags []string `json:"post_tags" validate:"maxlen=30,tag"`
+	// UpdatedAt is set once the post is edited
+	UpdatedAt *time.Time `json:"post_updated_at,omitempty"`
+	// DeletedAt is only read by GetUserData, other methods skip deleted
+	// posts
+	DeletedAt *time.Time `json:"post_deleted_at,omitempty"`
+}
+
+// UserDeletion is the deletion a user asked for, done once DeleteAfter has
+// passed unless it is cancelled.
+type UserDeletion struct {
+	UserId      int        `json:"user_id"`
+	RequestedAt *time.Time `json:"deletion_requested_at"`
+	DeleteAfter *time.Time `json:"deletion_delete_after"`
+}
+
+// AuditEntry is a line of the audit log.
+type AuditEntry struct {
+	Id      int        `json:"audit_id"`
+	Date    *time.Time `json:"audit_date"`
+	Action  string     `json:"audit_action"`
+	UserId  int        `json:"audit_user_id"`
+	Details string     `json:"audit_details"`
+}
+
+// Export is a personal data export of a user. It is pending until a
+// worker claims it, then done or failed; a done export is downloaded with
+// Token until ExpiresAt.
+type Export struct {
+	Id         int        `json:"export_id"`
+	UserId     int        `json:"export_user_id"`
+	Status     string     `json:"export_status"`
+	CreatedAt  *time.Time `json:"export_created_at"`
+	FinishedAt *time.Time `json:"export_finished_at,omitempty"`
+	ExpiresAt  *time.Time `json:"export_expires_at,omitempty"`
+	Token      string     `json:"-"`
+}
+
+// Export statuses.
+const (
+	ExportPending = "pending"
+	ExportRunning = "running"
+	ExportDone    = "done"
+	ExportFailed  = "failed"
+)
+
+// UserData is everything kept about a user, as exported to them. Posts
+// include the deleted ones not purged yet; Comments are the comments the
+// psikologs wrote on those posts.
+type UserData struct {
+	User     User          `json:"user"`
+	Posts    []Post        `json:"posts"`
+	Comments []Comment     `json:"comments_received"`
+	Reports  []Report      `json:"reports"`
+	Wisdom   []WisdomGiven `json:"wisdom_given"`
+}
+
+// WisdomGiven is a wisdom point a user gave to a psikolog.
+type WisdomGiven struct {
+	PsikologID int `json:"psikolog_id"`
+	Point      int `json:"wisdom_point"`
+}
+
+// PostRevision is a previous version of a post, the one it had until Date.
+type PostRevision struct {
+	Id       int        `json:"revision_id"`
+	PostId   int        `json:"revision_post_id"`
+	Date     *time.Time `json:"revision_date"`
+	Title    string     `json:"revision_title"`
+	Category string     `json:"revision_category"`
+	Content  string     `json:"revision_content"`
 }
 
 type Comment struct {
 	Id         int        `json:"comment_id"`
-	UserId     int        `json:"comment_user_id"`
-	PsikologId int        `json:"comment_psikolog_id"`
-	PostId     int        `json:"comment_post_id"`
-	Text       string     `json:"comment_text"`
+	UserId     int        `json:"comment_user_id" validate:"required,id"`
+	PsikologId int        `json:"comment_psikolog_id" validate:"required,id"`
+	PostId     int        `json:"comment_post_id" validate:"required,id"`
+	Text       string     `json:"comment_text" validate:"required,maxlen=5000"`
 	Date       *time.Time `json:"comment_date"`
+	// UpdatedAt is set once the comment is edited
+	UpdatedAt *time.Time `json:"comment_updated_at,omitempty"`
+}
+
+// CommentRevision is a previous version of a comment, the one it had until
+// Date.
+type CommentRevision struct {
+	Id        int        `json:"revision_id"`
+	CommentId int        `json:"revision_comment_id"`
+	Date      *time.Time `json:"revision_date"`
+	Text      string     `json:"revision_text"`
 }
 
 type Report struct {
 	Id     int `json:"report_id"`
-	UserId int `json:"report_user_id"`
-	PostId int `json:"report_post_id"`
+	UserId int `json:"report_user_id" validate:"required,id"`
+	PostId int `json:"report_post_id" validate:"required,id"`
 }
 
+// Database is the PostgreSQL implementation of Store. Every query it runs
+// is prepared once by Prepare and kept on the struct.
 type Database struct {
 	Conn *sql.DB
+
+	// prepared is set once every statement below is prepared
+	prepared atomic.Bool
+
+	// ObserveQuery, if set, is called with the duration of every statement.
+	ObserveQuery func(statement string, d time.Duration)
+
+	stmtInsertUser    *sql.Stmt
+	stmtInsertPost    *sql.Stmt
+	stmtUpdatePost    *sql.Stmt
+	stmtDeletePost    *sql.Stmt
+	stmtInsertComment *sql.Stmt
+	stmtUpdateComment *sql.Stmt
+	stmtDeleteComment *sql.Stmt
+	stmtHideComment   *sql.Stmt
+	stmtInsertReport  *sql.Stmt
+
+	stmtGetUser             *sql.Stmt
+	stmtScheduleDeletion    *sql.Stmt
+	stmtGetUserDeletion     *sql.Stmt
+	stmtCancelDeletion      *sql.Stmt
+	stmtGetDueDeletions     *sql.Stmt
+	stmtInsertAuditEntry    *sql.Stmt
+	stmtGetAuditEntries     *sql.Stmt
+	stmtInsertExport        *sql.Stmt
+	stmtGetExport           *sql.Stmt
+	stmtClaimExport         *sql.Stmt
+	stmtFinishExport        *sql.Stmt
+	stmtGetExportFile       *sql.Stmt
+	stmtPurgeExports        *sql.Stmt
+	stmtGetPost             *sql.Stmt
+	stmtGetReport           *sql.Stmt
+	stmtGetAllPostsByUserID *sql.Stmt
+	stmtGetComment          *sql.Stmt
+	stmtGetCommentsByPostID *sql.Stmt
+	stmtGetPostRevisions    *sql.Stmt
+	stmtGetCommentRevisions *sql.Stmt
+
+	stmtGetWisdomPointByID *sql.Stmt
+	stmtCheckWisdomPoint   *sql.Stmt
+	stmtInsertWisdomPoint  *sql.Stmt
+
+	stmtGetPsikolog     *sql.Stmt
+	stmtUpdatePsikolog  *sql.Stmt
+	stmtGetPsikologByID *sql.Stmt
+	stmtInsertPsikolog  *sql.Stmt
+	stmtSetVerified     *sql.Stmt
+
+	stmtInsertAssignment     *sql.Stmt
+	stmtGetAssignments       *sql.Stmt
+	stmtGetRoutingCandidates *sql.Stmt
 }
 
 type WisdomPoint struct {
-	UserID     int `json:"user_id"`
-	PsikologID int `json:"psikolog_id"`
+	UserID     int `json:"user_id" validate:"required,id"`
+	PsikologID int `json:"psikolog_id" validate:"required,id"`
 }
 
 type PsikologPoint struct {
@@ -99,170 +244,314 @@ type Reliever struct {
 	Bio  string `json:"reliever_bio"`
 }
 
-func New() (*Database, error) {
-	db, err := sql.Open("postgres", DATABASE_URL)
-	if err != nil {
-		log.Fatalf("Error opening database: %v\n", err)
-	}
-
-	// insert user statement
-	stmtInsertUser, err = db.Prepare(`INSERT INTO users(user_email, user_gender, user_age, user_profession) VALUES ($1,$2,$3,$4)`)
+// Open connect to the postgres database at url. Statements are not
+// prepared yet; every method returns ErrNotReady until Prepare succeeds.
+func Open(url string) (*Database, error) {
+	conn, err := sql.Open("postgres", url)
 	if err != nil {
-		log.Printf("Error insert user statement: %v\n", err)
+		return nil, err
 	}
+	return &Database{Conn: conn}, nil
+}
 
-	// Psikolog/reliever
-	// insert psikolog statement
-	stmtInsertPsikolog, err = db.Prepare(`INSERT INTO psikologs(psikolog_email, psikolog_name, psikolog_image_url, psikolog_wisdom, psikolog_bio) VALUES ($1,$2,$3,$4,$5)`)
+// New connect to the postgres database at url and prepare every statement.
+func New(url string) (*Database, error) {
+	db, err := Open(url)
 	if err != nil {
-		log.Printf("Error insert psikolog statement: %v\n", err)
+		return nil, err
 	}
-	// get psikolog by ID
-	stmtGetPsikologByID, err = db.Prepare(`SELECT psikolog_name, psikolog_bio FROM psikologs WHERE psikolog_id=$1`)
-	if err != nil {
-		log.Printf("Error stmtGetPsikologByID: %v\n", err)
+	if err := db.Prepare(context.Background()); err != nil {
+		db.Close()
+		return nil, err
 	}
+	return db, nil
+}
 
-	// insert post statement
-	stmtInsertPost, err = db.Prepare(`INSERT INTO posts(post_user_id, post_psikolog_id, post_title, post_category, post_content) VALUES ($1,$2,$3,$4,$5)`)
-	if err != nil {
-		log.Printf("Error insert post statement: %v\n", err)
-	}
+// statements list every prepared statement of db with its query.
+func (db *Database) statements() []struct {
+	stmt  **sql.Stmt
+	query string
+} {
+	return []struct {
+		stmt  **sql.Stmt
+		query string
+	}{
+		// users
+		{&db.stmtInsertUser, `INSERT INTO users(user_email, user_gender, user_age, user_profession) VALUES ($1,$2,$3,$4) RETURNING user_id`},
+		{&db.stmtGetUser, `SELECT user_id, user_email, COALESCE(user_gender, ''), COALESCE(user_age, 0), COALESCE(user_profession, '') FROM users WHERE user_id=$1`},
 
-	// insert comment statement
-	stmtInsertComment, err = db.Prepare(`INSERT INTO comments(comment_user_id, comment_psikolog_id, comment_post_id, comment_text) VALUES ($1,$2,$3,$4)`)
-	if err != nil {
-		log.Printf("Error insert comment statement: %v\n", err)
-	}
+		// account deletion, a pending deletion keeps its schedule
+		{&db.stmtScheduleDeletion, `UPDATE users SET user_deletion_requested_at=COALESCE(user_deletion_requested_at, now()), user_delete_after=COALESCE(user_delete_after, $2) WHERE user_id=$1 RETURNING user_deletion_requested_at, user_delete_after`},
+		{&db.stmtGetUserDeletion, `SELECT user_deletion_requested_at, user_delete_after FROM users WHERE user_id=$1 AND user_delete_after IS NOT NULL`},
+		{&db.stmtCancelDeletion, `UPDATE users SET user_deletion_requested_at=NULL, user_delete_after=NULL WHERE user_id=$1 AND user_delete_after IS NOT NULL`},
+		{&db.stmtGetDueDeletions, `SELECT user_id FROM users WHERE user_delete_after <= $1 ORDER BY user_delete_after`},
+		{&db.stmtInsertAuditEntry, `INSERT INTO audit_log(audit_action, audit_user_id, audit_details) VALUES ($1,$2,$3) RETURNING audit_id, audit_date`},
+		{&db.stmtGetAuditEntries, `SELECT audit_id, audit_date, audit_action, COALESCE(audit_user_id, 0), audit_details FROM audit_log WHERE audit_user_id=$1 ORDER BY audit_id`},
 
-	// insert report statement
-	stmtInsertReport, err = db.Prepare(`INSERT INTO reports(report_user_id, report_post_id) VALUES ($1,$2)`)
-	if err != nil {
-		log.Printf("Error insert report statement: %v\n", err)
-	}
+		// data exports, a running export whose worker died is claimed again
+		{&db.stmtInsertExport, `INSERT INTO exports(export_user_id) VALUES ($1) RETURNING export_id, export_status, export_created_at`},
+		{&db.stmtGetExport, `SELECT export_id, export_user_id, export_status, export_created_at, export_finished_at, export_expires_at, COALESCE(export_token, '') FROM exports WHERE export_id=$1`},
+		{&db.stmtClaimExport, `
+UPDATE exports SET export_status='running', export_started_at=now()
+WHERE export_id = (
+    SELECT export_id FROM exports WHERE export_status='pending' OR (export_status='running' AND export_started_at < $1)
+    ORDER BY export_id FOR UPDATE SKIP LOCKED LIMIT 1
+) RETURNING export_id, export_user_id, export_status, export_created_at`},
+		{&db.stmtFinishExport, `UPDATE exports SET export_status=$2, export_finished_at=now(), export_expires_at=$3, export_token=NULLIF($4, ''), export_data=$5 WHERE export_id=$1 AND export_status='running' RETURNING export_finished_at`},
+		{&db.stmtGetExportFile, `SELECT export_id, export_user_id, export_status, export_created_at, export_finished_at, export_expires_at, export_token, export_data FROM exports WHERE export_token=$1 AND export_status='done'`},
+		{&db.stmtPurgeExports, `DELETE FROM exports WHERE export_expires_at < $1`},
 
-	// get all posts by user ID
-	stmtGetAllPostsByUserID, err = db.Prepare(`SELECT * FROM posts WHERE post_user_id=$1`)
-	if err != nil {
-		log.Printf("Error stmtGetAllPostsByUserID: %v\n", err)
+		// Psikolog/reliever
+		{&db.stmtInsertPsikolog, `INSERT INTO psikologs(psikolog_email, psikolog_name, psikolog_image_url, psikolog_wisdom, psikolog_bio, psikolog_specializations, psikolog_available) VALUES ($1,$2,$3,$4,$5,$6::jsonb,$7) RETURNING psikolog_id, psikolog_verified`},
+		{&db.stmtGetPsikologByID, `SELECT psikolog_name, psikolog_bio FROM psikologs WHERE psikolog_id=$1`},
+		{&db.stmtUpdatePsikolog, `UPDATE psikologs SET psikolog_email=$2, psikolog_name=$3, psikolog_image_url=$4, psikolog_bio=$5, psikolog_specializations=$6::jsonb, psikolog_available=$7 WHERE psikolog_id=$1 RETURNING COALESCE(psikolog_wisdom, 0), psikolog_verified`},
+		{&db.stmtGetPsikolog, `SELECT ` + psikologColumns + ` FROM psikologs WHERE psikolog_id=$1`},
+		{&db.stmtSetVerified, `UPDATE psikologs SET psikolog_verified=$2 WHERE psikolog_id=$1`},
+
+		// routing, open posts are the live ones without a visible comment
+		{&db.stmtGetRoutingCandidates, `SELECT ` + psikologColumns + `,
+    (SELECT count(*) FROM posts WHERE post_psikolog_id=psikolog_id AND post_deleted_at IS NULL
+        AND NOT EXISTS(SELECT 1 FROM comments WHERE comment_post_id=post_id AND comment_deleted_at IS NULL AND comment_hidden_at IS NULL))
+FROM psikologs ORDER BY psikolog_id`},
+		{&db.stmtInsertAssignment, `INSERT INTO post_assignments(assignment_post_id, assignment_psikolog_id, assignment_reason) VALUES ($1, NULLIF($2, 0), $3) RETURNING assignment_id, assignment_date`},
+		{&db.stmtGetAssignments, `SELECT assignment_id, assignment_post_id, COALESCE(assignment_psikolog_id, 0), assignment_date, assignment_reason FROM post_assignments WHERE assignment_post_id=$1 ORDER BY assignment_id`},
+
+		// posts, comments & reports; deleted posts are hidden, and cannot be
+		// commented or reported
+		{&db.stmtInsertPost, `INSERT INTO posts(post_user_id, post_psikolog_id, post_title, post_category, post_content, post_tags) VALUES ($1,$2,$3,$4,$5,$6::jsonb) RETURNING post_id, post_date`},
+		{&db.stmtInsertComment, `INSERT INTO comments(comment_user_id, comment_psikolog_id, comment_post_id, comment_text) SELECT $1::integer, $2::integer, $3::integer, $4::text WHERE EXISTS(SELECT 1 FROM posts WHERE post_id=$3 AND post_deleted_at IS NULL) RETURNING comment_id, comment_date`},
+		{&db.stmtInsertReport, `INSERT INTO reports(report_user_id, report_post_id) SELECT $1::integer, $2::integer WHERE EXISTS(SELECT 1 FROM posts WHERE post_id=$2 AND post_deleted_at IS NULL) RETURNING report_id`},
+		{&db.stmtGetAllPostsByUserID, `SELECT ` + postColumns + ` FROM posts WHERE post_user_id=$1 AND post_deleted_at IS NULL ORDER BY post_id`},
+		{&db.stmtGetPost, `SELECT ` + postColumns + ` FROM posts WHERE post_id=$1 AND post_deleted_at IS NULL`},
+		{&db.stmtUpdatePost, `
+WITH previous AS (
+    SELECT post_id, post_title, post_category, post_content FROM posts WHERE post_id=$1 AND post_deleted_at IS NULL FOR UPDATE
+), revision AS (
+    INSERT INTO post_revisions(revision_post_id, revision_title, revision_category, revision_content)
+    SELECT post_id, post_title, post_category, post_content FROM previous
+)
+UPDATE posts SET post_title=$2, post_category=$3, post_content=$4, post_tags=$5::jsonb, post_updated_at=now()
+WHERE post_id IN (SELECT post_id FROM previous) RETURNING post_updated_at`},
+		{&db.stmtDeletePost, `UPDATE posts SET post_deleted_at=now() WHERE post_id=$1 AND post_deleted_at IS NULL`},
+		{&db.stmtGetPostRevisions, `SELECT revision_id, revision_post_id, revision_date, revision_title, revision_category, revision_content FROM post_revisions WHERE revision_post_id=$1 ORDER BY revision_id`},
+
+		// deleted and hidden comments are not read back
+		{&db.stmtGetComment, `SELECT comment_id, COALESCE(comment_user_id, 0), comment_psikolog_id, comment_post_id, comment_text, comment_date, comment_updated_at FROM comments JOIN posts ON post_id=comment_post_id WHERE comment_id=$1 AND post_deleted_at IS NULL AND comment_deleted_at IS NULL AND comment_hidden_at IS NULL`},
+		{&db.stmtGetCommentsByPostID, `SELECT comment_id, COALESCE(comment_user_id, 0), comment_psikolog_id, comment_post_id, comment_text, comment_date, comment_updated_at FROM comments JOIN posts ON post_id=comment_post_id WHERE comment_post_id=$1 AND post_deleted_at IS NULL AND comment_deleted_at IS NULL AND comment_hidden_at IS NULL ORDER BY comment_id`},
+		{&db.stmtUpdateComment, `
+WITH previous AS (
+    SELECT comment_id, comment_text FROM comments WHERE comment_id=$1 AND comment_deleted_at IS NULL AND comment_hidden_at IS NULL FOR UPDATE
+), revision AS (
+    INSERT INTO comment_revisions(revision_comment_id, revision_text)
+    SELECT comment_id, comment_text FROM previous
+)
+UPDATE comments SET comment_text=$2, comment_updated_at=now()
+WHERE comment_id IN (SELECT comment_id FROM previous) RETURNING comment_updated_at`},
+		{&db.stmtDeleteComment, `UPDATE comments SET comment_deleted_at=now() WHERE comment_id=$1 AND comment_deleted_at IS NULL`},
+		{&db.stmtHideComment, `UPDATE comments SET comment_hidden_at=CASE WHEN $2 THEN COALESCE(comment_hidden_at, now()) END WHERE comment_id=$1 AND comment_deleted_at IS NULL RETURNING COALESCE(comment_user_id, 0), comment_psikolog_id, comment_post_id, comment_text, comment_date, comment_updated_at`},
+		{&db.stmtGetCommentRevisions, `SELECT revision_id, revision_comment_id, revision_date, revision_text FROM comment_revisions WHERE revision_comment_id=$1 ORDER BY revision_id`},
+		{&db.stmtGetReport, `SELECT report_id, report_user_id, report_post_id FROM reports WHERE report_id=$1`},
+
+		// wisdom points
+		{&db.stmtGetWisdomPointByID, `SELECT COALESCE(SUM(wisdom_point), 0) FROM wisdom_points WHERE wisdom_psikolog_id=$1`},
+		{&db.stmtCheckWisdomPoint, `SELECT EXISTS(SELECT 1 FROM wisdom_points WHERE wisdom_user_id=$1 AND wisdom_psikolog_id=$2)`},
+		{&db.stmtInsertWisdomPoint, `INSERT INTO wisdom_points(wisdom_user_id,wisdom_psikolog_id) VALUES ($1,$2)`},
 	}
+}
 
-	// get the sum of psikolog wisdom points
-	stmtGetWisdomPointByID, err = db.Prepare(`SELECT SUM(wisdom_point) FROM wisdom_points WHERE wisdom_psikolog_id=$1`)
-	if err != nil {
-		log.Printf("Error get wisdom point by ID statement: %v\n", err)
+// Prepare prepare every statement. It can be called again after a failure,
+// e.g. once the database is reachable or migrated.
+func (db *Database) Prepare(ctx context.Context) error {
+	if db.Prepared() {
+		return nil
 	}
-	// check wisdom point if exists
-	stmtCheckWisdomPoint, err = db.Prepare(`SELECT EXISTS(SELECT 1 FROM wisdom_points WHERE wisdom_user_id=$1 AND wisdom_psikolog_id=$2)`)
-	if err != nil {
-		log.Printf("Error check wisdom point statement: %v\n", err)
+	stmts := db.statements()
+	for i, s := range stmts {
+		stmt, err := db.Conn.PrepareContext(ctx, s.query)
+		if err != nil {
+			slog.ErrorContext(ctx, "Error preparing statement", "query", s.query, "err", err)
+			for _, prev := range stmts[:i] {
+				(*prev.stmt).Close()
+				*prev.stmt = nil
+			}
+			return err
+		}
+		*s.stmt = stmt
 	}
-	stmtInsertWisdomPoint, err = db.Prepare(`INSERT INTO wisdom_points(wisdom_user_id,wisdom_psikolog_id) VALUES ($1,$2)`)
-	if err != nil {
-		log.Printf("Error insert wisdom point statement: %v\n", err)
+	db.prepared.Store(true)
+	return nil
+}
+
+// Prepared report whether Prepare has succeeded.
+func (db *Database) Prepared() bool {
+	return db.prepared.Load()
+}
+
+// Ping check the database can be reached.
+func (db *Database) Ping(ctx context.Context) error {
+	return db.Conn.PingContext(ctx)
+}
+
+// observe report the time spent in statement since start.
+func (db *Database) observe(statement string, start time.Time) {
+	if db.ObserveQuery != nil {
+		db.ObserveQuery(statement, time.Since(start))
 	}
-	return &Database{db}, nil
 }
 
-func (db *Database) InsertUser(user *User) error {
+// Close releases the prepared statements and the connection pool.
+func (db *Database) Close() error {
+	if db.prepared.Swap(false) {
+		for _, s := range db.statements() {
+			(*s.stmt).Close()
+		}
+	}
+	return db.Conn.Close()
+}
+func (db *Database) InsertUser(ctx context.Context, user *User) error {
+	defer db.observe("InsertUser", time.Now())
+	if !db.Prepared() {
+		return ErrNotReady
+	}
 	// insert data to database
-	_, err := stmtInsertUser.Exec(user.Email, user.Gender, user.Age, user.Profession)
+	err := db.stmtInsertUser.QueryRowContext(ctx, user.Email, user.Gender, user.Age, user.Profession).Scan(&user.Id)
 	if err != nil {
-		log.Printf("Error while insert data to users table: %v\n", err)
-		return err
+		slog.ErrorContext(ctx, "Error while insert data to users table", "err", err)
+		return translate(err)
 	}
 	return nil
 }
 
-func (db *Database) InsertPsikolog(p *Psikolog) error {
+func (db *Database) InsertPsikolog(ctx context.Context, p *Psikolog) error {
+	defer db.observe("InsertPsikolog", time.Now())
+	if !db.Prepared() {
+		return ErrNotReady
+	}
 	// insert data to database
-	_, err := stmtInsertPsikolog.Exec(p.Email, p.Name, p.ImageURL, p.Wisdom, p.Bio)
+	err := db.stmtInsertPsikolog.QueryRowContext(ctx, p.Email, p.Name, p.ImageURL, p.Wisdom, p.Bio, specializations(p), p.Available).Scan(&p.Id, &p.Verified)
 	if err != nil {
-		log.Printf("Error while insert data to psikologs table: %v\n", err)
-		return err
+		slog.ErrorContext(ctx, "Error while insert data to psikologs table", "err", err)
+		return translate(err)
 	}
 	return nil
 }
 
-func (db *Database) InsertPost(p *Post) error {
+func (db *Database) InsertPost(ctx context.Context, p *Post) error {
+	defer db.observe("InsertPost", time.Now())
+	if !db.Prepared() {
+		return ErrNotReady
+	}
 	// insert data to database
-	_, err := stmtInsertPost.Exec(p.UserId, p.PsikologId, p.Title, p.Category, p.Content)
+	err := db.stmtInsertPost.QueryRowContext(ctx, p.UserId, p.PsikologId, p.Title, p.Category, p.Content, tags(p)).Scan(&p.Id, &p.Date)
 	if err != nil {
-		log.Printf("Error while insert data to posts table: %v\n", err)
-		return err
+		slog.ErrorContext(ctx, "Error while insert data to posts table", "err", err)
+		return translate(err)
 	}
 	return nil
 }
 
-func (db *Database) InsertComment(c *Comment) error {
+func (db *Database) InsertComment(ctx context.Context, c *Comment) error {
+	defer db.observe("InsertComment", time.Now())
+	if !db.Prepared() {
+		return ErrNotReady
+	}
 	// insert data to database
-	_, err := stmtInsertComment.Exec(c.UserId, c.PsikologId, c.PostId, c.Text)
+	err := db.stmtInsertComment.QueryRowContext(ctx, c.UserId, c.PsikologId, c.PostId, c.Text).Scan(&c.Id, &c.Date)
+	if errors.Is(err, sql.ErrNoRows) {
+		return missingPost("comments_comment_post_id_fkey")
+	}
 	if err != nil {
-		log.Printf("Error while insert data to comments table: %v\n", err)
-		return err
+		slog.ErrorContext(ctx, "Error while insert data to comments table", "err", err)
+		return translate(err)
 	}
 	return nil
 }
 
-func (db *Database) InsertReport(r *Report) error {
+func (db *Database) InsertReport(ctx context.Context, r *Report) error {
+	defer db.observe("InsertReport", time.Now())
+	if !db.Prepared() {
+		return ErrNotReady
+	}
 	// insert data to database
-	_, err := stmtInsertReport.Exec(r.UserId, r.PostId)
+	err := db.stmtInsertReport.QueryRowContext(ctx, r.UserId, r.PostId).Scan(&r.Id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return missingPost("reports_report_post_id_fkey")
+	}
 	if err != nil {
-		log.Printf("Error while insert data to reports table: %v\n", err)
-		return err
+		slog.ErrorContext(ctx, "Error while insert data to reports table", "err", err)
+		return translate(err)
 	}
 	return nil
 }
 
-func (db *Database) GetAllPostsByUserID(userID string) ([]Post, error) {
+func (db *Database) GetAllPostsByUserID(ctx context.Context, userID string) ([]Post, error) {
+	defer db.observe("GetAllPostsByUserID", time.Now())
+	if !db.Prepared() {
+		return nil, ErrNotReady
+	}
 	var posts []Post
-	rows, err := stmtGetAllPostsByUserID.Query(userID)
-	defer rows.Close()
+	rows, err := db.stmtGetAllPostsByUserID.QueryContext(ctx, userID)
 	if err != nil {
-		log.Printf("Error while get data all posts: %v\n", err)
-		return nil, err
-	}
-	if !rows.Next() {
-		return nil, errors.New("cannot found a list of posts")
+		slog.ErrorContext(ctx, "Error while get data all posts", "err", err)
+		return nil, translate(err)
 	}
+	defer rows.Close()
 	for rows.Next() {
 		var post Post
-		err := rows.Scan(&post.Id, &post.UserId, &post.PsikologId, &post.Date, &post.Title, &post.Category, &post.Content, &post.ImageURL, &post.ReportCount)
+		err := scanPost(rows, &post)
 		if err != nil {
-			log.Printf("Error while iterating a rows on get all posts: %v\n", err)
-			return nil, err
+			slog.ErrorContext(ctx, "Error while iterating a rows on get all posts", "err", err)
+			return nil, translate(err)
 		}
 		posts = append(posts, post)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, translate(err)
+	}
+	if len(posts) == 0 {
+		return nil, ErrPostsNotFound
+	}
 	return posts, nil
 }
 
 // GetWisdomPointByID return the sum of psikolog wisdom point
-func (db *Database) GetWisdomPointByID(id string) (PsikologPoint, error) {
+func (db *Database) GetWisdomPointByID(ctx context.Context, id string) (PsikologPoint, error) {
+	defer db.observe("GetWisdomPointByID", time.Now())
+	if !db.Prepared() {
+		return PsikologPoint{}, ErrNotReady
+	}
 	var p PsikologPoint
 	p.PsikologID = id
-	err := stmtGetWisdomPointByID.QueryRow(id).Scan(&p.Point)
+	err := db.stmtGetWisdomPointByID.QueryRowContext(ctx, id).Scan(&p.Point)
 	if err != nil {
-		return p, err
+		return p, translate(err)
 	}
 	return p, nil
 }
 
 // CheckWisdomPoint return a WisdomPointStatus if record exists.
-func (db *Database) CheckWisdomPoint(user_id string, psikolog_id string) (WisdomPointStatus, error) {
+func (db *Database) CheckWisdomPoint(ctx context.Context, user_id string, psikolog_id string) (WisdomPointStatus, error) {
+	defer db.observe("CheckWisdomPoint", time.Now())
+	if !db.Prepared() {
+		return WisdomPointStatus{}, ErrNotReady
+	}
 	var ws WisdomPointStatus
-	err := stmtCheckWisdomPoint.QueryRow(user_id, psikolog_id).Scan(&ws.Status)
+	err := db.stmtCheckWisdomPoint.QueryRowContext(ctx, user_id, psikolog_id).Scan(&ws.Status)
 	if err != nil {
-		return ws, err
+		return ws, translate(err)
 	}
 	return ws, nil
 }
 
 // InsertWisdomPoint insert new records on wisdom_points table.
-func (db *Database) InsertWisdomPoint(w *WisdomPoint) error {
-	_, err := stmtInsertWisdomPoint.Exec(w.UserID, w.PsikologID)
+func (db *Database) InsertWisdomPoint(ctx context.Context, w *WisdomPoint) error {
+	defer db.observe("InsertWisdomPoint", time.Now())
+	if !db.Prepared() {
+		return ErrNotReady
+	}
+	_, err := db.stmtInsertWisdomPoint.ExecContext(ctx, w.UserID, w.PsikologID)
 	if err != nil {
-		return err
+		return translate(err)
 	}
 	return nil
 }
@@ -270,11 +559,714 @@ func (db *Database) InsertWisdomPoint(w *WisdomPoint) error {
 // psikolog
 // GetPsikologByID get psikolog data with specified psikolog_id.
 // return Reliever if only if error is nil.
-func (db *Database) GetPsikologByID(psikolog_id string) (Reliever, error) {
+func (db *Database) GetPsikologByID(ctx context.Context, psikolog_id string) (Reliever, error) {
+	defer db.observe("GetPsikologByID", time.Now())
+	if !db.Prepared() {
+		return Reliever{}, ErrNotReady
+	}
 	var r Reliever
-	err := stmtGetPsikologByID.QueryRow(psikolog_id).Scan(&r.Name, &r.Bio)
+	err := db.stmtGetPsikologByID.QueryRowContext(ctx, psikolog_id).Scan(&r.Name, &r.Bio)
+	if err != nil {
+		return r, translate(err)
+	}
+	return r, nil
+}
+
+// GetUser get the user with the given ID.
+func (db *Database) GetUser(ctx context.Context, id int) (User, error) {
+	defer db.observe("GetUser", time.Now())
+	if !db.Prepared() {
+		return User{}, ErrNotReady
+	}
+	var u User
+	err := db.stmtGetUser.QueryRowContext(ctx, id).Scan(&u.Id, &u.Email, &u.Gender, &u.Age, &u.Profession)
+	if err != nil {
+		return u, translate(err)
+	}
+	return u, nil
+}
+
+// GetPsikolog get the psikolog with the given ID.
+func (db *Database) GetPsikolog(ctx context.Context, id int) (Psikolog, error) {
+	defer db.observe("GetPsikolog", time.Now())
+	if !db.Prepared() {
+		return Psikolog{}, ErrNotReady
+	}
+	var p Psikolog
+	var specs string
+	err := db.stmtGetPsikolog.QueryRowContext(ctx, id).Scan(&p.Id, &p.Email, &p.Name, &p.ImageURL, &p.Wisdom, &p.Bio, &specs, &p.Available, &p.Verified)
+	if err != nil {
+		return p, translate(err)
+	}
+	return p, json.Unmarshal([]byte(specs), &p.Specializations)
+}
+
+// psikologColumns are the columns of a Psikolog, in the order of its
+// fields.
+const psikologColumns = `psikolog_id, psikolog_email, COALESCE(psikolog_name, ''), COALESCE(psikolog_image_url, ''), COALESCE(psikolog_wisdom, 0), COALESCE(psikolog_bio, ''), psikolog_specializations::text, psikolog_available, psikolog_verified`
+
+// specializations encode the specializations of p for a jsonb column.
+func specializations(p *Psikolog) string {
+	if len(p.Specializations) == 0 {
+		return "[]"
+	}
+	b, _ := json.Marshal(p.Specializations)
+	return string(b)
+}
+
+// UpdatePsikolog save every field of p but Wisdom and Verified on the
+// psikolog with its Id, and set p.Wisdom and p.Verified. Wisdom only
+// changes with the wisdom points given.
+func (db *Database) UpdatePsikolog(ctx context.Context, p *Psikolog) error {
+	defer db.observe("UpdatePsikolog", time.Now())
+	if !db.Prepared() {
+		return ErrNotReady
+	}
+	err := db.stmtUpdatePsikolog.QueryRowContext(ctx, p.Id, p.Email, p.Name, p.ImageURL, p.Bio, specializations(p), p.Available).Scan(&p.Wisdom, &p.Verified)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			slog.ErrorContext(ctx, "Error while update data of psikologs table", "err", err)
+		}
+		return translate(err)
+	}
+	return nil
+}
+
+// SetPsikologVerified verify the psikolog with the given ID, or withdraw
+// the verification.
+func (db *Database) SetPsikologVerified(ctx context.Context, id int, verified bool) error {
+	defer db.observe("SetPsikologVerified", time.Now())
+	if !db.Prepared() {
+		return ErrNotReady
+	}
+	res, err := db.stmtSetVerified.ExecContext(ctx, id, verified)
+	if err != nil {
+		return translate(err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return &Error{Kind: ErrNotFound, Err: sql.ErrNoRows}
+	}
+	return nil
+}
+
+// GetRoutingCandidates get every psikolog with their open posts, the live
+// posts without a visible comment yet.
+func (db *Database) GetRoutingCandidates(ctx context.Context) ([]RoutingCandidate, error) {
+	defer db.observe("GetRoutingCandidates", time.Now())
+	if !db.Prepared() {
+		return nil, ErrNotReady
+	}
+	rows, err := db.stmtGetRoutingCandidates.QueryContext(ctx)
+	if err != nil {
+		return nil, translate(err)
+	}
+	defer rows.Close()
+	var candidates []RoutingCandidate
+	for rows.Next() {
+		var c RoutingCandidate
+		var specs string
+		if err := rows.Scan(&c.Id, &c.Email, &c.Name, &c.ImageURL, &c.Wisdom, &c.Bio, &specs, &c.Available, &c.Verified, &c.OpenPosts); err != nil {
+			return nil, translate(err)
+		}
+		if err := json.Unmarshal([]byte(specs), &c.Specializations); err != nil {
+			return nil, err
+		}
+		candidates = append(candidates, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, translate(err)
+	}
+	return candidates, nil
+}
+
+// InsertAssignment record a step of the routing of a post and set the Id
+// and Date of a. A PsikologId of 0 is no psikolog.
+func (db *Database) InsertAssignment(ctx context.Context, a *Assignment) error {
+	defer db.observe("InsertAssignment", time.Now())
+	if !db.Prepared() {
+		return ErrNotReady
+	}
+	err := db.stmtInsertAssignment.QueryRowContext(ctx, a.PostId, a.PsikologId, a.Reason).Scan(&a.Id, &a.Date)
+	if err != nil {
+		return translate(err)
+	}
+	return nil
+}
+
+// GetAssignments get the routing steps of a post, oldest first.
+func (db *Database) GetAssignments(ctx context.Context, postID int) ([]Assignment, error) {
+	defer db.observe("GetAssignments", time.Now())
+	if !db.Prepared() {
+		return nil, ErrNotReady
+	}
+	rows, err := db.stmtGetAssignments.QueryContext(ctx, postID)
+	if err != nil {
+		return nil, translate(err)
+	}
+	defer rows.Close()
+	assignments := []Assignment{}
+	for rows.Next() {
+		var a Assignment
+		if err := rows.Scan(&a.Id, &a.PostId, &a.PsikologId, &a.Date, &a.Reason); err != nil {
+			return nil, translate(err)
+		}
+		assignments = append(assignments, a)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, translate(err)
+	}
+	return assignments, nil
+}
+
+// GetPost get the post with the given ID.
+func (db *Database) GetPost(ctx context.Context, id int) (Post, error) {
+	defer db.observe("GetPost", time.Now())
+	if !db.Prepared() {
+		return Post{}, ErrNotReady
+	}
+	var p Post
+	err := scanPost(db.stmtGetPost.QueryRowContext(ctx, id), &p)
+	return p, translate(err)
+}
+
+// GetComment get the comment with the given ID.
+func (db *Database) GetComment(ctx context.Context, id int) (Comment, error) {
+	defer db.observe("GetComment", time.Now())
+	if !db.Prepared() {
+		return Comment{}, ErrNotReady
+	}
+	var c Comment
+	err := db.stmtGetComment.QueryRowContext(ctx, id).Scan(&c.Id, &c.UserId, &c.PsikologId, &c.PostId, &c.Text, &c.Date, &c.UpdatedAt)
+	if err != nil {
+		return c, translate(err)
+	}
+	return c, nil
+}
+
+// GetCommentsByPostID get the comments of a post, oldest first. A post
+// without comments, or a missing post, has an empty list.
+func (db *Database) GetCommentsByPostID(ctx context.Context, postID int) ([]Comment, error) {
+	defer db.observe("GetCommentsByPostID", time.Now())
+	if !db.Prepared() {
+		return nil, ErrNotReady
+	}
+	rows, err := db.stmtGetCommentsByPostID.QueryContext(ctx, postID)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error while get comments of a post", "err", err)
+		return nil, translate(err)
+	}
+	defer rows.Close()
+	comments := []Comment{}
+	for rows.Next() {
+		var c Comment
+		if err := rows.Scan(&c.Id, &c.UserId, &c.PsikologId, &c.PostId, &c.Text, &c.Date, &c.UpdatedAt); err != nil {
+			return nil, translate(err)
+		}
+		comments = append(comments, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, translate(err)
+	}
+	return comments, nil
+}
+
+// GetReport get the report with the given ID.
+func (db *Database) GetReport(ctx context.Context, id int) (Report, error) {
+	defer db.observe("GetReport", time.Now())
+	if !db.Prepared() {
+		return Report{}, ErrNotReady
+	}
+	var r Report
+	err := db.stmtGetReport.QueryRowContext(ctx, id).Scan(&r.Id, &r.UserId, &r.PostId)
 	if err != nil {
-		return r, err
+		return r, translate(err)
 	}
 	return r, nil
 }
+
+// missingPost is the error of an insert referencing a post that does not
+// exist or is deleted, as the foreign key constraint would report it.
+func missingPost(constraint string) error {
+	return &Error{Kind: ErrInvalidReference, Constraint: constraint, Err: sql.ErrNoRows}
+}
+
+// UpdatePost save the title, category, content and tags of p on the post with
+// its Id, keeping the previous version as a revision. It sets UpdatedAt.
+func (db *Database) UpdatePost(ctx context.Context, p *Post) error {
+	defer db.observe("UpdatePost", time.Now())
+	if !db.Prepared() {
+		return ErrNotReady
+	}
+	err := db.stmtUpdatePost.QueryRowContext(ctx, p.Id, p.Title, p.Category, p.Content, tags(p)).Scan(&p.UpdatedAt)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error while update data of posts table", "err", err)
+		return translate(err)
+	}
+	return nil
+}
+
+// DeletePost soft delete the post with the Id of p. The post is hidden
+// until PurgeDeletedPosts removes it.
+func (db *Database) DeletePost(ctx context.Context, p *Post) error {
+	defer db.observe("DeletePost", time.Now())
+	if !db.Prepared() {
+		return ErrNotReady
+	}
+	res, err := db.stmtDeletePost.ExecContext(ctx, p.Id)
+	if err != nil {
+		return translate(err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return &Error{Kind: ErrNotFound, Err: sql.ErrNoRows}
+	}
+	return nil
+}
+
+// GetPostRevisions get the previous versions of a post, oldest first.
+func (db *Database) GetPostRevisions(ctx context.Context, postID int) ([]PostRevision, error) {
+	defer db.observe("GetPostRevisions", time.Now())
+	if !db.Prepared() {
+		return nil, ErrNotReady
+	}
+	rows, err := db.stmtGetPostRevisions.QueryContext(ctx, postID)
+	if err != nil {
+		return nil, translate(err)
+	}
+	defer rows.Close()
+	revisions := []PostRevision{}
+	for rows.Next() {
+		var r PostRevision
+		if err := rows.Scan(&r.Id, &r.PostId, &r.Date, &r.Title, &r.Category, &r.Content); err != nil {
+			return nil, translate(err)
+		}
+		revisions = append(revisions, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, translate(err)
+	}
+	return revisions, nil
+}
+
+// PurgeDeletedPosts remove for good the posts deleted before before, with
+// their comments, reports and revisions. It returns the number of posts
+// removed.
+func (db *Database) PurgeDeletedPosts(ctx context.Context, before time.Time) (int64, error) {
+	defer db.observe("PurgeDeletedPosts", time.Now())
+	if !db.Prepared() {
+		return 0, ErrNotReady
+	}
+	tx, err := db.Conn.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, translate(err)
+	}
+	defer tx.Rollback()
+	// comments do not cascade, reports and revisions do
+	_, err = tx.ExecContext(ctx, `DELETE FROM comments WHERE comment_post_id IN (SELECT post_id FROM posts WHERE post_deleted_at < $1)`, before)
+	if err != nil {
+		return 0, translate(err)
+	}
+	res, err := tx.ExecContext(ctx, `DELETE FROM posts WHERE post_deleted_at < $1`, before)
+	if err != nil {
+		return 0, translate(err)
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, translate(err)
+	}
+	return res.RowsAffected()
+}
+
+// UpdateComment save the text of c on the comment with its Id, keeping the
+// previous version as a revision. It sets UpdatedAt.
+func (db *Database) UpdateComment(ctx context.Context, c *Comment) error {
+	defer db.observe("UpdateComment", time.Now())
+	if !db.Prepared() {
+		return ErrNotReady
+	}
+	err := db.stmtUpdateComment.QueryRowContext(ctx, c.Id, c.Text).Scan(&c.UpdatedAt)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error while update data of comments table", "err", err)
+		return translate(err)
+	}
+	return nil
+}
+
+// DeleteComment soft delete the comment with the Id of c.
+func (db *Database) DeleteComment(ctx context.Context, c *Comment) error {
+	defer db.observe("DeleteComment", time.Now())
+	if !db.Prepared() {
+		return ErrNotReady
+	}
+	res, err := db.stmtDeleteComment.ExecContext(ctx, c.Id)
+	if err != nil {
+		return translate(err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return &Error{Kind: ErrNotFound, Err: sql.ErrNoRows}
+	}
+	return nil
+}
+
+// SetCommentHidden hide the comment with the Id of c, or restore it, and
+// fill c with the comment. Hiding a hidden comment keeps the time it was
+// first hidden. Deleted comments are not found.
+func (db *Database) SetCommentHidden(ctx context.Context, c *Comment, hidden bool) error {
+	defer db.observe("SetCommentHidden", time.Now())
+	if !db.Prepared() {
+		return ErrNotReady
+	}
+	err := db.stmtHideComment.QueryRowContext(ctx, c.Id, hidden).Scan(&c.UserId, &c.PsikologId, &c.PostId, &c.Text, &c.Date, &c.UpdatedAt)
+	if err != nil {
+		return translate(err)
+	}
+	return nil
+}
+
+// GetCommentRevisions get the previous versions of a comment, oldest first.
+func (db *Database) GetCommentRevisions(ctx context.Context, commentID int) ([]CommentRevision, error) {
+	defer db.observe("GetCommentRevisions", time.Now())
+	if !db.Prepared() {
+		return nil, ErrNotReady
+	}
+	rows, err := db.stmtGetCommentRevisions.QueryContext(ctx, commentID)
+	if err != nil {
+		return nil, translate(err)
+	}
+	defer rows.Close()
+	revisions := []CommentRevision{}
+	for rows.Next() {
+		var r CommentRevision
+		if err := rows.Scan(&r.Id, &r.CommentId, &r.Date, &r.Text); err != nil {
+			return nil, translate(err)
+		}
+		revisions = append(revisions, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, translate(err)
+	}
+	return revisions, nil
+}
+
+// ScheduleUserDeletion schedule the deletion of the user d.UserId after
+// d.DeleteAfter and fill d. A deletion already pending is kept as is.
+func (db *Database) ScheduleUserDeletion(ctx context.Context, d *UserDeletion) error {
+	defer db.observe("ScheduleUserDeletion", time.Now())
+	if !db.Prepared() {
+		return ErrNotReady
+	}
+	err := db.stmtScheduleDeletion.QueryRowContext(ctx, d.UserId, d.DeleteAfter).Scan(&d.RequestedAt, &d.DeleteAfter)
+	if err != nil {
+		return translate(err)
+	}
+	return nil
+}
+
+// GetUserDeletion get the pending deletion of a user.
+func (db *Database) GetUserDeletion(ctx context.Context, userID int) (UserDeletion, error) {
+	defer db.observe("GetUserDeletion", time.Now())
+	if !db.Prepared() {
+		return UserDeletion{}, ErrNotReady
+	}
+	d := UserDeletion{UserId: userID}
+	err := db.stmtGetUserDeletion.QueryRowContext(ctx, userID).Scan(&d.RequestedAt, &d.DeleteAfter)
+	if err != nil {
+		return d, translate(err)
+	}
+	return d, nil
+}
+
+// CancelUserDeletion cancel the pending deletion of a user.
+func (db *Database) CancelUserDeletion(ctx context.Context, userID int) error {
+	defer db.observe("CancelUserDeletion", time.Now())
+	if !db.Prepared() {
+		return ErrNotReady
+	}
+	res, err := db.stmtCancelDeletion.ExecContext(ctx, userID)
+	if err != nil {
+		return translate(err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return &Error{Kind: ErrNotFound, Err: sql.ErrNoRows}
+	}
+	return nil
+}
+
+// GetDueUserDeletions get the users whose deletion is due at now.
+func (db *Database) GetDueUserDeletions(ctx context.Context, now time.Time) ([]int, error) {
+	defer db.observe("GetDueUserDeletions", time.Now())
+	if !db.Prepared() {
+		return nil, ErrNotReady
+	}
+	rows, err := db.stmtGetDueDeletions.QueryContext(ctx, now)
+	if err != nil {
+		return nil, translate(err)
+	}
+	defer rows.Close()
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, translate(err)
+		}
+		ids = append(ids, id)
+	}
+	return ids, translate(rows.Err())
+}
+
+// CompleteUserDeletion delete the user userID if its deletion is due at
+// now, and returns ErrNotFound otherwise, e.g. when it was cancelled.
+//
+// With anonymize, the posts, comments and wisdom points of the user are
+// kept without their user. Otherwise they are deleted, together with the
+// comments of psikologs on the posts, which do not cascade. Reports of
+// the user are deleted either way.
+func (db *Database) CompleteUserDeletion(ctx context.Context, userID int, now time.Time, anonymize bool) error {
+	defer db.observe("CompleteUserDeletion", time.Now())
+	if !db.Prepared() {
+		return ErrNotReady
+	}
+	tx, err := db.Conn.BeginTx(ctx, nil)
+	if err != nil {
+		return translate(err)
+	}
+	defer tx.Rollback()
+
+	var id int
+	err = tx.QueryRowContext(ctx, `SELECT user_id FROM users WHERE user_id=$1 AND user_delete_after <= $2 FOR UPDATE`, userID, now).Scan(&id)
+	if err != nil {
+		return translate(err)
+	}
+	var queries []string
+	if anonymize {
+		queries = []string{
+			`UPDATE posts SET post_user_id=NULL WHERE post_user_id=$1`,
+			`UPDATE comments SET comment_user_id=NULL WHERE comment_user_id=$1`,
+			`UPDATE wisdom_points SET wisdom_user_id=NULL WHERE wisdom_user_id=$1`,
+		}
+	} else {
+		queries = []string{
+			`DELETE FROM comments WHERE comment_post_id IN (SELECT post_id FROM posts WHERE post_user_id=$1)`,
+		}
+	}
+	// posts, comments, wisdom points and reports left cascade
+	queries = append(queries, `DELETE FROM users WHERE user_id=$1`)
+	for _, q := range queries {
+		if _, err := tx.ExecContext(ctx, q, userID); err != nil {
+			slog.ErrorContext(ctx, "Error while deleting a user", "query", q, "err", err)
+			return translate(err)
+		}
+	}
+	return translate(tx.Commit())
+}
+
+// InsertAuditEntry append a to the audit log and set its Id and Date.
+func (db *Database) InsertAuditEntry(ctx context.Context, a *AuditEntry) error {
+	defer db.observe("InsertAuditEntry", time.Now())
+	if !db.Prepared() {
+		return ErrNotReady
+	}
+	err := db.stmtInsertAuditEntry.QueryRowContext(ctx, a.Action, a.UserId, a.Details).Scan(&a.Id, &a.Date)
+	if err != nil {
+		return translate(err)
+	}
+	return nil
+}
+
+// GetAuditEntries get the audit log of a user, oldest first.
+func (db *Database) GetAuditEntries(ctx context.Context, userID int) ([]AuditEntry, error) {
+	defer db.observe("GetAuditEntries", time.Now())
+	if !db.Prepared() {
+		return nil, ErrNotReady
+	}
+	rows, err := db.stmtGetAuditEntries.QueryContext(ctx, userID)
+	if err != nil {
+		return nil, translate(err)
+	}
+	defer rows.Close()
+	entries := []AuditEntry{}
+	for rows.Next() {
+		var a AuditEntry
+		if err := rows.Scan(&a.Id, &a.Date, &a.Action, &a.UserId, &a.Details); err != nil {
+			return nil, translate(err)
+		}
+		entries = append(entries, a)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, translate(err)
+	}
+	return entries, nil
+}
+
+// InsertExport queue an export of the user e.UserId and set its Id, Status
+// and CreatedAt.
+func (db *Database) InsertExport(ctx context.Context, e *Export) error {
+	defer db.observe("InsertExport", time.Now())
+	if !db.Prepared() {
+		return ErrNotReady
+	}
+	err := db.stmtInsertExport.QueryRowContext(ctx, e.UserId).Scan(&e.Id, &e.Status, &e.CreatedAt)
+	if err != nil {
+		return translate(err)
+	}
+	return nil
+}
+
+// GetExport get the export with the given ID.
+func (db *Database) GetExport(ctx context.Context, id int) (Export, error) {
+	defer db.observe("GetExport", time.Now())
+	if !db.Prepared() {
+		return Export{}, ErrNotReady
+	}
+	var e Export
+	err := db.stmtGetExport.QueryRowContext(ctx, id).Scan(&e.Id, &e.UserId, &e.Status, &e.CreatedAt, &e.FinishedAt, &e.ExpiresAt, &e.Token)
+	if err != nil {
+		return e, translate(err)
+	}
+	return e, nil
+}
+
+// ClaimExport mark the oldest pending export running and return it. An
+// export left running since before staleBefore is claimed again. It
+// returns ErrNotFound when there is nothing to do.
+func (db *Database) ClaimExport(ctx context.Context, staleBefore time.Time) (Export, error) {
+	defer db.observe("ClaimExport", time.Now())
+	if !db.Prepared() {
+		return Export{}, ErrNotReady
+	}
+	var e Export
+	err := db.stmtClaimExport.QueryRowContext(ctx, staleBefore).Scan(&e.Id, &e.UserId, &e.Status, &e.CreatedAt)
+	if err != nil {
+		return e, translate(err)
+	}
+	return e, nil
+}
+
+// FinishExport store the outcome of a running export: e.Status, e.Token and
+// e.ExpiresAt, with the file data when it is done. It sets e.FinishedAt.
+func (db *Database) FinishExport(ctx context.Context, e *Export, data []byte) error {
+	defer db.observe("FinishExport", time.Now())
+	if !db.Prepared() {
+		return ErrNotReady
+	}
+	err := db.stmtFinishExport.QueryRowContext(ctx, e.Id, e.Status, e.ExpiresAt, e.Token, data).Scan(&e.FinishedAt)
+	if err != nil {
+		return translate(err)
+	}
+	return nil
+}
+
+// GetExportFile get the done export with the given token and its file.
+// Whether it expired is left to the caller.
+func (db *Database) GetExportFile(ctx context.Context, token string) (Export, []byte, error) {
+	defer db.observe("GetExportFile", time.Now())
+	if !db.Prepared() {
+		return Export{}, nil, ErrNotReady
+	}
+	var e Export
+	var data []byte
+	err := db.stmtGetExportFile.QueryRowContext(ctx, token).Scan(&e.Id, &e.UserId, &e.Status, &e.CreatedAt, &e.FinishedAt, &e.ExpiresAt, &e.Token, &data)
+	if err != nil {
+		return e, nil, translate(err)
+	}
+	return e, data, nil
+}
+
+// PurgeExports delete the exports that expired before before, with their
+// file, and return how many were deleted.
+func (db *Database) PurgeExports(ctx context.Context, before time.Time) (int64, error) {
+	defer db.observe("PurgeExports", time.Now())
+	if !db.Prepared() {
+		return 0, ErrNotReady
+	}
+	res, err := db.stmtPurgeExports.ExecContext(ctx, before)
+	if err != nil {
+		return 0, translate(err)
+	}
+	return res.RowsAffected()
+}
+
+// GetUserData read everything kept about a user in a single snapshot.
+func (db *Database) GetUserData(ctx context.Context, userID int) (UserData, error) {
+	defer db.observe("GetUserData", time.Now())
+	if !db.Prepared() {
+		return UserData{}, ErrNotReady
+	}
+	tx, err := db.Conn.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return UserData{}, translate(err)
+	}
+	defer tx.Rollback()
+
+	data := UserData{Posts: []Post{}, Comments: []Comment{}, Reports: []Report{}, Wisdom: []WisdomGiven{}}
+	u := &data.User
+	err = tx.StmtContext(ctx, db.stmtGetUser).QueryRowContext(ctx, userID).Scan(&u.Id, &u.Email, &u.Gender, &u.Age, &u.Profession)
+	if err != nil {
+		return UserData{}, translate(err)
+	}
+	queries := []struct {
+		query string
+		scan  func(rows *sql.Rows) error
+	}{
+		{`SELECT ` + postColumns + `, post_deleted_at FROM posts WHERE post_user_id=$1 ORDER BY post_id`,
+			func(rows *sql.Rows) error {
+				var p Post
+				err := scanPost(rows, &p, &p.DeletedAt)
+				data.Posts = append(data.Posts, p)
+				return err
+			}},
+		{`SELECT comment_id, COALESCE(comment_user_id, 0), comment_psikolog_id, comment_post_id, comment_text, comment_date, comment_updated_at FROM comments JOIN posts ON post_id=comment_post_id WHERE post_user_id=$1 AND comment_deleted_at IS NULL AND comment_hidden_at IS NULL ORDER BY comment_id`,
+			func(rows *sql.Rows) error {
+				var c Comment
+				err := rows.Scan(&c.Id, &c.UserId, &c.PsikologId, &c.PostId, &c.Text, &c.Date, &c.UpdatedAt)
+				data.Comments = append(data.Comments, c)
+				return err
+			}},
+		{`SELECT report_id, report_user_id, report_post_id FROM reports WHERE report_user_id=$1 ORDER BY report_id`,
+			func(rows *sql.Rows) error {
+				var r Report
+				err := rows.Scan(&r.Id, &r.UserId, &r.PostId)
+				data.Reports = append(data.Reports, r)
+				return err
+			}},
+		{`SELECT wisdom_psikolog_id, COALESCE(wisdom_point, 0) FROM wisdom_points WHERE wisdom_user_id=$1 ORDER BY wisdom_psikolog_id`,
+			func(rows *sql.Rows) error {
+				var w WisdomGiven
+				err := rows.Scan(&w.PsikologID, &w.Point)
+				data.Wisdom = append(data.Wisdom, w)
+				return err
+			}},
+	}
+	for _, q := range queries {
+		rows, err := tx.QueryContext(ctx, q.query, userID)
+		if err != nil {
+			slog.ErrorContext(ctx, "Error while reading the data of a user", "query", q.query, "err", err)
+			return UserData{}, translate(err)
+		}
+		for rows.Next() {
+			if err := q.scan(rows); err != nil {
+				rows.Close()
+				return UserData{}, translate(err)
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return UserData{}, translate(err)
+		}
+	}
+	return data, nil
+}
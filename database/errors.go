@@ -0,0 +1,82 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/lib/pq"
+)
+
+// Domain errors returned by every Store. Test them with errors.Is; the
+// concrete error is an *Error that also wraps the driver error.
+var (
+	// ErrNotFound: the requested record does not exist.
+	ErrNotFound = errors.New("database: record not found")
+	// ErrConflict: the record would duplicate a unique value.
+	ErrConflict = errors.New("database: record already exists")
+	// ErrInvalidReference: a referenced record does not exist.
+	ErrInvalidReference = errors.New("database: referenced record does not exist")
+	// ErrConstraint: a CHECK or NOT NULL constraint rejected a value.
+	ErrConstraint = errors.New("database: value violates a constraint")
+	// ErrInvalidInput: a value cannot be converted to its column type.
+	ErrInvalidInput = errors.New("database: invalid input value")
+)
+
+// Error is a storage error translated to one of the domain errors above.
+type Error struct {
+	// Kind is one of the domain errors.
+	Kind error
+	// Constraint and Column name what was violated, when known.
+	Constraint string
+	Column     string
+	// Err is the original error.
+	Err error
+}
+
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap let errors.Is match both the kind and the original error.
+func (e *Error) Unwrap() []error {
+	return []error{e.Kind, e.Err}
+}
+
+// PostgreSQL error codes, see the errcodes appendix of its manual.
+const (
+	codeUniqueViolation     = "23505"
+	codeForeignKeyViolation = "23503"
+	codeCheckViolation      = "23514"
+	codeNotNullViolation    = "23502"
+	codeInvalidText         = "22P02"
+	codeNumericOutOfRange   = "22003"
+)
+
+// translate map sql.ErrNoRows and *pq.Error to domain errors. Other errors
+// are returned unchanged.
+func translate(err error) error {
+	if err == nil {
+		return nil
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		return &Error{Kind: ErrNotFound, Err: err}
+	}
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) {
+		return err
+	}
+	e := &Error{Constraint: pqErr.Constraint, Column: pqErr.Column, Err: err}
+	switch pqErr.Code {
+	case codeUniqueViolation:
+		e.Kind = ErrConflict
+	case codeForeignKeyViolation:
+		e.Kind = ErrInvalidReference
+	case codeCheckViolation, codeNotNullViolation:
+		e.Kind = ErrConstraint
+	case codeInvalidText, codeNumericOutOfRange:
+		e.Kind = ErrInvalidInput
+	default:
+		return err
+	}
+	return e
+}
@@ -0,0 +1,47 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+
+	"github.com/lib/pq"
+)
+
+func TestTranslate(t *testing.T) {
+	other := errors.New("connection refused")
+	tests := []struct {
+		err  error
+		kind error
+	}{
+		{sql.ErrNoRows, ErrNotFound},
+		{&pq.Error{Code: "23505", Constraint: "users_user_email_key"}, ErrConflict},
+		{&pq.Error{Code: "23503"}, ErrInvalidReference},
+		{&pq.Error{Code: "23514"}, ErrConstraint},
+		{&pq.Error{Code: "23502", Column: "user_email"}, ErrConstraint},
+		{&pq.Error{Code: "22P02"}, ErrInvalidInput},
+		{&pq.Error{Code: "40001"}, nil},
+		{other, nil},
+	}
+	for _, test := range tests {
+		got := translate(test.err)
+		if !errors.Is(got, test.err) {
+			t.Errorf("translate(%v) does not wrap the original error", test.err)
+		}
+		if test.kind == nil {
+			if got != test.err {
+				t.Errorf("translate(%v) = %v, want it unchanged", test.err, got)
+			}
+			continue
+		}
+		if !errors.Is(got, test.kind) {
+			t.Errorf("translate(%v) = %v, want %v", test.err, got, test.kind)
+		}
+	}
+	if translate(nil) != nil {
+		t.Error("translate(nil) != nil")
+	}
+	if !errors.Is(ErrPostsNotFound, ErrNotFound) {
+		t.Error("ErrPostsNotFound does not match ErrNotFound")
+	}
+}
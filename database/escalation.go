@@ -0,0 +1,239 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+	"time"
+)
+
+// A post is overdue when its psikolog has not answered it within the
+// escalation window of its last assignment. Posts without any assignment,
+// written before posts were routed, count from their date. An escalated post
+// goes to another psikolog or to the open pool, where its PsikologId is
+// empty until a psikolog claims it.
+
+// Notification kinds.
+const (
+	NotifyReassigned = "post.reassigned"
+	NotifyPooled     = "post.pooled"
+	NotifyAssigned   = "post.assigned"
+)
+
+// OverduePost is a post waiting for a first comment since AssignedAt, and
+// the number of times it changed psikolog already.
+type OverduePost struct {
+	PostId        int
+	UserId        int
+	PsikologId    int
+	Category      string
+	AssignedAt    time.Time
+	Reassignments int
+}
+
+// Escalation move a post from the psikolog From to the psikolog To, or to
+// the open pool when To is 0. UserId is the author of the post.
+type Escalation struct {
+	PostId int
+	UserId int
+	From   int
+	To     int
+	Reason string
+}
+
+// Notification tell a psikolog, or the admins when PsikologId is 0, what
+// happened to a post.
+type Notification struct {
+	Id         int        `json:"notification_id"`
+	PsikologId int        `json:"notification_psikolog_id,omitempty"`
+	PostId     int        `json:"notification_post_id"`
+	Kind       string     `json:"notification_kind"`
+	Date       *time.Time `json:"notification_date"`
+}
+
+// maxNotifications is how many notifications GetNotifications return.
+const maxNotifications = 100
+
+// notifications of an escalation: the psikolog who lost the post and the
+// admins, and the psikolog who got it.
+func (e *Escalation) notifications() []Notification {
+	kind := NotifyReassigned
+	if e.To == 0 {
+		kind = NotifyPooled
+	}
+	n := []Notification{
+		{PsikologId: e.From, PostId: e.PostId, Kind: kind},
+		{PostId: e.PostId, Kind: kind},
+	}
+	if e.To != 0 {
+		n = append(n, Notification{PsikologId: e.To, PostId: e.PostId, Kind: NotifyAssigned})
+	}
+	return n
+}
+
+// GetOverduePosts get the live posts without a visible comment whose last
+// assignment, or date when they have none, is older than before, oldest
+// first.
+func (db *Database) GetOverduePosts(ctx context.Context, before time.Time) ([]OverduePost, error) {
+	defer db.observe("GetOverduePosts", time.Now())
+	if !db.Prepared() {
+		return nil, ErrNotReady
+	}
+	rows, err := db.Conn.QueryContext(ctx, `
+SELECT post_id, COALESCE(post_user_id, 0), post_psikolog_id, COALESCE(post_category, ''),
+    COALESCE(a.assigned_at, post_date) AS assigned_at, COALESCE(a.reassignments, 0)
+FROM posts
+LEFT JOIN (SELECT assignment_post_id, max(assignment_date) AS assigned_at, count(*) - 1 AS reassignments
+    FROM post_assignments GROUP BY 1) a ON a.assignment_post_id=post_id
+WHERE post_deleted_at IS NULL AND post_psikolog_id IS NOT NULL AND COALESCE(a.assigned_at, post_date) < $1
+    AND NOT EXISTS(SELECT 1 FROM comments WHERE comment_post_id=post_id AND comment_deleted_at IS NULL AND comment_hidden_at IS NULL)
+ORDER BY 5, post_id`, before)
+	if err != nil {
+		return nil, translate(err)
+	}
+	defer rows.Close()
+	var posts []OverduePost
+	for rows.Next() {
+		var o OverduePost
+		if err := rows.Scan(&o.PostId, &o.UserId, &o.PsikologId, &o.Category, &o.AssignedAt, &o.Reassignments); err != nil {
+			return nil, translate(err)
+		}
+		posts = append(posts, o)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, translate(err)
+	}
+	return posts, nil
+}
+
+// EscalatePost move the post of e, record the assignment and notify the
+// psikologs and admins, at once. It returns ErrNotFound when the post was
+// answered, deleted or moved in the meantime.
+func (db *Database) EscalatePost(ctx context.Context, e *Escalation) error {
+	defer db.observe("EscalatePost", time.Now())
+	if !db.Prepared() {
+		return ErrNotReady
+	}
+	tx, err := db.Conn.BeginTx(ctx, nil)
+	if err != nil {
+		return translate(err)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, `
+UPDATE posts SET post_psikolog_id=NULLIF($3::integer, 0)
+WHERE post_id=$1 AND post_psikolog_id=$2 AND post_deleted_at IS NULL
+    AND NOT EXISTS(SELECT 1 FROM comments WHERE comment_post_id=post_id AND comment_deleted_at IS NULL AND comment_hidden_at IS NULL)`, e.PostId, e.From, e.To)
+	if err != nil {
+		return translate(err)
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return &Error{Kind: ErrNotFound, Err: sql.ErrNoRows}
+	}
+	if err := db.insertAssignment(ctx, tx, &Assignment{PostId: e.PostId, PsikologId: e.To, Reason: e.Reason}); err != nil {
+		return err
+	}
+	for _, n := range e.notifications() {
+		_, err := tx.ExecContext(ctx, `INSERT INTO notifications(notification_psikolog_id, notification_post_id, notification_kind) VALUES (NULLIF($1::integer, 0), $2, $3)`,
+			n.PsikologId, n.PostId, n.Kind)
+		if err != nil {
+			return translate(err)
+		}
+	}
+	return translate(tx.Commit())
+}
+
+// insertAssignment record a in tx.
+func (db *Database) insertAssignment(ctx context.Context, tx *sql.Tx, a *Assignment) error {
+	err := tx.StmtContext(ctx, db.stmtInsertAssignment).QueryRowContext(ctx, a.PostId, a.PsikologId, a.Reason).Scan(&a.Id, &a.Date)
+	return translate(err)
+}
+
+// ClaimPost give the pooled post p to the psikolog psikologID, and record
+// the assignment with reason. It returns ErrNotFound when the post is not
+// in the pool.
+func (db *Database) ClaimPost(ctx context.Context, p *Post, psikologID int, reason string) error {
+	defer db.observe("ClaimPost", time.Now())
+	if !db.Prepared() {
+		return ErrNotReady
+	}
+	tx, err := db.Conn.BeginTx(ctx, nil)
+	if err != nil {
+		return translate(err)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, `UPDATE posts SET post_psikolog_id=$2 WHERE post_id=$1 AND post_psikolog_id IS NULL AND post_deleted_at IS NULL`, p.Id, psikologID)
+	if err != nil {
+		return translate(err)
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return &Error{Kind: ErrNotFound, Err: sql.ErrNoRows}
+	}
+	if err := db.insertAssignment(ctx, tx, &Assignment{PostId: p.Id, PsikologId: psikologID, Reason: reason}); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return translate(err)
+	}
+	p.PsikologId = strconv.Itoa(psikologID)
+	return nil
+}
+
+// GetPooledPosts get the live posts of the open pool, oldest first.
+func (db *Database) GetPooledPosts(ctx context.Context) ([]Post, error) {
+	defer db.observe("GetPooledPosts", time.Now())
+	if !db.Prepared() {
+		return nil, ErrNotReady
+	}
+	rows, err := db.Conn.QueryContext(ctx, `SELECT `+postColumns+`
+FROM posts WHERE post_psikolog_id IS NULL AND post_deleted_at IS NULL ORDER BY post_id`)
+	if err != nil {
+		return nil, translate(err)
+	}
+	defer rows.Close()
+	posts := []Post{}
+	for rows.Next() {
+		var p Post
+		if err := scanPost(rows, &p); err != nil {
+			return nil, translate(err)
+		}
+		posts = append(posts, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, translate(err)
+	}
+	return posts, nil
+}
+
+// GetNotifications get the latest notifications of a psikolog, or of the
+// admins when psikologID is 0, newest first.
+func (db *Database) GetNotifications(ctx context.Context, psikologID int) ([]Notification, error) {
+	defer db.observe("GetNotifications", time.Now())
+	if !db.Prepared() {
+		return nil, ErrNotReady
+	}
+	rows, err := db.Conn.QueryContext(ctx, `SELECT notification_id, COALESCE(notification_psikolog_id, 0), notification_post_id, notification_kind, notification_date
+FROM notifications WHERE notification_psikolog_id IS NOT DISTINCT FROM NULLIF($1::integer, 0)
+ORDER BY notification_id DESC LIMIT $2`, psikologID, maxNotifications)
+	if err != nil {
+		return nil, translate(err)
+	}
+	defer rows.Close()
+	notifications := []Notification{}
+	for rows.Next() {
+		var n Notification
+		if err := rows.Scan(&n.Id, &n.PsikologId, &n.PostId, &n.Kind, &n.Date); err != nil {
+			return nil, translate(err)
+		}
+		notifications = append(notifications, n)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, translate(err)
+	}
+	return notifications, nil
+}
@@ -0,0 +1,1407 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultWisdomPoint mirrors the DEFAULT of wisdom_points.wisdom_point.
+const defaultWisdomPoint = 10
+
+// wisdomKey identifies a row of wisdom_points.
+type wisdomKey struct {
+	userID     int
+	psikologID int
+}
+
+// Memory is an in-process Store. It keeps every table in maps guarded by a
+// single mutex and enforces the same constraints as the migrations (unique
+// emails, unique wisdom pairs and foreign keys), returning the same domain
+// errors as Database. It is meant for tests and demo mode.
+type Memory struct {
+	mu sync.Mutex
+
+	users     map[int]User
+	psikologs map[int]Psikolog
+	posts     map[int]Post
+	comments  map[int]Comment
+	reports   map[int]Report
+	wisdom    map[wisdomKey]int
+	revisions map[int][]PostRevision
+	// deleted posts are moved out of posts until they are purged
+	deleted map[int]deletedPost
+
+	commentRevisions map[int][]CommentRevision
+	hiddenComments   map[int]bool
+	deletedComments  map[int]bool
+
+	// anonymousWisdom sum the points of deleted users by psikolog
+	anonymousWisdom map[int]int
+	deletions       map[int]UserDeletion
+	audit           []AuditEntry
+
+	// userCreated and reportDates are user_created_at and report_date
+	userCreated map[int]time.Time
+	reportDates map[int]time.Time
+
+	exports    map[int]Export
+	exportData map[int][]byte
+	// exportStarted is when a running export was claimed
+	exportStarted map[int]time.Time
+
+	categories    map[string]Category
+	assignments   []Assignment
+	notifications []Notification
+
+	lastUserID     int
+	lastPsikologID int
+	lastPostID     int
+	lastCommentID  int
+	lastReportID   int
+	lastRevisionID int
+
+	lastCommentRevisionID int
+	lastAuditID           int
+	lastExportID          int
+	lastAssignmentID      int
+	lastNotificationID    int
+}
+
+type deletedPost struct {
+	post Post
+	at   time.Time
+}
+
+// NewMemory returns an empty in-memory store.
+func NewMemory() *Memory {
+	return &Memory{
+		users:     make(map[int]User),
+		psikologs: make(map[int]Psikolog),
+		posts:     make(map[int]Post),
+		comments:  make(map[int]Comment),
+		revisions: make(map[int][]PostRevision),
+		deleted:   make(map[int]deletedPost),
+
+		commentRevisions: make(map[int][]CommentRevision),
+		hiddenComments:   make(map[int]bool),
+		deletedComments:  make(map[int]bool),
+
+		anonymousWisdom: make(map[int]int),
+		deletions:       make(map[int]UserDeletion),
+
+		userCreated: make(map[int]time.Time),
+		reportDates: make(map[int]time.Time),
+
+		exports:       make(map[int]Export),
+		exportData:    make(map[int][]byte),
+		exportStarted: make(map[int]time.Time),
+
+		categories: make(map[string]Category),
+
+		reports: make(map[int]Report),
+		wisdom:  make(map[wisdomKey]int),
+	}
+}
+
+// uniqueViolation builds the error PostgreSQL returns for a UNIQUE
+// constraint, translated to ErrConflict.
+func uniqueViolation(constraint string) error {
+	return &Error{
+		Kind:       ErrConflict,
+		Constraint: constraint,
+		Err:        fmt.Errorf("pq: duplicate key value violates unique constraint %q", constraint),
+	}
+}
+
+// foreignKeyViolation builds the error PostgreSQL returns for a REFERENCES
+// constraint, translated to ErrInvalidReference.
+func foreignKeyViolation(table, constraint string) error {
+	return &Error{
+		Kind:       ErrInvalidReference,
+		Constraint: constraint,
+		Err:        fmt.Errorf("pq: insert or update on table %q violates foreign key constraint %q", table, constraint),
+	}
+}
+
+// parseID converts an ID received as text the same way PostgreSQL casts it
+// to an integer column.
+func parseID(s string) (int, error) {
+	id, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, &Error{
+			Kind: ErrInvalidInput,
+			Err:  fmt.Errorf("pq: invalid input syntax for integer: %q", s),
+		}
+	}
+	return id, nil
+}
+
+func (m *Memory) InsertUser(ctx context.Context, user *User) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, u := range m.users {
+		if u.Email == user.Email {
+			return uniqueViolation("users_user_email_key")
+		}
+	}
+	m.lastUserID++
+	u := *user
+	u.Id = m.lastUserID
+	m.users[u.Id] = u
+	m.userCreated[u.Id] = time.Now()
+	user.Id = u.Id
+	return nil
+}
+
+func (m *Memory) InsertPsikolog(ctx context.Context, p *Psikolog) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, ps := range m.psikologs {
+		if ps.Email == p.Email {
+			return uniqueViolation("psikologs_psikolog_email_key")
+		}
+	}
+	m.lastPsikologID++
+	ps := *p
+	ps.Id = m.lastPsikologID
+	ps.Verified = false
+	ps.Specializations = append([]string(nil), p.Specializations...)
+	m.psikologs[ps.Id] = ps
+	p.Id = ps.Id
+	p.Verified = false
+	return nil
+}
+
+func (m *Memory) GetPsikologByID(ctx context.Context, psikolog_id string) (Reliever, error) {
+	var r Reliever
+	id, err := parseID(psikolog_id)
+	if err != nil {
+		return r, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	p, ok := m.psikologs[id]
+	if !ok {
+		return r, notFound()
+	}
+	r.Name = p.Name
+	r.Bio = p.Bio
+	return r, nil
+}
+
+func (m *Memory) InsertPost(ctx context.Context, p *Post) error {
+	userID, err := parseID(p.UserId)
+	if err != nil {
+		return err
+	}
+	psikologID, err := parseID(p.PsikologId)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.users[userID]; !ok {
+		return foreignKeyViolation("posts", "posts_post_user_id_fkey")
+	}
+	if _, ok := m.psikologs[psikologID]; !ok {
+		return foreignKeyViolation("posts", "posts_post_psikolog_id_fkey")
+	}
+	now := time.Now()
+	m.lastPostID++
+	post := Post{
+		Id:         m.lastPostID,
+		UserId:     strconv.Itoa(userID),
+		PsikologId: strconv.Itoa(psikologID),
+		Date:       &now,
+		Title:      p.Title,
+		Category:   p.Category,
+		Content:    p.Content,
+		Tags:       append([]string(nil), p.Tags...),
+	}
+	m.posts[post.Id] = post
+	p.Id = post.Id
+	p.Date = post.Date
+	return nil
+}
+
+func (m *Memory) GetAllPostsByUserID(ctx context.Context, userID string) ([]Post, error) {
+	id, err := parseID(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var posts []Post
+	for postID := 1; postID <= m.lastPostID; postID++ {
+		post, ok := m.posts[postID]
+		if ok && post.UserId == strconv.Itoa(id) {
+			posts = append(posts, copyPost(post))
+		}
+	}
+	if len(posts) == 0 {
+		return nil, ErrPostsNotFound
+	}
+	return posts, nil
+}
+
+func (m *Memory) InsertComment(ctx context.Context, c *Comment) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.users[c.UserId]; !ok {
+		return foreignKeyViolation("comments", "comments_comment_user_id_fkey")
+	}
+	if _, ok := m.psikologs[c.PsikologId]; !ok {
+		return foreignKeyViolation("comments", "comments_comment_psikolog_id_fkey")
+	}
+	if _, ok := m.posts[c.PostId]; !ok {
+		return foreignKeyViolation("comments", "comments_comment_post_id_fkey")
+	}
+	now := time.Now()
+	m.lastCommentID++
+	comment := *c
+	comment.Id = m.lastCommentID
+	comment.Date = &now
+	m.comments[comment.Id] = comment
+	c.Id = comment.Id
+	c.Date = comment.Date
+	return nil
+}
+
+func (m *Memory) InsertReport(ctx context.Context, r *Report) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.users[r.UserId]; !ok {
+		return foreignKeyViolation("reports", "reports_report_user_id_fkey")
+	}
+	if _, ok := m.posts[r.PostId]; !ok {
+		return foreignKeyViolation("reports", "reports_report_post_id_fkey")
+	}
+	m.lastReportID++
+	report := *r
+	report.Id = m.lastReportID
+	m.reports[report.Id] = report
+	m.reportDates[report.Id] = time.Now()
+	r.Id = report.Id
+	return nil
+}
+
+func (m *Memory) GetWisdomPointByID(ctx context.Context, id string) (PsikologPoint, error) {
+	var p PsikologPoint
+	p.PsikologID = id
+	psikologID, err := parseID(id)
+	if err != nil {
+		return p, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	sum := m.anonymousWisdom[psikologID]
+	for k, point := range m.wisdom {
+		if k.psikologID == psikologID {
+			sum += point
+		}
+	}
+	p.Point = strconv.Itoa(sum)
+	return p, nil
+}
+
+func (m *Memory) CheckWisdomPoint(ctx context.Context, user_id string, psikolog_id string) (WisdomPointStatus, error) {
+	var ws WisdomPointStatus
+	userID, err := parseID(user_id)
+	if err != nil {
+		return ws, err
+	}
+	psikologID, err := parseID(psikolog_id)
+	if err != nil {
+		return ws, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	_, ok := m.wisdom[wisdomKey{userID, psikologID}]
+	ws.Status = strconv.FormatBool(ok)
+	return ws, nil
+}
+
+func (m *Memory) InsertWisdomPoint(ctx context.Context, w *WisdomPoint) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.users[w.UserID]; !ok {
+		return foreignKeyViolation("wisdom_points", "wisdom_points_wisdom_user_id_fkey")
+	}
+	if _, ok := m.psikologs[w.PsikologID]; !ok {
+		return foreignKeyViolation("wisdom_points", "wisdom_points_wisdom_psikolog_id_fkey")
+	}
+	k := wisdomKey{w.UserID, w.PsikologID}
+	if _, ok := m.wisdom[k]; ok {
+		return uniqueViolation("wisdom_points_wisdom_user_id_wisdom_psikolog_id_key")
+	}
+	m.wisdom[k] = defaultWisdomPoint
+	return nil
+}
+
+// notFound is the error of a lookup that found no record.
+func notFound() error {
+	return &Error{Kind: ErrNotFound, Err: sql.ErrNoRows}
+}
+
+func (m *Memory) GetUser(ctx context.Context, id int) (User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	u, ok := m.users[id]
+	if !ok {
+		return User{}, notFound()
+	}
+	return u, nil
+}
+
+func (m *Memory) GetPsikolog(ctx context.Context, id int) (Psikolog, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	p, ok := m.psikologs[id]
+	if !ok {
+		return Psikolog{}, notFound()
+	}
+	p.Specializations = append([]string{}, p.Specializations...)
+	return p, nil
+}
+
+func (m *Memory) UpdatePsikolog(ctx context.Context, p *Psikolog) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	old, ok := m.psikologs[p.Id]
+	if !ok {
+		return notFound()
+	}
+	for _, ps := range m.psikologs {
+		if ps.Email == p.Email && ps.Id != p.Id {
+			return uniqueViolation("psikologs_psikolog_email_key")
+		}
+	}
+	ps := *p
+	ps.Wisdom, ps.Verified = old.Wisdom, old.Verified
+	ps.Specializations = append([]string(nil), p.Specializations...)
+	m.psikologs[p.Id] = ps
+	p.Wisdom, p.Verified = old.Wisdom, old.Verified
+	return nil
+}
+
+func (m *Memory) SetPsikologVerified(ctx context.Context, id int, verified bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	p, ok := m.psikologs[id]
+	if !ok {
+		return notFound()
+	}
+	p.Verified = verified
+	m.psikologs[id] = p
+	return nil
+}
+
+func (m *Memory) GetRoutingCandidates(ctx context.Context) ([]RoutingCandidate, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	answered := m.answered()
+	open := make(map[int]int)
+	for _, post := range m.posts {
+		if !answered[post.Id] {
+			id, _ := strconv.Atoi(post.PsikologId)
+			open[id]++
+		}
+	}
+	var candidates []RoutingCandidate
+	for _, p := range m.psikologs {
+		p.Specializations = append([]string{}, p.Specializations...)
+		candidates = append(candidates, RoutingCandidate{Psikolog: p, OpenPosts: open[p.Id]})
+	}
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].Id < candidates[j].Id })
+	return candidates, nil
+}
+
+func (m *Memory) InsertAssignment(ctx context.Context, a *Assignment) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.posts[a.PostId]; !ok {
+		return foreignKeyViolation("post_assignments", "post_assignments_assignment_post_id_fkey")
+	}
+	if _, ok := m.psikologs[a.PsikologId]; !ok && a.PsikologId != 0 {
+		return foreignKeyViolation("post_assignments", "post_assignments_assignment_psikolog_id_fkey")
+	}
+	m.insertAssignment(a)
+	return nil
+}
+
+func (m *Memory) GetAssignments(ctx context.Context, postID int) ([]Assignment, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	assignments := []Assignment{}
+	for _, a := range m.assignments {
+		if a.PostId == postID {
+			assignments = append(assignments, a)
+		}
+	}
+	return assignments, nil
+}
+
+func (m *Memory) GetPost(ctx context.Context, id int) (Post, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	p, ok := m.posts[id]
+	if !ok {
+		return Post{}, notFound()
+	}
+	return copyPost(p), nil
+}
+
+func (m *Memory) GetComment(ctx context.Context, id int) (Comment, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c, ok := m.visibleComment(id)
+	if !ok {
+		return Comment{}, notFound()
+	}
+	return c, nil
+}
+
+// visibleComment return the comment id unless it, or its post, is deleted
+// or hidden. m.mu must be held.
+func (m *Memory) visibleComment(id int) (Comment, bool) {
+	c, ok := m.comments[id]
+	if _, live := m.posts[c.PostId]; !ok || !live || m.deletedComments[id] || m.hiddenComments[id] {
+		return Comment{}, false
+	}
+	return c, true
+}
+
+func (m *Memory) GetCommentsByPostID(ctx context.Context, postID int) ([]Comment, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	comments := []Comment{}
+	for id := 1; id <= m.lastCommentID; id++ {
+		c, ok := m.visibleComment(id)
+		if ok && c.PostId == postID {
+			comments = append(comments, c)
+		}
+	}
+	return comments, nil
+}
+
+func (m *Memory) GetReport(ctx context.Context, id int) (Report, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	r, ok := m.reports[id]
+	if !ok {
+		return Report{}, notFound()
+	}
+	return r, nil
+}
+
+// Ping always succeeds.
+func (m *Memory) Ping(ctx context.Context) error {
+	return nil
+}
+
+// Close is a no-op; it exists to satisfy Store.
+func (m *Memory) Close() error {
+	return nil
+}
+
+func (m *Memory) UpdatePost(ctx context.Context, p *Post) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	post, ok := m.posts[p.Id]
+	if !ok {
+		return notFound()
+	}
+	now := time.Now()
+	m.lastRevisionID++
+	m.revisions[p.Id] = append(m.revisions[p.Id], PostRevision{
+		Id:       m.lastRevisionID,
+		PostId:   p.Id,
+		Date:     &now,
+		Title:    post.Title,
+		Category: post.Category,
+		Content:  post.Content,
+	})
+	post.Title, post.Category, post.Content = p.Title, p.Category, p.Content
+	post.Tags = append([]string(nil), p.Tags...)
+	post.UpdatedAt = &now
+	m.posts[p.Id] = post
+	p.UpdatedAt = post.UpdatedAt
+	return nil
+}
+
+func (m *Memory) DeletePost(ctx context.Context, p *Post) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	post, ok := m.posts[p.Id]
+	if !ok {
+		return notFound()
+	}
+	delete(m.posts, p.Id)
+	m.deleted[p.Id] = deletedPost{post, time.Now()}
+	return nil
+}
+
+func (m *Memory) GetPostRevisions(ctx context.Context, postID int) ([]PostRevision, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return append([]PostRevision{}, m.revisions[postID]...), nil
+}
+
+func (m *Memory) PurgeDeletedPosts(ctx context.Context, before time.Time) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var n int64
+	for id, d := range m.deleted {
+		if !d.at.Before(before) {
+			continue
+		}
+		for cid, c := range m.comments {
+			if c.PostId == id {
+				delete(m.comments, cid)
+				delete(m.commentRevisions, cid)
+				delete(m.hiddenComments, cid)
+				delete(m.deletedComments, cid)
+			}
+		}
+		for rid, r := range m.reports {
+			if r.PostId == id {
+				delete(m.reports, rid)
+			}
+		}
+		delete(m.revisions, id)
+		delete(m.deleted, id)
+		n++
+	}
+	return n, nil
+}
+
+func (m *Memory) UpdateComment(ctx context.Context, c *Comment) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	comment, ok := m.comments[c.Id]
+	if !ok || m.deletedComments[c.Id] || m.hiddenComments[c.Id] {
+		return notFound()
+	}
+	now := time.Now()
+	m.lastCommentRevisionID++
+	m.commentRevisions[c.Id] = append(m.commentRevisions[c.Id], CommentRevision{
+		Id:        m.lastCommentRevisionID,
+		CommentId: c.Id,
+		Date:      &now,
+		Text:      comment.Text,
+	})
+	comment.Text = c.Text
+	comment.UpdatedAt = &now
+	m.comments[c.Id] = comment
+	c.UpdatedAt = comment.UpdatedAt
+	return nil
+}
+
+func (m *Memory) DeleteComment(ctx context.Context, c *Comment) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.comments[c.Id]; !ok || m.deletedComments[c.Id] {
+		return notFound()
+	}
+	m.deletedComments[c.Id] = true
+	return nil
+}
+
+func (m *Memory) SetCommentHidden(ctx context.Context, c *Comment, hidden bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	comment, ok := m.comments[c.Id]
+	if !ok || m.deletedComments[c.Id] {
+		return notFound()
+	}
+	if hidden {
+		m.hiddenComments[c.Id] = true
+	} else {
+		delete(m.hiddenComments, c.Id)
+	}
+	*c = comment
+	return nil
+}
+
+func (m *Memory) GetCommentRevisions(ctx context.Context, commentID int) ([]CommentRevision, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return append([]CommentRevision{}, m.commentRevisions[commentID]...), nil
+}
+
+func (m *Memory) ScheduleUserDeletion(ctx context.Context, d *UserDeletion) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.users[d.UserId]; !ok {
+		return notFound()
+	}
+	if pending, ok := m.deletions[d.UserId]; ok {
+		*d = pending
+		return nil
+	}
+	now := time.Now()
+	after := *d.DeleteAfter
+	*d = UserDeletion{UserId: d.UserId, RequestedAt: &now, DeleteAfter: &after}
+	m.deletions[d.UserId] = *d
+	return nil
+}
+
+func (m *Memory) GetUserDeletion(ctx context.Context, userID int) (UserDeletion, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	d, ok := m.deletions[userID]
+	if !ok {
+		return UserDeletion{}, notFound()
+	}
+	return d, nil
+}
+
+func (m *Memory) CancelUserDeletion(ctx context.Context, userID int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.deletions[userID]; !ok {
+		return notFound()
+	}
+	delete(m.deletions, userID)
+	return nil
+}
+
+func (m *Memory) GetDueUserDeletions(ctx context.Context, now time.Time) ([]int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var ids []int
+	for id, d := range m.deletions {
+		if !d.DeleteAfter.After(now) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Ints(ids)
+	return ids, nil
+}
+
+func (m *Memory) CompleteUserDeletion(ctx context.Context, userID int, now time.Time, anonymize bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	d, ok := m.deletions[userID]
+	if !ok || d.DeleteAfter.After(now) {
+		return notFound()
+	}
+	uid := strconv.Itoa(userID)
+
+	// posts, live and deleted
+	posts := make(map[int]bool)
+	for id, p := range m.posts {
+		if p.UserId == uid {
+			posts[id] = true
+			p.UserId = ""
+			m.posts[id] = p
+		}
+	}
+	for id, dp := range m.deleted {
+		if dp.post.UserId == uid {
+			posts[id] = true
+			dp.post.UserId = ""
+			m.deleted[id] = dp
+		}
+	}
+	for id, c := range m.comments {
+		switch {
+		case !anonymize && (posts[c.PostId] || c.UserId == userID):
+			delete(m.comments, id)
+			delete(m.commentRevisions, id)
+			delete(m.hiddenComments, id)
+			delete(m.deletedComments, id)
+		case c.UserId == userID:
+			c.UserId = 0
+			m.comments[id] = c
+		}
+	}
+	if !anonymize {
+		for id := range posts {
+			delete(m.posts, id)
+			delete(m.deleted, id)
+			delete(m.revisions, id)
+		}
+	}
+	for id, r := range m.reports {
+		if r.UserId == userID || (!anonymize && posts[r.PostId]) {
+			delete(m.reports, id)
+		}
+	}
+	for k, point := range m.wisdom {
+		if k.userID == userID {
+			if anonymize {
+				m.anonymousWisdom[k.psikologID] += point
+			}
+			delete(m.wisdom, k)
+		}
+	}
+	for id, e := range m.exports {
+		if e.UserId == userID {
+			delete(m.exports, id)
+			delete(m.exportData, id)
+			delete(m.exportStarted, id)
+		}
+	}
+	delete(m.deletions, userID)
+	delete(m.users, userID)
+	return nil
+}
+
+func (m *Memory) InsertAuditEntry(ctx context.Context, a *AuditEntry) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	m.lastAuditID++
+	a.Id = m.lastAuditID
+	a.Date = &now
+	m.audit = append(m.audit, *a)
+	return nil
+}
+
+func (m *Memory) GetAuditEntries(ctx context.Context, userID int) ([]AuditEntry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entries := []AuditEntry{}
+	for _, a := range m.audit {
+		if a.UserId == userID {
+			entries = append(entries, a)
+		}
+	}
+	return entries, nil
+}
+
+func (m *Memory) GetUserData(ctx context.Context, userID int) (UserData, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	u, ok := m.users[userID]
+	if !ok {
+		return UserData{}, notFound()
+	}
+	data := UserData{User: u, Posts: []Post{}, Comments: []Comment{}, Reports: []Report{}, Wisdom: []WisdomGiven{}}
+	uid := strconv.Itoa(userID)
+	posts := make(map[int]bool)
+	for id := 1; id <= m.lastPostID; id++ {
+		if p, ok := m.posts[id]; ok && p.UserId == uid {
+			data.Posts = append(data.Posts, copyPost(p))
+			posts[id] = true
+		} else if dp, ok := m.deleted[id]; ok && dp.post.UserId == uid {
+			p := copyPost(dp.post)
+			at := dp.at
+			p.DeletedAt = &at
+			data.Posts = append(data.Posts, p)
+			posts[id] = true
+		}
+	}
+	for id := 1; id <= m.lastCommentID; id++ {
+		// the comments of deleted posts too, unlike visibleComment
+		c, ok := m.comments[id]
+		if ok && posts[c.PostId] && !m.deletedComments[id] && !m.hiddenComments[id] {
+			data.Comments = append(data.Comments, c)
+		}
+	}
+	for id := 1; id <= m.lastReportID; id++ {
+		if r, ok := m.reports[id]; ok && r.UserId == userID {
+			data.Reports = append(data.Reports, r)
+		}
+	}
+	for k, point := range m.wisdom {
+		if k.userID == userID {
+			data.Wisdom = append(data.Wisdom, WisdomGiven{PsikologID: k.psikologID, Point: point})
+		}
+	}
+	sort.Slice(data.Wisdom, func(i, j int) bool { return data.Wisdom[i].PsikologID < data.Wisdom[j].PsikologID })
+	return data, nil
+}
+
+func (m *Memory) InsertExport(ctx context.Context, e *Export) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.users[e.UserId]; !ok {
+		return foreignKeyViolation("exports", "exports_export_user_id_fkey")
+	}
+	now := time.Now()
+	m.lastExportID++
+	*e = Export{Id: m.lastExportID, UserId: e.UserId, Status: ExportPending, CreatedAt: &now}
+	m.exports[e.Id] = *e
+	return nil
+}
+
+func (m *Memory) GetExport(ctx context.Context, id int) (Export, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e, ok := m.exports[id]
+	if !ok {
+		return Export{}, notFound()
+	}
+	return e, nil
+}
+
+func (m *Memory) ClaimExport(ctx context.Context, staleBefore time.Time) (Export, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for id := 1; id <= m.lastExportID; id++ {
+		e, ok := m.exports[id]
+		if !ok {
+			continue
+		}
+		if e.Status == ExportPending || (e.Status == ExportRunning && m.exportStarted[id].Before(staleBefore)) {
+			e.Status = ExportRunning
+			m.exports[id] = e
+			m.exportStarted[id] = time.Now()
+			return e, nil
+		}
+	}
+	return Export{}, notFound()
+}
+
+func (m *Memory) FinishExport(ctx context.Context, e *Export, data []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, ok := m.exports[e.Id]
+	if !ok || stored.Status != ExportRunning {
+		return notFound()
+	}
+	if e.Token != "" {
+		for _, other := range m.exports {
+			if other.Token == e.Token {
+				return uniqueViolation("exports_export_token_key")
+			}
+		}
+	}
+	now := time.Now()
+	stored.Status = e.Status
+	stored.Token = e.Token
+	stored.ExpiresAt = e.ExpiresAt
+	stored.FinishedAt = &now
+	m.exports[e.Id] = stored
+	m.exportData[e.Id] = append([]byte(nil), data...)
+	delete(m.exportStarted, e.Id)
+	e.FinishedAt = &now
+	return nil
+}
+
+func (m *Memory) GetExportFile(ctx context.Context, token string) (Export, []byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for id, e := range m.exports {
+		if e.Status == ExportDone && e.Token == token {
+			return e, m.exportData[id], nil
+		}
+	}
+	return Export{}, nil, notFound()
+}
+
+func (m *Memory) PurgeExports(ctx context.Context, before time.Time) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var n int64
+	for id, e := range m.exports {
+		if e.ExpiresAt != nil && e.ExpiresAt.Before(before) {
+			delete(m.exports, id)
+			delete(m.exportData, id)
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (m *Memory) GetActivity(ctx context.Context, interval string, from, to time.Time) ([]ActivityPoint, error) {
+	if interval != IntervalDay && interval != IntervalWeek {
+		return nil, &Error{Kind: ErrInvalidInput, Err: fmt.Errorf("unknown interval %q", interval)}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	points, index := periods(interval, from, to)
+	count := func(t time.Time, field func(p *ActivityPoint) *int) {
+		if t.Before(from) || !t.Before(to) {
+			return
+		}
+		if i, ok := index[truncate(t, interval)]; ok {
+			*field(&points[i])++
+		}
+	}
+	for _, p := range m.posts {
+		count(*p.Date, func(p *ActivityPoint) *int { return &p.Posts })
+	}
+	for _, dp := range m.deleted {
+		count(*dp.post.Date, func(p *ActivityPoint) *int { return &p.Posts })
+	}
+	for _, c := range m.comments {
+		count(*c.Date, func(p *ActivityPoint) *int { return &p.Comments })
+	}
+	for id := range m.reports {
+		count(m.reportDates[id], func(p *ActivityPoint) *int { return &p.Reports })
+	}
+	for id := range m.users {
+		count(m.userCreated[id], func(p *ActivityPoint) *int { return &p.Users })
+	}
+	return points, nil
+}
+
+func (m *Memory) GetPostBreakdown(ctx context.Context, by string, from, to time.Time) ([]PostBreakdown, error) {
+	if _, ok := breakdownGroups[by]; !ok {
+		return nil, &Error{Kind: ErrInvalidInput, Err: fmt.Errorf("unknown dimension %q", by)}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	posts := make([]Post, 0, len(m.posts)+len(m.deleted))
+	for _, p := range m.posts {
+		posts = append(posts, p)
+	}
+	for _, dp := range m.deleted {
+		posts = append(posts, dp.post)
+	}
+	comments := make(map[int]int)
+	for _, c := range m.comments {
+		comments[c.PostId]++
+	}
+	reports := make(map[int]int)
+	for _, r := range m.reports {
+		reports[r.PostId]++
+	}
+	groups := make(map[string]*PostBreakdown)
+	authors := make(map[string]map[string]bool)
+	for _, p := range posts {
+		if p.Date.Before(from) || !p.Date.Before(to) {
+			continue
+		}
+		userID, _ := strconv.Atoi(p.UserId)
+		u := m.users[userID]
+		var group string
+		switch by {
+		case ByCategory:
+			group = p.Category
+		case ByGender:
+			group = strings.ToLower(strings.TrimSpace(u.Gender))
+		case ByProfession:
+			group = strings.ToLower(strings.TrimSpace(u.Profession))
+		case ByAge:
+			group = ageBucket(u.Age)
+		}
+		if group == "" {
+			group = "unknown"
+		}
+		b, ok := groups[group]
+		if !ok {
+			b = &PostBreakdown{Group: group}
+			groups[group] = b
+			authors[group] = make(map[string]bool)
+		}
+		b.Posts++
+		b.Comments += comments[p.Id]
+		b.Reports += reports[p.Id]
+		if p.UserId != "" {
+			authors[group][p.UserId] = true
+		}
+	}
+	breakdown := []PostBreakdown{}
+	for group, b := range groups {
+		b.Authors = len(authors[group])
+		breakdown = append(breakdown, *b)
+	}
+	sortBreakdown(breakdown)
+	return breakdown, nil
+}
+
+func (m *Memory) GetResponseTimes(ctx context.Context, psikologID int, from, to time.Time) ([]ResponseTime, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	first := make(map[int]time.Time)
+	for id, c := range m.comments {
+		if m.hiddenComments[id] || m.deletedComments[id] {
+			continue
+		}
+		if t, ok := first[c.PostId]; !ok || c.Date.Before(t) {
+			first[c.PostId] = *c.Date
+		}
+	}
+	times := []ResponseTime{}
+	for id := 1; id <= m.lastPostID; id++ {
+		p, ok := m.posts[id]
+		if !ok || p.Date.Before(from) || !p.Date.Before(to) {
+			continue
+		}
+		pid, _ := strconv.Atoi(p.PsikologId)
+		if psikologID != 0 && pid != psikologID {
+			continue
+		}
+		rt := ResponseTime{PostId: id, PsikologId: pid, PostDate: *p.Date}
+		if t, ok := first[id]; ok {
+			rt.FirstComment = &t
+		}
+		times = append(times, rt)
+	}
+	return times, nil
+}
+
+// answered is the set of posts with a visible comment.
+func (m *Memory) answered() map[int]bool {
+	answered := make(map[int]bool)
+	for id, c := range m.comments {
+		if !m.hiddenComments[id] && !m.deletedComments[id] {
+			answered[c.PostId] = true
+		}
+	}
+	return answered
+}
+
+func (m *Memory) GetOverduePosts(ctx context.Context, before time.Time) ([]OverduePost, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	last := make(map[int]time.Time)
+	count := make(map[int]int)
+	for _, a := range m.assignments {
+		last[a.PostId] = *a.Date
+		count[a.PostId]++
+	}
+	answered := m.answered()
+	var posts []OverduePost
+	for id, p := range m.posts {
+		at, ok := last[id]
+		if !ok && p.Date != nil {
+			// written before posts were routed
+			at, ok = *p.Date, true
+		}
+		if !ok || p.PsikologId == "" || answered[id] || !at.Before(before) {
+			continue
+		}
+		userID, _ := strconv.Atoi(p.UserId)
+		psikologID, _ := strconv.Atoi(p.PsikologId)
+		posts = append(posts, OverduePost{PostId: id, UserId: userID, PsikologId: psikologID, Category: p.Category, AssignedAt: at, Reassignments: max(count[id]-1, 0)})
+	}
+	sort.Slice(posts, func(i, j int) bool {
+		if !posts[i].AssignedAt.Equal(posts[j].AssignedAt) {
+			return posts[i].AssignedAt.Before(posts[j].AssignedAt)
+		}
+		return posts[i].PostId < posts[j].PostId
+	})
+	return posts, nil
+}
+
+// insertAssignment record a, the caller holds m.mu.
+func (m *Memory) insertAssignment(a *Assignment) {
+	now := time.Now()
+	m.lastAssignmentID++
+	a.Id = m.lastAssignmentID
+	a.Date = &now
+	m.assignments = append(m.assignments, *a)
+}
+
+func (m *Memory) EscalatePost(ctx context.Context, e *Escalation) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	p, ok := m.posts[e.PostId]
+	if !ok || p.PsikologId != strconv.Itoa(e.From) || m.answered()[e.PostId] {
+		return notFound()
+	}
+	if _, ok := m.psikologs[e.To]; !ok && e.To != 0 {
+		return foreignKeyViolation("posts", "posts_post_psikolog_id_fkey")
+	}
+	p.PsikologId = ""
+	if e.To != 0 {
+		p.PsikologId = strconv.Itoa(e.To)
+	}
+	m.posts[e.PostId] = p
+	m.insertAssignment(&Assignment{PostId: e.PostId, PsikologId: e.To, Reason: e.Reason})
+	for _, n := range e.notifications() {
+		now := time.Now()
+		m.lastNotificationID++
+		n.Id = m.lastNotificationID
+		n.Date = &now
+		m.notifications = append(m.notifications, n)
+	}
+	return nil
+}
+
+func (m *Memory) ClaimPost(ctx context.Context, p *Post, psikologID int, reason string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	post, ok := m.posts[p.Id]
+	if !ok || post.PsikologId != "" {
+		return notFound()
+	}
+	if _, ok := m.psikologs[psikologID]; !ok {
+		return foreignKeyViolation("posts", "posts_post_psikolog_id_fkey")
+	}
+	post.PsikologId = strconv.Itoa(psikologID)
+	m.posts[p.Id] = post
+	m.insertAssignment(&Assignment{PostId: p.Id, PsikologId: psikologID, Reason: reason})
+	p.PsikologId = post.PsikologId
+	return nil
+}
+
+func (m *Memory) GetPooledPosts(ctx context.Context) ([]Post, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	posts := []Post{}
+	for _, p := range m.posts {
+		if p.PsikologId == "" {
+			posts = append(posts, copyPost(p))
+		}
+	}
+	sort.Slice(posts, func(i, j int) bool { return posts[i].Id < posts[j].Id })
+	return posts, nil
+}
+
+func (m *Memory) GetNotifications(ctx context.Context, psikologID int) ([]Notification, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	notifications := []Notification{}
+	for i := len(m.notifications) - 1; i >= 0 && len(notifications) < maxNotifications; i-- {
+		if n := m.notifications[i]; n.PsikologId == psikologID {
+			notifications = append(notifications, n)
+		}
+	}
+	return notifications, nil
+}
+
+// copyCategory copy the maps of c, so callers cannot change the store.
+func copyCategory(c Category) Category {
+	names, descriptions := make(map[string]string), make(map[string]string)
+	for k, v := range c.Names {
+		names[k] = v
+	}
+	for k, v := range c.Descriptions {
+		descriptions[k] = v
+	}
+	c.Names, c.Descriptions = names, descriptions
+	return c
+}
+
+// copyPost return p with its own tags, never nil, so callers cannot change
+// the store.
+func copyPost(p Post) Post {
+	p.Tags = append([]string{}, p.Tags...)
+	return p
+}
+
+func (m *Memory) GetCategories(ctx context.Context) ([]Category, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	categories := []Category{}
+	for _, c := range m.categories {
+		categories = append(categories, copyCategory(c))
+	}
+	sort.Slice(categories, func(i, j int) bool {
+		if categories[i].Position != categories[j].Position {
+			return categories[i].Position < categories[j].Position
+		}
+		return categories[i].Slug < categories[j].Slug
+	})
+	return categories, nil
+}
+
+func (m *Memory) GetCategory(ctx context.Context, slug string) (Category, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c, ok := m.categories[slug]
+	if !ok {
+		return Category{}, notFound()
+	}
+	return copyCategory(c), nil
+}
+
+func (m *Memory) InsertCategory(ctx context.Context, c *Category) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.categories[c.Slug]; ok {
+		return uniqueViolation("categories_pkey")
+	}
+	m.categories[c.Slug] = copyCategory(*c)
+	return nil
+}
+
+func (m *Memory) UpdateCategory(ctx context.Context, c *Category) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.categories[c.Slug]; !ok {
+		return notFound()
+	}
+	m.categories[c.Slug] = copyCategory(*c)
+	return nil
+}
+
+func (m *Memory) DeleteCategory(ctx context.Context, slug, replace string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.categories[slug]; !ok {
+		return notFound()
+	}
+	if replace == "" {
+		used := false
+		for _, p := range m.posts {
+			used = used || p.Category == slug
+		}
+		for _, d := range m.deleted {
+			used = used || d.post.Category == slug
+		}
+		if used {
+			return &Error{Kind: ErrConflict, Constraint: categoryInUse, Err: fmt.Errorf("category %q is used by posts", slug)}
+		}
+	}
+	for id, p := range m.posts {
+		if p.Category == slug {
+			p.Category = replace
+			m.posts[id] = p
+		}
+	}
+	for id, d := range m.deleted {
+		if d.post.Category == slug {
+			d.post.Category = replace
+			m.deleted[id] = d
+		}
+	}
+	for id, ps := range m.psikologs {
+		var specs []string
+		changed := false
+		for _, s := range ps.Specializations {
+			if s == slug {
+				s, changed = replace, true
+			}
+			if s != "" && !contains(specs, s) {
+				specs = append(specs, s)
+			}
+		}
+		if changed {
+			ps.Specializations = specs
+			m.psikologs[id] = ps
+		}
+	}
+	delete(m.categories, slug)
+	return nil
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *Memory) GetTags(ctx context.Context, prefix string, limit int) ([]TagCount, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.tagCounts(func(p Post, tag string) bool { return strings.HasPrefix(tag, prefix) }, limit), nil
+}
+
+func (m *Memory) GetTrendingTags(ctx context.Context, since time.Time, limit int) ([]TagCount, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.tagCounts(func(p Post, tag string) bool { return !p.Date.Before(since) }, limit), nil
+}
+
+// tagCounts count the live posts by tag, for the tags keep accepts; the
+// caller holds m.mu.
+func (m *Memory) tagCounts(keep func(p Post, tag string) bool, limit int) []TagCount {
+	count := make(map[string]int)
+	for _, p := range m.posts {
+		for _, t := range p.Tags {
+			if keep(p, t) {
+				count[t]++
+			}
+		}
+	}
+	counts := []TagCount{}
+	for t, n := range count {
+		counts = append(counts, TagCount{Name: t, Posts: n})
+	}
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].Posts != counts[j].Posts {
+			return counts[i].Posts > counts[j].Posts
+		}
+		return counts[i].Name < counts[j].Name
+	})
+	if len(counts) > limit {
+		counts = counts[:limit]
+	}
+	return counts
+}
+
+func (m *Memory) GetPostsByTag(ctx context.Context, tag string, beforeID, limit int) ([]Post, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	posts := []Post{}
+	for id := m.lastPostID; id > 0 && len(posts) < limit; id-- {
+		p, ok := m.posts[id]
+		if !ok || (beforeID > 0 && id >= beforeID) || !contains(p.Tags, tag) {
+			continue
+		}
+		posts = append(posts, copyPost(p))
+	}
+	return posts, nil
+}
@@ -0,0 +1,88 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestPurgeDeletedPosts(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory()
+	u := User{Email: "user@example.com"}
+	p := Psikolog{Email: "psikolog@example.com", Name: "Dr. Tuesday"}
+	m.InsertUser(ctx, &u)
+	m.InsertPsikolog(ctx, &p)
+	post := Post{UserId: strconv.Itoa(u.Id), PsikologId: strconv.Itoa(p.Id), Title: "t", Category: "c", Content: "text"}
+	if err := m.InsertPost(ctx, &post); err != nil {
+		t.Fatal(err)
+	}
+	c := Comment{UserId: u.Id, PsikologId: p.Id, PostId: post.Id, Text: "hi"}
+	if err := m.InsertComment(ctx, &c); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.DeletePost(ctx, &post); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.GetComment(ctx, c.Id); !errors.Is(err, ErrNotFound) {
+		t.Errorf("comment of a deleted post: %v", err)
+	}
+
+	// kept for the retention period
+	if n, _ := m.PurgeDeletedPosts(ctx, time.Now().Add(-time.Hour)); n != 0 {
+		t.Errorf("purged %d posts deleted within the retention period", n)
+	}
+	if n, _ := m.PurgeDeletedPosts(ctx, time.Now().Add(time.Second)); n != 1 {
+		t.Errorf("purged %d posts, want 1", n)
+	}
+	if len(m.comments) != 0 || len(m.deleted) != 0 {
+		t.Errorf("comments %v, deleted %v left after purge", m.comments, m.deleted)
+	}
+}
+
+func TestOverdueUnassignedPost(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory()
+	u := User{Email: "user@example.com"}
+	p := Psikolog{Email: "psikolog@example.com", Name: "Dr. Tuesday"}
+	m.InsertUser(ctx, &u)
+	m.InsertPsikolog(ctx, &p)
+	// written before posts were routed, without an assignment
+	post := Post{UserId: strconv.Itoa(u.Id), PsikologId: strconv.Itoa(p.Id), Title: "t", Category: "c", Content: "text"}
+	if err := m.InsertPost(ctx, &post); err != nil {
+		t.Fatal(err)
+	}
+	if posts, _ := m.GetOverduePosts(ctx, post.Date.Add(-time.Minute)); len(posts) != 0 {
+		t.Errorf("overdue before its date: %+v", posts)
+	}
+	posts, err := m.GetOverduePosts(ctx, post.Date.Add(time.Minute))
+	if err != nil || len(posts) != 1 || !posts[0].AssignedAt.Equal(*post.Date) || posts[0].Reassignments != 0 {
+		t.Errorf("GetOverduePosts = %+v, %v", posts, err)
+	}
+}
+
+func TestResponseTimeDeletedComment(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory()
+	u := User{Email: "user@example.com"}
+	p := Psikolog{Email: "psikolog@example.com", Name: "Dr. Wednesday"}
+	m.InsertUser(ctx, &u)
+	m.InsertPsikolog(ctx, &p)
+	post := Post{UserId: strconv.Itoa(u.Id), PsikologId: strconv.Itoa(p.Id), Title: "t", Category: "c", Content: "text"}
+	if err := m.InsertPost(ctx, &post); err != nil {
+		t.Fatal(err)
+	}
+	c := Comment{UserId: u.Id, PsikologId: p.Id, PostId: post.Id, Text: "soon gone"}
+	if err := m.InsertComment(ctx, &c); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.DeleteComment(ctx, &c); err != nil {
+		t.Fatal(err)
+	}
+	times, err := m.GetResponseTimes(ctx, 0, post.Date.Add(-time.Minute), post.Date.Add(time.Minute))
+	if err != nil || len(times) != 1 || times[0].FirstComment != nil {
+		t.Errorf("GetResponseTimes = %+v, %v", times, err)
+	}
+}
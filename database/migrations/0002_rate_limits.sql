@@ -0,0 +1,8 @@
+-- Rate limit buckets shared by every dyno. The table is unlogged: losing
+-- it on a crash only resets the limits.
+CREATE UNLOGGED TABLE IF NOT EXISTS rate_limits (
+    rate_key text PRIMARY KEY,
+    rate_tokens double precision NOT NULL,
+    rate_allowed boolean NOT NULL DEFAULT true,
+    rate_updated_at timestamp with time zone NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
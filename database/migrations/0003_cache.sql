@@ -0,0 +1,7 @@
+-- Shared read cache, see cache.go. The table is unlogged: losing it on a
+-- crash only costs cache misses.
+CREATE UNLOGGED TABLE IF NOT EXISTS cache_entries (
+    cache_key text PRIMARY KEY,
+    cache_value bytea NOT NULL,
+    cache_expires_at timestamp with time zone NOT NULL
+);
@@ -0,0 +1,16 @@
+-- Posts are edited and soft deleted by their author. A deleted post is
+-- hidden everywhere and purged after the retention period; the previous
+-- versions of an edited post are kept in post_revisions.
+ALTER TABLE posts ADD COLUMN IF NOT EXISTS post_updated_at timestamp with time zone;
+ALTER TABLE posts ADD COLUMN IF NOT EXISTS post_deleted_at timestamp with time zone;
+CREATE INDEX IF NOT EXISTS posts_post_deleted_at_idx ON posts (post_deleted_at) WHERE post_deleted_at IS NOT NULL;
+
+CREATE TABLE IF NOT EXISTS post_revisions (
+    revision_id SERIAL PRIMARY KEY,
+    revision_post_id integer NOT NULL REFERENCES posts(post_id) ON DELETE CASCADE,
+    revision_date timestamp with time zone DEFAULT CURRENT_TIMESTAMP,
+    revision_title text,
+    revision_category text,
+    revision_content text
+);
+CREATE INDEX IF NOT EXISTS post_revisions_revision_post_id_idx ON post_revisions (revision_post_id);
@@ -0,0 +1,14 @@
+-- Comments are edited and deleted by their psikolog and hidden by
+-- moderators. Deleted and hidden comments are not read back; the previous
+-- versions of an edited comment are kept in comment_revisions.
+ALTER TABLE comments ADD COLUMN IF NOT EXISTS comment_updated_at timestamp with time zone;
+ALTER TABLE comments ADD COLUMN IF NOT EXISTS comment_deleted_at timestamp with time zone;
+ALTER TABLE comments ADD COLUMN IF NOT EXISTS comment_hidden_at timestamp with time zone;
+
+CREATE TABLE IF NOT EXISTS comment_revisions (
+    revision_id SERIAL PRIMARY KEY,
+    revision_comment_id integer NOT NULL REFERENCES comments(comment_id) ON DELETE CASCADE,
+    revision_date timestamp with time zone DEFAULT CURRENT_TIMESTAMP,
+    revision_text text
+);
+CREATE INDEX IF NOT EXISTS comment_revisions_revision_comment_id_idx ON comment_revisions (revision_comment_id);
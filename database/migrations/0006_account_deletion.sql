@@ -0,0 +1,19 @@
+-- Users ask for their account to be deleted; it is deleted, or their
+-- content anonymized, once user_delete_after has passed unless they cancel.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS user_deletion_requested_at timestamp with time zone;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS user_delete_after timestamp with time zone;
+CREATE INDEX IF NOT EXISTS users_user_delete_after_idx ON users (user_delete_after) WHERE user_delete_after IS NOT NULL;
+
+-- Anonymized posts, comments and wisdom points are kept with a NULL
+-- user; those columns were nullable from the start.
+
+-- Audit log of account changes. It outlives the users it is about, so
+-- audit_user_id is not a foreign key.
+CREATE TABLE IF NOT EXISTS audit_log (
+    audit_id SERIAL PRIMARY KEY,
+    audit_date timestamp with time zone NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    audit_action text NOT NULL,
+    audit_user_id integer,
+    audit_details text NOT NULL DEFAULT ''
+);
+CREATE INDEX IF NOT EXISTS audit_log_audit_user_id_idx ON audit_log (audit_user_id);
@@ -0,0 +1,14 @@
+-- Personal data exports. Rows are claimed by a worker, the ZIP is kept in
+-- export_data until the download link expires and the row is purged.
+CREATE TABLE IF NOT EXISTS exports (
+    export_id SERIAL PRIMARY KEY,
+    export_user_id integer NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+    export_status text NOT NULL DEFAULT 'pending' CHECK (export_status IN ('pending', 'running', 'done', 'failed')),
+    export_created_at timestamp with time zone NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    export_started_at timestamp with time zone,
+    export_finished_at timestamp with time zone,
+    export_expires_at timestamp with time zone,
+    export_token text UNIQUE,
+    export_data bytea
+);
+CREATE INDEX IF NOT EXISTS exports_pending_idx ON exports (export_id) WHERE export_status IN ('pending', 'running');
@@ -0,0 +1,12 @@
+-- Analytics count new users and reports by day, which needs their dates.
+-- Rows created before this migration have no date and are left out; the
+-- DEFAULT is set after the column is added so they are not all dated today.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS user_created_at timestamp with time zone;
+ALTER TABLE users ALTER COLUMN user_created_at SET DEFAULT CURRENT_TIMESTAMP;
+ALTER TABLE reports ADD COLUMN IF NOT EXISTS report_date timestamp with time zone;
+ALTER TABLE reports ALTER COLUMN report_date SET DEFAULT CURRENT_TIMESTAMP;
+
+CREATE INDEX IF NOT EXISTS users_user_created_at_idx ON users (user_created_at);
+CREATE INDEX IF NOT EXISTS reports_report_date_idx ON reports (report_date);
+CREATE INDEX IF NOT EXISTS posts_post_date_idx ON posts (post_date);
+CREATE INDEX IF NOT EXISTS comments_comment_date_idx ON comments (comment_date);
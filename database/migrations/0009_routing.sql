@@ -0,0 +1,20 @@
+-- Posts can be routed by the server to a psikolog who takes their
+-- category, is available and verified. Specializations are a JSON array of
+-- categories; a psikolog without any takes every category. Psikologs
+-- registered so far were vetted by hand, so they start verified.
+ALTER TABLE psikologs ADD COLUMN IF NOT EXISTS psikolog_specializations jsonb NOT NULL DEFAULT '[]';
+ALTER TABLE psikologs ADD COLUMN IF NOT EXISTS psikolog_available boolean NOT NULL DEFAULT true;
+ALTER TABLE psikologs ADD COLUMN IF NOT EXISTS psikolog_verified boolean;
+UPDATE psikologs SET psikolog_verified = true WHERE psikolog_verified IS NULL;
+ALTER TABLE psikologs ALTER COLUMN psikolog_verified SET DEFAULT false;
+ALTER TABLE psikologs ALTER COLUMN psikolog_verified SET NOT NULL;
+
+-- Every psikolog a post was given to, and why.
+CREATE TABLE IF NOT EXISTS post_assignments (
+    assignment_id SERIAL PRIMARY KEY,
+    assignment_post_id integer NOT NULL REFERENCES posts(post_id) ON DELETE CASCADE,
+    assignment_psikolog_id integer REFERENCES psikologs(psikolog_id) ON DELETE SET NULL,
+    assignment_date timestamp with time zone NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    assignment_reason text NOT NULL
+);
+CREATE INDEX IF NOT EXISTS post_assignments_post_id_idx ON post_assignments (assignment_post_id);
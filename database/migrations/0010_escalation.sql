@@ -0,0 +1,13 @@
+-- Posts left unanswered by their psikolog are escalated: given to another
+-- psikolog, or to the open pool (post_psikolog_id NULL) any verified
+-- psikolog can claim them from. The psikologs and admins involved are
+-- notified; a NULL notification_psikolog_id is for the admins.
+CREATE TABLE IF NOT EXISTS notifications (
+    notification_id SERIAL PRIMARY KEY,
+    notification_psikolog_id integer REFERENCES psikologs(psikolog_id) ON DELETE CASCADE,
+    notification_post_id integer NOT NULL REFERENCES posts(post_id) ON DELETE CASCADE,
+    notification_kind text NOT NULL,
+    notification_date timestamp with time zone NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS notifications_psikolog_id_idx ON notifications (notification_psikolog_id, notification_id);
+CREATE INDEX IF NOT EXISTS posts_pool_idx ON posts (post_id) WHERE post_psikolog_id IS NULL AND post_deleted_at IS NULL;
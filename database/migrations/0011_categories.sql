@@ -0,0 +1,39 @@
+-- Categories of posts are managed by admins instead of free text. A post
+-- and a psikolog specialization refer to a category by its slug; names
+-- and descriptions are by language, e.g. {"en": "Family", "id": "Keluarga"}.
+CREATE TABLE IF NOT EXISTS categories (
+    category_slug text PRIMARY KEY CHECK (category_slug ~ '^[a-z0-9]+(-[a-z0-9]+)*$'),
+    category_names jsonb NOT NULL DEFAULT '{}',
+    category_descriptions jsonb NOT NULL DEFAULT '{}',
+    category_icon text NOT NULL DEFAULT '',
+    category_position integer NOT NULL DEFAULT 0,
+    category_active boolean NOT NULL DEFAULT true
+);
+
+-- category_slug turn free text into a slug: "Family ", "FAMILY" and
+-- "family" are all family, nothing at all is other. Keep in step with
+-- categorySlug of the server.
+CREATE OR REPLACE FUNCTION category_slug(t text) RETURNS text AS $$
+    SELECT COALESCE(NULLIF(trim(both '-' from regexp_replace(lower(COALESCE(t, '')), '[^a-z0-9]+', '-', 'g')), ''), 'other')
+$$ LANGUAGE sql IMMUTABLE;
+
+-- every category used so far, named after its most common spelling and
+-- ordered by posts, most first. Free text left many spellings and typos,
+-- so only the ten most used are active; admins review the others, which
+-- keep their posts but are not offered for new ones.
+INSERT INTO categories (category_slug, category_names, category_position, category_active)
+SELECT slug, jsonb_build_object('en', COALESCE(NULLIF(name, ''), initcap(replace(slug, '-', ' ')))), 10 * rank, rank <= 10
+FROM (
+    SELECT slug, name, row_number() OVER (ORDER BY posts DESC, slug) AS rank
+    FROM (
+        SELECT category_slug(post_category) AS slug, mode() WITHIN GROUP (ORDER BY trim(post_category)) AS name, count(*) AS posts
+        FROM posts GROUP BY 1
+    ) used
+) ranked
+ON CONFLICT (category_slug) DO NOTHING;
+
+UPDATE posts SET post_category = category_slug(post_category)
+WHERE post_category IS DISTINCT FROM category_slug(post_category);
+UPDATE psikologs SET psikolog_specializations = (
+    SELECT COALESCE(jsonb_agg(DISTINCT category_slug(s)), '[]') FROM jsonb_array_elements_text(psikolog_specializations) s
+) WHERE psikolog_specializations <> '[]';
@@ -0,0 +1,5 @@
+-- Posts carry free-form tags next to their category, as a JSON array of
+-- normalized names, e.g. ["exam", "family"]. The GIN index serves the
+-- posts of a tag; autocomplete and trending count over the array.
+ALTER TABLE posts ADD COLUMN IF NOT EXISTS post_tags jsonb NOT NULL DEFAULT '[]';
+CREATE INDEX IF NOT EXISTS posts_tags_idx ON posts USING gin (post_tags);
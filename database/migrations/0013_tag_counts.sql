@@ -0,0 +1,39 @@
+-- Autocomplete counted the tags of every post on each keystroke. tags keep
+-- the number of live posts of each tag instead, maintained by a trigger on
+-- posts so that edits, soft deletes and cascades count too. A tag no live
+-- post carries is removed. The C collation lets the primary key serve the
+-- prefix ranges of autocomplete.
+CREATE TABLE IF NOT EXISTS tags (
+    tag_name text COLLATE "C" PRIMARY KEY,
+    tag_posts integer NOT NULL
+);
+
+CREATE OR REPLACE FUNCTION count_post_tags() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP <> 'INSERT' THEN
+        IF OLD.post_deleted_at IS NULL THEN
+            UPDATE tags SET tag_posts = tag_posts - 1
+            WHERE tag_name IN (SELECT jsonb_array_elements_text(OLD.post_tags));
+            DELETE FROM tags
+            WHERE tag_name IN (SELECT jsonb_array_elements_text(OLD.post_tags)) AND tag_posts <= 0;
+        END IF;
+    END IF;
+    IF TG_OP <> 'DELETE' THEN
+        IF NEW.post_deleted_at IS NULL THEN
+            INSERT INTO tags (tag_name, tag_posts)
+            SELECT DISTINCT t, 1 FROM jsonb_array_elements_text(NEW.post_tags) t ORDER BY 1
+            ON CONFLICT (tag_name) DO UPDATE SET tag_posts = tags.tag_posts + 1;
+        END IF;
+    END IF;
+    RETURN NULL;
+END
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS posts_count_tags ON posts;
+CREATE TRIGGER posts_count_tags AFTER INSERT OR DELETE OR UPDATE OF post_tags, post_deleted_at ON posts
+FOR EACH ROW EXECUTE PROCEDURE count_post_tags();
+
+INSERT INTO tags (tag_name, tag_posts)
+SELECT t, count(*) FROM posts, jsonb_array_elements_text(post_tags) t
+WHERE post_deleted_at IS NULL GROUP BY t
+ON CONFLICT (tag_name) DO UPDATE SET tag_posts = EXCLUDED.tag_posts;
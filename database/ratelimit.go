@@ -0,0 +1,40 @@
+package database
+
+import (
+	"context"
+	"time"
+)
+
+// takeTokenQuery refill the bucket of $1 at $2 tokens per second up to $3
+// tokens, then take one token if there is one. The whole step is a single
+// statement so concurrent dynos cannot both take the last token.
+const takeTokenQuery = `
+INSERT INTO rate_limits AS r (rate_key, rate_tokens, rate_allowed, rate_updated_at)
+VALUES ($1, $3::float8 - 1, true, now())
+ON CONFLICT (rate_key) DO UPDATE SET
+    rate_allowed = LEAST($3::float8, r.rate_tokens + EXTRACT(EPOCH FROM now() - r.rate_updated_at) * $2::float8) >= 1,
+    rate_tokens = LEAST($3::float8, r.rate_tokens + EXTRACT(EPOCH FROM now() - r.rate_updated_at) * $2::float8)
+        - CASE WHEN LEAST($3::float8, r.rate_tokens + EXTRACT(EPOCH FROM now() - r.rate_updated_at) * $2::float8) >= 1 THEN 1 ELSE 0 END,
+    rate_updated_at = now()
+RETURNING rate_allowed, rate_tokens`
+
+// TakeToken take a token from the rate limit bucket of key, which holds at
+// most burst tokens and refills at rate tokens per second. It returns
+// whether a token was taken and how many are left.
+func (db *Database) TakeToken(ctx context.Context, key string, rate float64, burst int) (bool, float64, error) {
+	defer db.observe("TakeToken", time.Now())
+	var allowed bool
+	var tokens float64
+	err := db.Conn.QueryRowContext(ctx, takeTokenQuery, key, rate, burst).Scan(&allowed, &tokens)
+	return allowed, tokens, err
+}
+
+// PurgeRateLimits delete the buckets not used for longer than age. Such a
+// bucket is full again anyway.
+func (db *Database) PurgeRateLimits(ctx context.Context, age time.Duration) (int64, error) {
+	res, err := db.Conn.ExecContext(ctx, `DELETE FROM rate_limits WHERE rate_updated_at < now() - $1::float8 * interval '1 second'`, age.Seconds())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
@@ -0,0 +1,122 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrNotReady is returned by Database methods until its statements are
+// prepared.
+var ErrNotReady = errors.New("database: statements are not prepared")
+
+// ErrPostsNotFound is returned by GetAllPostsByUserID when the user has not
+// written any post yet. It matches ErrNotFound.
+var ErrPostsNotFound error = &Error{Kind: ErrNotFound, Err: errors.New("cannot found a list of posts")}
+
+// Store is the set of operations the HTTP handlers need from the storage
+// layer. Database implements it on top of PostgreSQL and Memory implements
+// it in-process for tests and demo mode.
+//
+// Insert methods set the Id of their argument to the ID of the new row, and
+// the Date of posts and comments.
+// Deleted posts are hidden from every method but PurgeDeletedPosts, and
+// cannot be commented or reported. Deleted and hidden comments are hidden
+// from every method but SetCommentHidden, which restores hidden ones.
+// Errors caused by the data rather than the database match one of the
+// domain errors in errors.go.
+type Store interface {
+	// users
+	InsertUser(ctx context.Context, user *User) error
+	GetUser(ctx context.Context, id int) (User, error)
+
+	// account deletion & audit log
+	ScheduleUserDeletion(ctx context.Context, d *UserDeletion) error
+	GetUserDeletion(ctx context.Context, userID int) (UserDeletion, error)
+	CancelUserDeletion(ctx context.Context, userID int) error
+	GetDueUserDeletions(ctx context.Context, now time.Time) ([]int, error)
+	CompleteUserDeletion(ctx context.Context, userID int, now time.Time, anonymize bool) error
+	InsertAuditEntry(ctx context.Context, a *AuditEntry) error
+	GetAuditEntries(ctx context.Context, userID int) ([]AuditEntry, error)
+
+	// personal data exports
+	GetUserData(ctx context.Context, userID int) (UserData, error)
+	InsertExport(ctx context.Context, e *Export) error
+	GetExport(ctx context.Context, id int) (Export, error)
+	ClaimExport(ctx context.Context, staleBefore time.Time) (Export, error)
+	FinishExport(ctx context.Context, e *Export, data []byte) error
+	GetExportFile(ctx context.Context, token string) (Export, []byte, error)
+	PurgeExports(ctx context.Context, before time.Time) (int64, error)
+
+	// psikologs/relievers
+	InsertPsikolog(ctx context.Context, p *Psikolog) error
+	GetPsikolog(ctx context.Context, id int) (Psikolog, error)
+	UpdatePsikolog(ctx context.Context, p *Psikolog) error
+	GetPsikologByID(ctx context.Context, psikolog_id string) (Reliever, error)
+	SetPsikologVerified(ctx context.Context, id int, verified bool) error
+
+	// routing
+	GetRoutingCandidates(ctx context.Context) ([]RoutingCandidate, error)
+	InsertAssignment(ctx context.Context, a *Assignment) error
+	GetAssignments(ctx context.Context, postID int) ([]Assignment, error)
+
+	// categories
+	GetCategories(ctx context.Context) ([]Category, error)
+	GetCategory(ctx context.Context, slug string) (Category, error)
+	InsertCategory(ctx context.Context, c *Category) error
+	UpdateCategory(ctx context.Context, c *Category) error
+	DeleteCategory(ctx context.Context, slug, replace string) error
+
+	// tags
+	GetTags(ctx context.Context, prefix string, limit int) ([]TagCount, error)
+	GetTrendingTags(ctx context.Context, since time.Time, limit int) ([]TagCount, error)
+	GetPostsByTag(ctx context.Context, tag string, beforeID, limit int) ([]Post, error)
+
+	// escalation
+	GetOverduePosts(ctx context.Context, before time.Time) ([]OverduePost, error)
+	EscalatePost(ctx context.Context, e *Escalation) error
+	ClaimPost(ctx context.Context, p *Post, psikologID int, reason string) error
+	GetPooledPosts(ctx context.Context) ([]Post, error)
+	GetNotifications(ctx context.Context, psikologID int) ([]Notification, error)
+
+	// posts
+	InsertPost(ctx context.Context, p *Post) error
+	GetPost(ctx context.Context, id int) (Post, error)
+	GetAllPostsByUserID(ctx context.Context, userID string) ([]Post, error)
+	UpdatePost(ctx context.Context, p *Post) error
+	DeletePost(ctx context.Context, p *Post) error
+	GetPostRevisions(ctx context.Context, postID int) ([]PostRevision, error)
+	PurgeDeletedPosts(ctx context.Context, before time.Time) (int64, error)
+
+	// comments & reports
+	InsertComment(ctx context.Context, c *Comment) error
+	GetComment(ctx context.Context, id int) (Comment, error)
+	GetCommentsByPostID(ctx context.Context, postID int) ([]Comment, error)
+	UpdateComment(ctx context.Context, c *Comment) error
+	DeleteComment(ctx context.Context, c *Comment) error
+	SetCommentHidden(ctx context.Context, c *Comment, hidden bool) error
+	GetCommentRevisions(ctx context.Context, commentID int) ([]CommentRevision, error)
+	InsertReport(ctx context.Context, r *Report) error
+	GetReport(ctx context.Context, id int) (Report, error)
+
+	// analytics
+	GetActivity(ctx context.Context, interval string, from, to time.Time) ([]ActivityPoint, error)
+	GetPostBreakdown(ctx context.Context, by string, from, to time.Time) ([]PostBreakdown, error)
+	GetResponseTimes(ctx context.Context, psikologID int, from, to time.Time) ([]ResponseTime, error)
+
+	// wisdom points
+	GetWisdomPointByID(ctx context.Context, id string) (PsikologPoint, error)
+	CheckWisdomPoint(ctx context.Context, user_id string, psikolog_id string) (WisdomPointStatus, error)
+	InsertWisdomPoint(ctx context.Context, w *WisdomPoint) error
+
+	// Ping check the store can serve queries.
+	Ping(ctx context.Context) error
+
+	// Close releases every resource held by the store.
+	Close() error
+}
+
+var (
+	_ Store = (*Database)(nil)
+	_ Store = (*Memory)(nil)
+)
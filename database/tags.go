@@ -0,0 +1,110 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+)
+
+// TagCount is a tag with the number of live posts carrying it.
+type TagCount struct {
+	Name  string `json:"tag_name"`
+	Posts int    `json:"tag_posts"`
+}
+
+// postColumns are the columns of a Post read by scanPost, in the order of
+// its fields.
+const postColumns = `post_id, COALESCE(post_user_id::text, ''), COALESCE(post_psikolog_id::text, ''), post_date, post_title, post_category, post_content, post_image_url, post_report_count, post_updated_at, post_tags::text`
+
+// scanPost scan the postColumns of row into p, then the extra columns.
+func scanPost(row interface{ Scan(...interface{}) error }, p *Post, extra ...interface{}) error {
+	var tags string
+	dest := append([]interface{}{&p.Id, &p.UserId, &p.PsikologId, &p.Date, &p.Title, &p.Category, &p.Content, &p.ImageURL, &p.ReportCount, &p.UpdatedAt, &tags}, extra...)
+	if err := row.Scan(dest...); err != nil {
+		return err
+	}
+	return json.Unmarshal([]byte(tags), &p.Tags)
+}
+
+// tags encode the tags of p for a jsonb column.
+func tags(p *Post) string {
+	if len(p.Tags) == 0 {
+		return "[]"
+	}
+	b, _ := json.Marshal(p.Tags)
+	return string(b)
+}
+
+// GetTags get up to limit tags of live posts starting with prefix, the most
+// used first. They are read from the counts of migration 0013; a tag is
+// lowercase letters, digits and dashes, so every tag starting with prefix
+// sorts below prefix followed by a tilde.
+func (db *Database) GetTags(ctx context.Context, prefix string, limit int) ([]TagCount, error) {
+	defer db.observe("GetTags", time.Now())
+	if !db.Prepared() {
+		return nil, ErrNotReady
+	}
+	return db.tagCounts(ctx, `SELECT tag_name, tag_posts FROM tags
+WHERE tag_name >= $1 AND tag_name < $1 || '~'
+ORDER BY tag_posts DESC, tag_name LIMIT $2`, prefix, limit)
+}
+
+// GetTrendingTags get up to limit tags of the live posts written since
+// since, the most used first.
+func (db *Database) GetTrendingTags(ctx context.Context, since time.Time, limit int) ([]TagCount, error) {
+	defer db.observe("GetTrendingTags", time.Now())
+	if !db.Prepared() {
+		return nil, ErrNotReady
+	}
+	return db.tagCounts(ctx, `SELECT t, count(*) FROM posts, jsonb_array_elements_text(post_tags) t
+WHERE post_deleted_at IS NULL AND post_date >= $1
+GROUP BY t ORDER BY count(*) DESC, t LIMIT $2`, since, limit)
+}
+
+func (db *Database) tagCounts(ctx context.Context, query string, args ...interface{}) ([]TagCount, error) {
+	rows, err := db.Conn.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, translate(err)
+	}
+	defer rows.Close()
+	counts := []TagCount{}
+	for rows.Next() {
+		var c TagCount
+		if err := rows.Scan(&c.Name, &c.Posts); err != nil {
+			return nil, translate(err)
+		}
+		counts = append(counts, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, translate(err)
+	}
+	return counts, nil
+}
+
+// GetPostsByTag get up to limit live posts carrying tag, newest first.
+// With beforeID, only the posts before it are read, to page through them.
+func (db *Database) GetPostsByTag(ctx context.Context, tag string, beforeID, limit int) ([]Post, error) {
+	defer db.observe("GetPostsByTag", time.Now())
+	if !db.Prepared() {
+		return nil, ErrNotReady
+	}
+	rows, err := db.Conn.QueryContext(ctx, `SELECT `+postColumns+` FROM posts
+WHERE post_tags ? $1 AND post_deleted_at IS NULL AND ($2::integer = 0 OR post_id < $2::integer)
+ORDER BY post_id DESC LIMIT $3`, tag, beforeID, limit)
+	if err != nil {
+		return nil, translate(err)
+	}
+	defer rows.Close()
+	posts := []Post{}
+	for rows.Next() {
+		var p Post
+		if err := scanPost(rows, &p); err != nil {
+			return nil, translate(err)
+		}
+		posts = append(posts, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, translate(err)
+	}
+	return posts, nil
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/pyk/relieve/database"
+)
+
+// Posts assigned config.EscalateAfter ago and still without a visible
+// comment are escalated every few minutes. In "reassign" mode the post is
+// routed again, its psikolog aside, until it changed psikolog
+// config.MaxReassignments times; then, in "pool" mode or when nobody can
+// take it, it goes to the open pool. The psikologs involved and the admins
+// are notified, and each step is kept in post_assignments.
+
+// escalationInterval is how often overdue posts are looked for.
+const escalationInterval = 10 * time.Minute
+
+// escalatePosts escalate the overdue posts every escalationInterval.
+func escalatePosts(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(escalationInterval):
+		}
+		escalateDuePosts(ctx, time.Now())
+	}
+}
+
+// escalateDuePosts escalate the posts overdue at now.
+func escalateDuePosts(ctx context.Context, now time.Time) {
+	overdue, err := db.GetOverduePosts(ctx, now.Add(-config.EscalateAfter))
+	if err != nil {
+		if !errors.Is(err, database.ErrNotReady) {
+			slog.Error("List overdue posts", "err", err)
+		}
+		return
+	}
+	if len(overdue) == 0 {
+		return
+	}
+	var candidates []database.RoutingCandidate
+	if config.EscalationMode == "reassign" {
+		candidates, err = db.GetRoutingCandidates(ctx)
+		if err != nil {
+			// the posts go to the pool rather than wait for the next run
+			slog.Error("List routing candidates", "err", err)
+		}
+	}
+	for _, o := range overdue {
+		e := database.Escalation{PostId: o.PostId, UserId: o.UserId, From: o.PsikologId, Reason: reasonPool}
+		if config.EscalationMode == "reassign" && o.Reassignments < config.MaxReassignments {
+			if id, _, err := route(others(candidates, o.PsikologId), o.Category); err == nil {
+				e.To, e.Reason = id, reasonEscalated
+			}
+		}
+		err := db.EscalatePost(ctx, &e)
+		if errors.Is(err, database.ErrNotFound) {
+			// answered or deleted in the meantime
+			continue
+		}
+		if err != nil {
+			slog.Error("Escalate post", "post_id", o.PostId, "err", err)
+			continue
+		}
+		// later posts of this run see the new load
+		for i := range candidates {
+			switch candidates[i].Id {
+			case e.From:
+				candidates[i].OpenPosts--
+			case e.To:
+				candidates[i].OpenPosts++
+			}
+		}
+		slog.Info("Post escalated", "post_id", o.PostId, "from", e.From, "to", e.To, "reason", e.Reason)
+	}
+}
+
+// others return the candidates but the psikolog id.
+func others(candidates []database.RoutingCandidate, id int) []database.RoutingCandidate {
+	var list []database.RoutingCandidate
+	for _, c := range candidates {
+		if c.Id != id {
+			list = append(list, c)
+		}
+	}
+	return list
+}
+
+// verifiedPsikolog return the psikolog_id of the request when the psikolog
+// is verified.
+func verifiedPsikolog(tag string, r *http.Request) (int, *apiError) {
+	psikologID, apiErr := queryID(tag, r, "psikolog_id")
+	if apiErr != nil {
+		return 0, apiErr
+	}
+	ps, err := db.GetPsikolog(r.Context(), psikologID)
+	if err != nil {
+		return 0, storeError(tag+" db.GetPsikolog", err, "psikolog.not_found")
+	}
+	if !ps.Verified {
+		return 0, &apiError{Tag: tag, Error: errors.New("psikolog not verified"), ID: "psikolog.not_verified", Code: http.StatusForbidden}
+	}
+	return psikologID, nil
+}
+
+// GET /v1/pool?psikolog_id=ID the posts of the open pool, oldest first, for
+// verified psikologs to claim and for moderators.
+func v1ListPool(w http.ResponseWriter, r *http.Request) *apiError {
+	if !isAdmin(r) {
+		if _, apiErr := verifiedPsikolog("v1ListPool", r); apiErr != nil {
+			return apiErr
+		}
+	}
+	posts, err := db.GetPooledPosts(r.Context())
+	if err != nil {
+		return storeError("v1ListPool db.GetPooledPosts", err, "")
+	}
+	return writeJSON("v1ListPool", w, http.StatusOK, posts)
+}
+
+// POST /v1/posts/{id}/claim?psikolog_id=ID take a post of the open pool,
+// for verified psikologs.
+func v1ClaimPost(w http.ResponseWriter, r *http.Request) *apiError {
+	tag := "v1ClaimPost"
+	id, apiErr := pathID(tag, r, "id")
+	if apiErr != nil {
+		return apiErr
+	}
+	psikologID, apiErr := verifiedPsikolog(tag, r)
+	if apiErr != nil {
+		return apiErr
+	}
+	p, err := db.GetPost(r.Context(), id)
+	if err != nil {
+		return storeError(tag+" db.GetPost", err, "post.not_found")
+	}
+	err = db.ClaimPost(r.Context(), &p, psikologID, reasonClaimed)
+	if errors.Is(err, database.ErrNotFound) {
+		return &apiError{Tag: tag, Error: err, ID: "post.not_pooled", Code: http.StatusConflict}
+	}
+	if err != nil {
+		return storeError(tag+" db.ClaimPost", err, "")
+	}
+	slog.InfoContext(r.Context(), "Post claimed", "post_id", id, "psikolog_id", psikologID)
+	return writeJSON(tag, w, http.StatusOK, p)
+}
+
+// GET /v1/psikologs/{id}/notifications?psikolog_id=ID the latest
+// notifications of a psikolog, newest first, for the psikolog or a moderator.
+func v1ListNotifications(w http.ResponseWriter, r *http.Request) *apiError {
+	tag := "v1ListNotifications"
+	id, apiErr := pathID(tag, r, "id")
+	if apiErr != nil {
+		return apiErr
+	}
+	if !isAdmin(r) {
+		who, apiErr := queryID(tag, r, "psikolog_id")
+		if apiErr != nil {
+			return apiErr
+		}
+		if who != id {
+			return forbidden(tag, fmt.Errorf("psikolog_id %d is not psikolog %d", who, id), "psikolog.not_self")
+		}
+	}
+	if _, err := db.GetPsikolog(r.Context(), id); err != nil {
+		return storeError(tag+" db.GetPsikolog", err, "psikolog.not_found")
+	}
+	notifications, err := db.GetNotifications(r.Context(), id)
+	if err != nil {
+		return storeError(tag+" db.GetNotifications", err, "")
+	}
+	return writeJSON(tag, w, http.StatusOK, notifications)
+}
+
+// GET /v1/admin/notifications the latest notifications of the admins,
+// newest first.
+func v1ListAdminNotifications(w http.ResponseWriter, r *http.Request) *apiError {
+	tag := "v1ListAdminNotifications"
+	if apiErr := requireAdmin(tag, r); apiErr != nil {
+		return apiErr
+	}
+	notifications, err := db.GetNotifications(r.Context(), 0)
+	if err != nil {
+		return storeError(tag+" db.GetNotifications", err, "")
+	}
+	return writeJSON(tag, w, http.StatusOK, notifications)
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/pyk/relieve/database"
+)
+
+func TestEscalation(t *testing.T) {
+	ts := newTestServer(t)
+	if _, ok := db.(*database.Memory); !ok {
+		// other posts of a shared database would be escalated too
+		t.Skip("needs the in-memory store")
+	}
+	defer func(c Config) { config = c }(config)
+	config.AdminToken = "admin-secret"
+	config.EscalateAfter = time.Hour
+	config.EscalationMode = "reassign"
+	config.MaxReassignments = 1
+	ctx := context.Background()
+
+	var user database.User
+	resp, body := postJSON(t, ts.URL+"/v1/users", database.User{Email: uniqueEmail("escalate")})
+	checkCreated(t, resp, body, &user)
+	var psikologs [3]database.Psikolog
+	for i := range psikologs {
+		resp, body = postJSON(t, ts.URL+"/v1/psikologs", database.Psikolog{Email: uniqueEmail("escalate"), Name: "Dr. Late", Available: true})
+		loc := checkCreated(t, resp, body, &psikologs[i])
+		if i < 2 {
+			resp, body = doAdmin(t, "PUT", ts.URL+loc+"/verified", config.AdminToken)
+			checkStatus(t, resp, body, http.StatusNoContent)
+		}
+	}
+	a, b, unverified := psikologs[0].Id, psikologs[1].Id, psikologs[2].Id
+	newPost := func(psikologID string) (database.Post, string) {
+		var p database.Post
+		resp, body := postJSON(t, ts.URL+"/v1/posts", database.Post{UserId: strconv.Itoa(user.Id), PsikologId: psikologID, Title: "t", Category: "c", Content: "c"})
+		return p, checkCreated(t, resp, body, &p)
+	}
+	post, loc := newPost(autoAssign)
+	if post.PsikologId != strconv.Itoa(a) {
+		t.Fatalf("routed to %s, want %d", post.PsikologId, a)
+	}
+	answered, answeredLoc := newPost(strconv.Itoa(a))
+	resp, body = postJSON(t, ts.URL+answeredLoc+"/comments", map[string]interface{}{
+		"comment_user_id": user.Id, "comment_psikolog_id": a, "comment_text": "on time",
+	})
+	checkStatus(t, resp, body, http.StatusCreated)
+	psikologOf := func(id int) string {
+		p, err := db.GetPost(ctx, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return p.PsikologId
+	}
+	notifications := func(path, token string) []database.Notification {
+		resp, body := doAdmin(t, "GET", ts.URL+path, token)
+		checkStatus(t, resp, body, http.StatusOK)
+		var n []database.Notification
+		decodeArray(t, body, &n)
+		return n
+	}
+
+	// nothing is due yet
+	escalateDuePosts(ctx, time.Now())
+	if got := psikologOf(post.Id); got != strconv.Itoa(a) {
+		t.Errorf("psikolog before the window = %s", got)
+	}
+
+	// the post goes to the other psikolog, the answered one stays
+	escalateDuePosts(ctx, time.Now().Add(2*time.Hour))
+	if got := psikologOf(post.Id); got != strconv.Itoa(b) {
+		t.Errorf("psikolog after escalation = %s, want %d", got, b)
+	}
+	if got := psikologOf(answered.Id); got != strconv.Itoa(a) {
+		t.Errorf("answered post moved to %s", got)
+	}
+	for _, n := range []struct {
+		path string
+		kind string
+	}{
+		{"/v1/psikologs/" + strconv.Itoa(a) + "/notifications", database.NotifyReassigned},
+		{"/v1/psikologs/" + strconv.Itoa(b) + "/notifications", database.NotifyAssigned},
+		{"/v1/admin/notifications", database.NotifyReassigned},
+	} {
+		if got := notifications(n.path, config.AdminToken); len(got) != 1 || got[0].Kind != n.kind || got[0].PostId != post.Id {
+			t.Errorf("GET %s = %+v, want %s", n.path, got, n.kind)
+		}
+	}
+	resp, body = do(t, "GET", ts.URL+"/v1/admin/notifications", "", "")
+	checkV1Error(t, resp, body, http.StatusUnauthorized, "admin.unauthorized")
+
+	// reassigned once already, the post goes to the pool
+	escalateDuePosts(ctx, time.Now().Add(4*time.Hour))
+	if got := psikologOf(post.Id); got != "" {
+		t.Errorf("psikolog of a pooled post = %s", got)
+	}
+	bPath := "/v1/psikologs/" + strconv.Itoa(b) + "/notifications"
+	if got := notifications(bPath+"?psikolog_id="+strconv.Itoa(b), ""); len(got) != 2 || got[0].Kind != database.NotifyPooled {
+		t.Errorf("notifications of %d = %+v", b, got)
+	}
+	resp, body = do(t, "GET", ts.URL+bPath, "", "")
+	checkV1Error(t, resp, body, http.StatusBadRequest, "request.missing_parameter")
+	resp, body = do(t, "GET", ts.URL+bPath+"?psikolog_id="+strconv.Itoa(a), "", "")
+	checkV1Error(t, resp, body, http.StatusForbidden, "psikolog.not_self")
+
+	// only verified psikologs and moderators see the pool
+	resp, body = do(t, "GET", ts.URL+"/v1/pool", "", "")
+	checkV1Error(t, resp, body, http.StatusBadRequest, "request.missing_parameter")
+	resp, body = do(t, "GET", ts.URL+"/v1/pool?psikolog_id="+strconv.Itoa(unverified), "", "")
+	checkV1Error(t, resp, body, http.StatusForbidden, "psikolog.not_verified")
+	resp, body = doAdmin(t, "GET", ts.URL+"/v1/pool", config.AdminToken)
+	checkStatus(t, resp, body, http.StatusOK)
+	resp, body = do(t, "GET", ts.URL+"/v1/pool?psikolog_id="+strconv.Itoa(a), "", "")
+	checkStatus(t, resp, body, http.StatusOK)
+	var pool []database.Post
+	decodeArray(t, body, &pool)
+	if len(pool) != 1 || pool[0].Id != post.Id {
+		t.Errorf("GET /v1/pool = %s", body)
+	}
+
+	// the author can still edit a pooled post
+	resp, body = do(t, "PATCH", ts.URL+loc+"?user_id="+strconv.Itoa(user.Id), "application/json", `{"post_title": "still waiting"}`)
+	checkStatus(t, resp, body, http.StatusOK)
+
+	claim := func(psikologID int) (*http.Response, string) {
+		return do(t, "POST", ts.URL+loc+"/claim?psikolog_id="+strconv.Itoa(psikologID), "", "")
+	}
+	resp, body = claim(unverified)
+	checkV1Error(t, resp, body, http.StatusForbidden, "psikolog.not_verified")
+	resp, body = do(t, "POST", ts.URL+loc+"/claim", "", "")
+	checkV1Error(t, resp, body, http.StatusBadRequest, "request.missing_parameter")
+	if got := psikologOf(post.Id); got != "" {
+		t.Errorf("refused claims gave the post to %s", got)
+	}
+	resp, body = claim(a)
+	checkStatus(t, resp, body, http.StatusOK)
+	resp, body = claim(b)
+	checkV1Error(t, resp, body, http.StatusConflict, "post.not_pooled")
+	resp, body = do(t, "POST", ts.URL+"/v1/posts/999999/claim?psikolog_id="+strconv.Itoa(a), "", "")
+	checkV1Error(t, resp, body, http.StatusNotFound, "post.not_found")
+
+	resp, body = doAdmin(t, "GET", ts.URL+loc+"/assignments", config.AdminToken)
+	var assignments []database.Assignment
+	decodeArray(t, body, &assignments)
+	var reasons []string
+	for _, as := range assignments {
+		reasons = append(reasons, as.Reason)
+	}
+	if len(reasons) != 4 || reasons[0] != reasonGeneralist || reasons[1] != reasonEscalated || reasons[2] != reasonPool || reasons[3] != reasonClaimed {
+		t.Errorf("assignments = %v", reasons)
+	}
+}
+
+func TestEscalationDeletedComment(t *testing.T) {
+	ts := newTestServer(t)
+	if _, ok := db.(*database.Memory); !ok {
+		t.Skip("needs the in-memory store")
+	}
+	defer func(c Config) { config = c }(config)
+	config.EscalateAfter = time.Hour
+	config.EscalationMode = "pool"
+	ctx := context.Background()
+
+	var user database.User
+	resp, body := postJSON(t, ts.URL+"/v1/users", database.User{Email: uniqueEmail("deleted")})
+	checkCreated(t, resp, body, &user)
+	var psikolog database.Psikolog
+	resp, body = postJSON(t, ts.URL+"/v1/psikologs", database.Psikolog{Email: uniqueEmail("deleted"), Name: "Dr. Gone"})
+	checkCreated(t, resp, body, &psikolog)
+	pid := strconv.Itoa(psikolog.Id)
+	var post database.Post
+	resp, body = postJSON(t, ts.URL+"/v1/posts", database.Post{UserId: strconv.Itoa(user.Id), PsikologId: pid, Title: "t", Category: "c", Content: "c"})
+	loc := checkCreated(t, resp, body, &post)
+	var comment database.Comment
+	resp, body = postJSON(t, ts.URL+loc+"/comments", map[string]interface{}{
+		"comment_user_id": user.Id, "comment_psikolog_id": psikolog.Id, "comment_text": "soon gone",
+	})
+	commentLoc := checkCreated(t, resp, body, &comment)
+	psikologOf := func() string {
+		p, err := db.GetPost(ctx, post.Id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return p.PsikologId
+	}
+
+	escalateDuePosts(ctx, time.Now().Add(2*time.Hour))
+	if got := psikologOf(); got != pid {
+		t.Fatalf("answered post moved to %q", got)
+	}
+
+	// the only answer is deleted, the post is due again
+	resp, body = do(t, "DELETE", ts.URL+commentLoc+"?psikolog_id="+pid, "", "")
+	checkStatus(t, resp, body, http.StatusNoContent)
+	escalateDuePosts(ctx, time.Now().Add(2*time.Hour))
+	if got := psikologOf(); got != "" {
+		t.Errorf("psikolog of a post whose answer was deleted = %q", got)
+	}
+}
@@ -0,0 +1,318 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/pyk/relieve/database"
+)
+
+// Personal data exports are asynchronous: POST /v1/users/{id}/exports
+// queue one, exportWorker builds the ZIP and the user polls the export
+// until it is done. The link to download the ZIP from /v1/exports/{token}
+// for config.ExportTTL is mailed to the email of the account only, the
+// export answers never hold it. Relieve has no chat, so there is no chat
+// history to export.
+
+// exportStale is how long a running export may take before another worker
+// claims it again, e.g. after the dyno building it was restarted.
+const exportStale = 10 * time.Minute
+
+// exportQueued wake the worker up when an export is queued.
+var exportQueued = make(chan struct{}, 1)
+
+// POST /v1/users/{id}/exports {"user_email": EMAIL} queue an export of
+// everything kept about the user. It answers 202 with the export to poll.
+func v1CreateExport(w http.ResponseWriter, r *http.Request) *apiError {
+	id, apiErr := pathID("v1CreateExport", r, "id")
+	if apiErr != nil {
+		return apiErr
+	}
+	setUserID(r, strconv.Itoa(id))
+	if config.MailURL == "" {
+		return &apiError{Tag: "v1CreateExport", Error: errors.New("mail.smtp_url not set"), ID: "export.unavailable", Code: http.StatusServiceUnavailable}
+	}
+	if apiErr := requireOwner("v1CreateExport", r, id); apiErr != nil {
+		return apiErr
+	}
+	e := database.Export{UserId: id}
+	if err := db.InsertExport(r.Context(), &e); err != nil {
+		if errors.Is(err, database.ErrInvalidReference) {
+			return &apiError{Tag: "v1CreateExport db.InsertExport", Error: err, ID: "user.not_found", Code: http.StatusNotFound}
+		}
+		return storeError("v1CreateExport db.InsertExport", err, "")
+	}
+	select {
+	case exportQueued <- struct{}{}:
+	default:
+	}
+	w.Header().Set("Location", fmt.Sprintf("/v1/users/%d/exports/%d", id, e.Id))
+	return writeJSON("v1CreateExport", w, http.StatusAccepted, e)
+}
+
+// GET /v1/users/{id}/exports/{export_id} the status of an export, without
+// its link.
+func v1GetExport(w http.ResponseWriter, r *http.Request) *apiError {
+	id, apiErr := pathID("v1GetExport", r, "id")
+	if apiErr != nil {
+		return apiErr
+	}
+	exportID, apiErr := pathID("v1GetExport", r, "export_id")
+	if apiErr != nil {
+		return apiErr
+	}
+	e, err := db.GetExport(r.Context(), exportID)
+	if err == nil && e.UserId != id {
+		// the export of another user does not exist for this one
+		err = &database.Error{Kind: database.ErrNotFound, Err: errors.New("export of another user")}
+	}
+	if err != nil {
+		return storeError("v1GetExport db.GetExport", err, "export.not_found")
+	}
+	return writeJSON("v1GetExport", w, http.StatusOK, e)
+}
+
+// GET /v1/exports/{token} download the ZIP of a done export. The token is
+// the only credential, so an expired link is gone for good.
+func v1DownloadExport(w http.ResponseWriter, r *http.Request) *apiError {
+	token := mux.Vars(r)["token"]
+	e, data, err := db.GetExportFile(r.Context(), token)
+	if err != nil {
+		return storeError("v1DownloadExport db.GetExportFile", err, "export.not_found")
+	}
+	setUserID(r, strconv.Itoa(e.UserId))
+	if e.ExpiresAt == nil || !time.Now().Before(*e.ExpiresAt) {
+		return &apiError{
+			Tag:   "v1DownloadExport",
+			Error: fmt.Errorf("export %d expired", e.Id),
+			ID:    "export.expired",
+			Code:  http.StatusGone,
+		}
+	}
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="relieve-export-%d.zip"`, e.Id))
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.Header().Set("Cache-Control", "private, no-store")
+	w.WriteHeader(http.StatusOK)
+	if r.Method != "HEAD" {
+		w.Write(data)
+	}
+	return nil
+}
+
+// exportWorker build the queued exports as they come, and look for
+// forgotten ones every minute.
+func exportWorker(ctx context.Context) {
+	for {
+		runExports(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-exportQueued:
+		case <-time.After(time.Minute):
+		}
+	}
+}
+
+// runExports build every queued export.
+func runExports(ctx context.Context) {
+	for ctx.Err() == nil {
+		e, err := db.ClaimExport(ctx, time.Now().Add(-exportStale))
+		if errors.Is(err, database.ErrNotFound) || errors.Is(err, database.ErrNotReady) {
+			return
+		}
+		if err != nil {
+			slog.Error("Claim export", "err", err)
+			return
+		}
+		buildExport(ctx, e)
+	}
+}
+
+// buildExport build the ZIP of e and store it, or mark e failed.
+func buildExport(ctx context.Context, e database.Export) {
+	start := time.Now()
+	data, err := exportZIP(ctx, e.UserId)
+	if err == nil {
+		e.Token, err = exportToken()
+	}
+	expires := time.Now().Add(config.ExportTTL)
+	e.ExpiresAt = &expires
+	if err != nil {
+		slog.Error("Build export", "export_id", e.Id, "user_id", e.UserId, "err", err)
+		e.Status, e.Token, data = database.ExportFailed, "", nil
+	} else {
+		e.Status = database.ExportDone
+	}
+	if err := db.FinishExport(ctx, &e, data); err != nil {
+		slog.Error("Store export", "export_id", e.Id, "user_id", e.UserId, "err", err)
+		return
+	}
+	slog.Info("Built export", "export_id", e.Id, "user_id", e.UserId, "status", e.Status,
+		"bytes", len(data), "duration", time.Since(start))
+	if e.Status == database.ExportDone {
+		mailExport(ctx, e)
+	}
+}
+
+// mailExport mail the download link of e to its user. The link is the only
+// credential, so it is never logged; on failure the user queue another
+// export.
+func mailExport(ctx context.Context, e database.Export) {
+	u, err := db.GetUser(ctx, e.UserId)
+	if err == nil {
+		link := strings.TrimSuffix(config.PublicURL, "/") + "/v1/exports/" + e.Token
+		err = sendMail(u.Email, "Your Relieve data", fmt.Sprintf(
+			"Your data is ready. Download it until %s from\n\n%s\n\nIf you did not ask for it, ignore this mail.\n",
+			e.ExpiresAt.UTC().Format("2006-01-02 15:04 MST"), link))
+	}
+	if err != nil {
+		slog.Error("Mail export link", "export_id", e.Id, "user_id", e.UserId, "err", err)
+		return
+	}
+	slog.Info("Mailed export link", "export_id", e.Id, "user_id", e.UserId)
+}
+
+// exportToken return a random token for a download link.
+func exportToken() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// exportZIP build the ZIP of everything kept about a user: data.json for
+// machines and index.html for people.
+func exportZIP(ctx context.Context, userID int) ([]byte, error) {
+	data, err := db.GetUserData(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	js, err := json.MarshalIndent(data, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	var page bytes.Buffer
+	if err := exportPage.Execute(&page, struct {
+		database.UserData
+		Generated time.Time
+	}{data, time.Now().UTC()}); err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, f := range []struct {
+		name string
+		body []byte
+	}{
+		{"data.json", append(js, '\n')},
+		{"index.html", page.Bytes()},
+	} {
+		fw, err := zw.CreateHeader(&zip.FileHeader{Name: f.name, Method: zip.Deflate, Modified: time.Now()})
+		if err != nil {
+			return nil, err
+		}
+		if _, err := fw.Write(f.body); err != nil {
+			return nil, err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// purgeExports delete every hour the exports whose link expired.
+func purgeExports(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Hour):
+		}
+		n, err := db.PurgeExports(ctx, time.Now())
+		if errors.Is(err, database.ErrNotReady) {
+			continue
+		}
+		if err != nil {
+			slog.Error("Purge exports", "err", err)
+			continue
+		}
+		if n > 0 {
+			slog.Info("Purged exports", "exports", n)
+		}
+	}
+}
+
+var exportPage = template.Must(template.New("export").Funcs(template.FuncMap{
+	"date": func(t *time.Time) string {
+		if t == nil {
+			return ""
+		}
+		return t.UTC().Format("2006-01-02 15:04 MST")
+	},
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Your Relieve data</title>
+<style>
+body { font-family: sans-serif; max-width: 48em; margin: 2em auto; line-height: 1.5; }
+article { border-top: 1px solid #ccc; padding: .5em 0; }
+.meta { color: #666; font-size: .9em; }
+</style>
+</head>
+<body>
+<h1>Your Relieve data</h1>
+<p class="meta">Exported {{.Generated.Format "2006-01-02 15:04 MST"}}. The same data is in data.json.</p>
+
+<h2>Account</h2>
+<ul>
+<li>User ID: {{.User.Id}}</li>
+<li>Email: {{.User.Email}}</li>
+<li>Gender: {{.User.Gender}}</li>
+<li>Age: {{.User.Age}}</li>
+<li>Profession: {{.User.Profession}}</li>
+</ul>
+
+<h2>Posts ({{len .Posts}})</h2>
+{{range .Posts}}<article>
+<h3>{{.Title}}</h3>
+<p class="meta">#{{.Id}}, {{.Category}}, to psikolog {{.PsikologId}}, {{date .Date}}{{if .UpdatedAt}}, edited {{date .UpdatedAt}}{{end}}{{if .DeletedAt}}, deleted {{date .DeletedAt}}{{end}}</p>
+<p>{{.Content}}</p>
+</article>
+{{else}}<p>None.</p>
+{{end}}
+<h2>Comments received ({{len .Comments}})</h2>
+{{range .Comments}}<article>
+<p class="meta">On post #{{.PostId}}, from psikolog {{.PsikologId}}, {{date .Date}}{{if .UpdatedAt}}, edited {{date .UpdatedAt}}{{end}}</p>
+<p>{{.Text}}</p>
+</article>
+{{else}}<p>None.</p>
+{{end}}
+<h2>Reports filed ({{len .Reports}})</h2>
+{{range .Reports}}<p>Report #{{.Id}} on post #{{.PostId}}</p>
+{{else}}<p>None.</p>
+{{end}}
+<h2>Wisdom points given ({{len .Wisdom}})</h2>
+{{range .Wisdom}}<p>{{.Point}} to psikolog {{.PsikologID}}</p>
+{{else}}<p>None.</p>
+{{end}}
+</body>
+</html>
+`))
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pyk/relieve/database"
+)
+
+func TestExport(t *testing.T) {
+	ts := newTestServer(t)
+	defer func(c Config) { config = c }(config)
+	get := func(path string) (*http.Response, string) {
+		return do(t, "GET", ts.URL+path, "", "")
+	}
+	// the link is mailed, read it from the mail
+	var mailedTo, link string
+	defer func(f func(to, subject, body string) error) { sendMail = f }(sendMail)
+	sendMail = func(to, subject, body string) error {
+		mailedTo = to
+		i := strings.Index(body, "https://api.example.com/")
+		link = strings.Fields(body[i+len("https://api.example.com"):])[0]
+		return nil
+	}
+
+	var user database.User
+	resp, body := postJSON(t, ts.URL+"/v1/users", database.User{Email: uniqueEmail("export"), Age: 30, Profession: "<b>nurse</b>"})
+	userLoc := checkCreated(t, resp, body, &user)
+	var psikolog database.Psikolog
+	resp, body = postJSON(t, ts.URL+"/v1/psikologs", database.Psikolog{Email: uniqueEmail("export"), Name: "Dr. Export"})
+	checkCreated(t, resp, body, &psikolog)
+	var post database.Post
+	resp, body = postJSON(t, ts.URL+"/v1/posts", database.Post{UserId: strconv.Itoa(user.Id), PsikologId: strconv.Itoa(psikolog.Id), Title: "t", Category: "c", Content: "my story"})
+	postLoc := checkCreated(t, resp, body, &post)
+	resp, body = postJSON(t, ts.URL+postLoc+"/comments", map[string]interface{}{
+		"comment_user_id": user.Id, "comment_psikolog_id": psikolog.Id, "comment_text": "it gets better",
+	})
+	checkStatus(t, resp, body, http.StatusCreated)
+	resp, body = postJSON(t, ts.URL+"/v1/reports", database.Report{UserId: user.Id, PostId: post.Id})
+	checkStatus(t, resp, body, http.StatusCreated)
+
+	owner := `{"user_email": "` + user.Email + `"}`
+	resp, body = do(t, "POST", ts.URL+userLoc+"/exports", "application/json", owner)
+	checkV1Error(t, resp, body, http.StatusServiceUnavailable, "export.unavailable")
+	config.MailURL, config.PublicURL = "smtp://mail.example.com", "https://api.example.com/"
+	config.AdminToken = "admin-secret"
+
+	resp, body = do(t, "POST", ts.URL+"/v1/users/999999/exports", "application/json", owner)
+	checkV1Error(t, resp, body, http.StatusNotFound, "user.not_found")
+	resp, body = do(t, "POST", ts.URL+userLoc+"/exports", "application/json", `{"user_email": "someone@example.com"}`)
+	checkV1Error(t, resp, body, http.StatusForbidden, "account.not_owner")
+
+	resp, body = do(t, "POST", ts.URL+userLoc+"/exports", "application/json", owner)
+	checkStatus(t, resp, body, http.StatusAccepted)
+	var e database.Export
+	if err := json.Unmarshal([]byte(body), &e); err != nil || e.Status != database.ExportPending {
+		t.Fatalf("POST exports = %s", body)
+	}
+	exportLoc := resp.Header.Get("Location")
+	if exportLoc != userLoc+"/exports/"+strconv.Itoa(e.Id) {
+		t.Errorf("Location = %q", exportLoc)
+	}
+	resp, body = get("/v1/users/999999/exports/" + strconv.Itoa(e.Id))
+	checkV1Error(t, resp, body, http.StatusNotFound, "export.not_found")
+
+	runExports(context.Background())
+	resp, body = get(exportLoc)
+	checkStatus(t, resp, body, http.StatusOK)
+	if err := json.Unmarshal([]byte(body), &e); err != nil || e.Status != database.ExportDone || strings.Contains(body, "/v1/exports/") {
+		t.Fatalf("GET %s = %s", exportLoc, body)
+	}
+	if mailedTo != user.Email || !strings.HasPrefix(link, "/v1/exports/") {
+		t.Fatalf("mailed %q to %q", link, mailedTo)
+	}
+
+	resp, body = get(link)
+	checkStatus(t, resp, body, http.StatusOK)
+	if ct := resp.Header.Get("Content-Type"); ct != "application/zip" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	zr, err := zip.NewReader(strings.NewReader(body), int64(len(body)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := make(map[string]string)
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := ioutil.ReadAll(rc)
+		rc.Close()
+		files[f.Name] = string(b)
+	}
+	var data database.UserData
+	if err := json.Unmarshal([]byte(files["data.json"]), &data); err != nil {
+		t.Fatalf("data.json: %v", err)
+	}
+	if data.User.Id != user.Id || len(data.Posts) != 1 || len(data.Comments) != 1 || len(data.Reports) != 1 || len(data.Wisdom) != 0 {
+		t.Errorf("data.json = %s", files["data.json"])
+	}
+	if page := files["index.html"]; !strings.Contains(page, "my story") || !strings.Contains(page, "&lt;b&gt;nurse") {
+		t.Errorf("index.html = %s", page)
+	}
+
+	resp, body = get("/v1/exports/unknown")
+	checkV1Error(t, resp, body, http.StatusNotFound, "export.not_found")
+
+	// the link expires, then the export is purged
+	config.ExportTTL = -time.Minute
+	resp, body = doAdmin(t, "POST", ts.URL+userLoc+"/exports", config.AdminToken)
+	checkStatus(t, resp, body, http.StatusAccepted)
+	runExports(context.Background())
+	resp, body = get(link)
+	checkV1Error(t, resp, body, http.StatusGone, "export.expired")
+	if n, err := db.PurgeExports(context.Background(), time.Now()); err != nil || n != 1 {
+		t.Errorf("PurgeExports = %d, %v", n, err)
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/pyk/relieve/database"
+)
+
+// healthCheck is the result of one readiness check.
+type healthCheck struct {
+	Status string `json:"status"`
+	Detail string `json:"detail,omitempty"`
+}
+
+type healthResponse struct {
+	Status string                 `json:"status"`
+	Checks map[string]healthCheck `json:"checks,omitempty"`
+}
+
+func writeHealth(w http.ResponseWriter, code int, resp healthResponse) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// healthzHandler report the process is alive. It never touches the
+// database so a slow database does not get the dyno restarted.
+// GET /healthz
+func healthzHandler(w http.ResponseWriter, r *http.Request) {
+	writeHealth(w, http.StatusOK, healthResponse{Status: "ok"})
+}
+
+// readyzHandler report whether the server can serve requests: the database
+// answers, every statement is prepared and the schema is at the version
+// this build expects.
+// GET /readyz
+func readyzHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 2*time.Second)
+	defer cancel()
+
+	resp := healthResponse{Status: "ready", Checks: make(map[string]healthCheck)}
+	check := func(name string, err error, detail string) {
+		if err != nil {
+			resp.Status = "not ready"
+			resp.Checks[name] = healthCheck{"fail", err.Error()}
+			return
+		}
+		resp.Checks[name] = healthCheck{"ok", detail}
+	}
+
+	check("database", db.Ping(ctx), "")
+	if pg := pgStore(); pg != nil {
+		var err error
+		if !pg.Prepared() {
+			err = database.ErrNotReady
+		}
+		check("statements", err, "")
+
+		want := database.SchemaVersion()
+		version, err := pg.Version(ctx)
+		if err == nil && version != want {
+			err = fmt.Errorf("schema at version %d, want %d", version, want)
+		}
+		check("migrations", err, fmt.Sprintf("version %d", version))
+	}
+
+	code := http.StatusOK
+	if resp.Status != "ready" {
+		code = http.StatusServiceUnavailable
+	}
+	writeHealth(w, code, resp)
+}
+
+// setupDatabase migrate the database if configured and prepare the
+// statements, retrying until it succeeds or ctx is done. Until then the
+// server runs but /readyz reports it is not ready.
+func setupDatabase(ctx context.Context, pg *database.Database) {
+	backoff := time.Second
+	for {
+		err := func() error {
+			if config.AutoMigrate {
+				if err := pg.Migrate(ctx); err != nil {
+					return fmt.Errorf("migrate: %v", err)
+				}
+			}
+			return pg.Prepare(ctx)
+		}()
+		if err == nil {
+			slog.Info("Database ready")
+			return
+		}
+		slog.Error("Database setup failed, retrying", "err", err, "retry_in", backoff.String())
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > 30*time.Second {
+			backoff = 30 * time.Second
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"log"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// requestIDHeader carry the request ID from the router and back to the
+// client, so a mobile bug report can be matched with our logs.
+const requestIDHeader = "X-Request-ID"
+
+type contextKey int
+
+const requestInfoKey contextKey = iota
+
+// requestInfo hold what is known about the request being served. Handlers
+// fill in the user once they have parsed it.
+type requestInfo struct {
+	id     string
+	userID string
+}
+
+func requestInfoFrom(ctx context.Context) *requestInfo {
+	info, _ := ctx.Value(requestInfoKey).(*requestInfo)
+	return info
+}
+
+// setUserID record the user a request acts for, it is logged with the
+// request.
+func setUserID(r *http.Request, userID string) {
+	if info := requestInfoFrom(r.Context()); info != nil {
+		info.userID = userID
+	}
+}
+
+// newLogger return the logger for format "json" or "text" at the given
+// level. Records logged with a request context get its request_id.
+func newLogger(w io.Writer, format string, level slog.Level) *slog.Logger {
+	opts := &slog.HandlerOptions{Level: level}
+	var h slog.Handler
+	if format == "text" {
+		h = slog.NewTextHandler(w, opts)
+	} else {
+		h = slog.NewJSONHandler(w, opts)
+	}
+	return slog.New(contextHandler{h})
+}
+
+// contextHandler add the request ID found in the context to every record,
+// including the ones logged by the database package.
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if info := requestInfoFrom(ctx); info != nil {
+		r.AddAttrs(slog.String("request_id", info.id))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
+
+// setupLogging install the configured logger as the default one, which
+// also redirects the standard log package.
+func setupLogging(w io.Writer) {
+	slog.SetDefault(newLogger(w, config.LogFormat, config.LogLevel))
+	log.SetFlags(0)
+}
+
+// newRequestID return 16 random bytes as hex.
+func newRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(b)
+}
+
+// validRequestID accept IDs set by the Heroku router or a client, as long
+// as they cannot break a log line.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for _, c := range id {
+		ok := c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.'
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// loggingWriter record the status code and the size of the response.
+type loggingWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (w *loggingWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *loggingWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += n
+	return n, err
+}
+
+// requestLogger give every request an ID and write one access log line
+// once the response is sent.
+func requestLogger(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		info := &requestInfo{id: r.Header.Get(requestIDHeader)}
+		if !validRequestID(info.id) {
+			info.id = newRequestID()
+		}
+		w.Header().Set(requestIDHeader, info.id)
+		r = r.WithContext(context.WithValue(r.Context(), requestInfoKey, info))
+
+		lw := &loggingWriter{ResponseWriter: w}
+		h.ServeHTTP(lw, r)
+		if lw.status == 0 {
+			lw.status = http.StatusOK
+		}
+
+		slog.InfoContext(r.Context(), "request",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"query", r.URL.RawQuery,
+			"status", lw.status,
+			"latency_ms", float64(time.Since(start).Microseconds())/1000,
+			"bytes", lw.bytes,
+			"user_id", info.userID,
+			"remote_addr", r.RemoteAddr,
+		)
+	})
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/url"
+	"strings"
+)
+
+// sendMail send a plain text mail through config.MailURL. Tests replace it.
+var sendMail = smtpMail
+
+func smtpMail(to, subject, body string) error {
+	u, err := url.Parse(config.MailURL)
+	if err != nil {
+		return err
+	}
+	from, err := mail.ParseAddress(config.MailFrom)
+	if err != nil {
+		return err
+	}
+	addr := u.Host
+	if u.Port() == "" {
+		addr = net.JoinHostPort(u.Hostname(), "587")
+	}
+	var auth smtp.Auth
+	if u.User != nil {
+		// PlainAuth refuse to send the password without TLS
+		password, _ := u.User.Password()
+		auth = smtp.PlainAuth("", u.User.Username(), password, u.Hostname())
+	}
+	var msg strings.Builder
+	msg.WriteString("From: " + from.String() + "\r\n")
+	msg.WriteString("To: " + to + "\r\n")
+	msg.WriteString("Subject: " + subject + "\r\n")
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	msg.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+	return smtp.SendMail(addr, auth, from.Address, []string{to}, []byte(msg.String()))
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// defaultLanguage is used when Accept-Language names no language we have a
+// catalog for.
+const defaultLanguage = "en"
+
+// catalogs hold the message of every error ID, by language. A message may
+// refer to a detail of the error as {name}; field messages use {arg}.
+// Clients branch on the ID, so never rename one, add a new one instead.
+var catalogs = map[string]map[string]string{
+	"en": {
+		"internal_error":            "Internal server error",
+		"route.not_found":           "Not Found",
+		"rate_limited":              "Too many requests",
+		"request.missing_parameter": "{parameter} not specified.",
+		"request.incomplete":        "POST data incomplete",
+		"request.invalid":           "Invalid request",
+		"request.invalid_value":     "Invalid value",
+		"request.malformed_json":    "Malformed JSON",
+		"request.too_large":         "Request body too large",
+		"record.not_found":          "Record not found",
+		"record.exists":             "Record exists.",
+		"user.not_found":            "User not found",
+		"user.email_taken":          "Email already registered",
+		"psikolog.not_found":        "Psikolog not found",
+		"psikolog.email_taken":      "Email already registered",
+		"reliever.not_found":        "Reliever not found",
+		"post.not_found":            "Post not found",
+		"post.not_author":           "Only the author can change the post",
+		"post.not_assigned":         "Only the psikolog of the post can see its history",
+		"post.not_pooled":           "The post is not in the open pool",
+		"psikolog.not_verified":     "Only verified psikologs can take posts",
+		"psikolog.not_self":         "Only the psikolog can read their notifications",
+		"wisdom.already_given":      "Wisdom point already given",
+		"wisdom.not_found":          "Wisdom point not given",
+		"comment.not_found":         "Comment not found",
+		"comment.not_author":        "Only the psikolog who wrote the comment can change it",
+		"report.not_found":          "Report not found",
+		"deletion.not_scheduled":    "No deletion is scheduled for this account",
+		"account.not_owner":         "The email is not the one of this account",
+		"export.not_found":          "Export not found",
+		"export.expired":            "The download link has expired, request a new export",
+		"export.unavailable":        "Exports are not available right now",
+		"routing.no_psikolog":       "No psikolog is available right now, try again later",
+		"category.not_found":        "Category not found",
+		"category.exists":           "Category already exists",
+		"category.in_use":           "Posts use this category, give a replacement",
+		"method.not_allowed":        "Method not allowed",
+		"admin.unauthorized":        "Moderator access required",
+
+		"field.required":         "is required",
+		"field.positive_integer": "must be a positive integer",
+		"field.assignee":         "must be a positive integer or \"auto\"",
+		"field.min":              "must be at least {arg}",
+		"field.max":              "must be at most {arg}",
+		"field.maxlen":           "must be at most {arg} characters",
+		"field.email":            "must be an email address",
+		"field.url":              "must be an http(s) URL",
+		"field.category":         "must be one of {arg}",
+		"field.category_unknown": "must be another existing category",
+		"field.slug":             "must be lowercase letters, digits and dashes",
+		"field.tag":              "must hold a letter or a digit",
+		"field.max_items":        "must have at most {arg} items",
+		"field.type":             "must be a {arg}",
+		"field.one_of":           "must be one of {arg}",
+		"field.date":             "must be a date as YYYY-MM-DD",
+		"field.date_range":       "must be before to, at most {arg} days",
+		"field.unknown":          "unknown field",
+		"field.invalid":          "is invalid",
+	},
+	"id": {
+		"internal_error":            "Terjadi kesalahan pada server",
+		"route.not_found":           "Tidak ditemukan",
+		"rate_limited":              "Terlalu banyak permintaan",
+		"request.missing_parameter": "{parameter} belum diisi.",
+		"request.incomplete":        "Data POST belum lengkap",
+		"request.invalid":           "Permintaan tidak valid",
+		"request.invalid_value":     "Nilai tidak valid",
+		"request.malformed_json":    "JSON tidak valid",
+		"request.too_large":         "Isi permintaan terlalu besar",
+		"record.not_found":          "Data tidak ditemukan",
+		"record.exists":             "Data sudah ada.",
+		"user.not_found":            "Pengguna tidak ditemukan",
+		"user.email_taken":          "Email sudah terdaftar",
+		"psikolog.not_found":        "Psikolog tidak ditemukan",
+		"psikolog.email_taken":      "Email sudah terdaftar",
+		"reliever.not_found":        "Reliever tidak ditemukan",
+		"post.not_found":            "Curhat tidak ditemukan",
+		"post.not_author":           "Hanya penulis yang dapat mengubah curhat ini",
+		"post.not_assigned":         "Hanya psikolog curhat ini yang dapat melihat riwayatnya",
+		"post.not_pooled":           "Curhat ini tidak ada di pool terbuka",
+		"psikolog.not_verified":     "Hanya psikolog terverifikasi yang dapat mengambil curhat",
+		"psikolog.not_self":         "Hanya psikolog ini yang dapat membaca notifikasinya",
+		"wisdom.already_given":      "Wisdom point sudah diberikan",
+		"wisdom.not_found":          "Wisdom point belum diberikan",
+		"comment.not_found":         "Komentar tidak ditemukan",
+		"comment.not_author":        "Hanya psikolog yang menulis komentar ini yang dapat mengubahnya",
+		"report.not_found":          "Laporan tidak ditemukan",
+		"deletion.not_scheduled":    "Tidak ada penghapusan akun yang dijadwalkan",
+		"account.not_owner":         "Email ini bukan milik akun ini",
+		"export.not_found":          "Ekspor tidak ditemukan",
+		"export.expired":            "Tautan unduhan sudah kedaluwarsa, minta ekspor baru",
+		"export.unavailable":        "Ekspor belum tersedia saat ini",
+		"routing.no_psikolog":       "Belum ada psikolog yang tersedia, coba lagi nanti",
+		"category.not_found":        "Kategori tidak ditemukan",
+		"category.exists":           "Kategori sudah ada",
+		"category.in_use":           "Kategori ini dipakai curhat, berikan penggantinya",
+		"method.not_allowed":        "Metode tidak diizinkan",
+		"admin.unauthorized":        "Akses moderator diperlukan",
+
+		"field.required":         "wajib diisi",
+		"field.positive_integer": "harus bilangan bulat positif",
+		"field.assignee":         "harus bilangan bulat positif atau \"auto\"",
+		"field.min":              "minimal {arg}",
+		"field.max":              "maksimal {arg}",
+		"field.maxlen":           "maksimal {arg} karakter",
+		"field.email":            "harus berupa alamat email",
+		"field.url":              "harus berupa URL http(s)",
+		"field.category":         "harus salah satu dari {arg}",
+		"field.category_unknown": "harus kategori lain yang sudah ada",
+		"field.slug":             "harus huruf kecil, angka dan tanda hubung",
+		"field.tag":              "harus berisi huruf atau angka",
+		"field.max_items":        "maksimal {arg} item",
+		"field.type":             "harus bertipe {arg}",
+		"field.one_of":           "harus salah satu dari {arg}",
+		"field.date":             "harus tanggal berformat YYYY-MM-DD",
+		"field.date_range":       "harus sebelum to, paling lama {arg} hari",
+		"field.unknown":          "tidak dikenal",
+		"field.invalid":          "tidak valid",
+	},
+}
+
+// language pick the catalog that best matches the Accept-Language header
+// of r, honouring q-values. Only the primary subtag is compared, so id-ID
+// and en-US match too.
+func language(r *http.Request) string {
+	type choice struct {
+		lang string
+		q    float64
+	}
+	var choices []choice
+	for _, part := range strings.Split(r.Header.Get("Accept-Language"), ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		lang, _, _ := strings.Cut(strings.ToLower(strings.TrimSpace(tag)), "-")
+		if _, ok := catalogs[lang]; !ok {
+			continue
+		}
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				q = f
+			}
+		}
+		if q > 0 {
+			choices = append(choices, choice{lang, q})
+		}
+	}
+	if len(choices) == 0 {
+		return defaultLanguage
+	}
+	sort.SliceStable(choices, func(i, j int) bool { return choices[i].q > choices[j].q })
+	return choices[0].lang
+}
+
+// message return the text of id in lang, with {name} replaced by the value
+// of args[name]. An ID missing from the catalog is returned as is.
+func message(lang, id string, args map[string]interface{}) string {
+	msg, ok := catalogs[lang][id]
+	if !ok {
+		msg, ok = catalogs[defaultLanguage][id]
+	}
+	if !ok {
+		return id
+	}
+	for name, v := range args {
+		msg = strings.ReplaceAll(msg, "{"+name+"}", fmt.Sprint(v))
+	}
+	return msg
+}
+
+// localize fill the messages of e and of its fields in lang.
+func (e *apiError) localize(lang string) {
+	e.Message = message(lang, e.ID, e.Details)
+	for i, f := range e.Fields {
+		e.Fields[i].Message = message(lang, f.ID, map[string]interface{}{"arg": f.arg})
+	}
+}
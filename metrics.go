@@ -0,0 +1,271 @@
+package main
+
+import (
+	"bufio"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pyk/relieve/database"
+)
+
+// The metrics below are exposed at /metrics in the Prometheus text format.
+// They are kept in a small registry instead of the Prometheus client
+// library so the build stays on the vendored dependencies.
+var (
+	httpRequests = newCounterVec("relieve_http_requests_total",
+		"HTTP requests by route template, method and status code.",
+		"route", "method", "code")
+	httpDuration = newHistogramVec("relieve_http_request_duration_seconds",
+		"HTTP request latency by route template and method.",
+		"route", "method")
+	apiErrors = newCounterVec("relieve_api_errors_total",
+		"apiError responses by tag.",
+		"tag")
+	queryDuration = newHistogramVec("relieve_db_query_duration_seconds",
+		"Latency of prepared statements by statement name.",
+		"statement")
+
+	postsCreated    = newCounterVec("relieve_posts_created_total", "Posts created.")
+	commentsCreated = newCounterVec("relieve_comments_created_total", "Comments created.")
+	reportsFiled    = newCounterVec("relieve_reports_filed_total", "Reports filed against posts.")
+	wisdomGiven     = newCounterVec("relieve_wisdom_points_given_total", "Wisdom points given to psikologs.")
+)
+
+// defaultBuckets are the Prometheus client default latency buckets.
+var defaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// collector is anything that can write itself in the text format.
+type collector interface {
+	writeTo(w *bufio.Writer)
+}
+
+var (
+	registryMu sync.Mutex
+	registry   []collector
+)
+
+func register(c collector) {
+	registryMu.Lock()
+	registry = append(registry, c)
+	registryMu.Unlock()
+}
+
+// labelKey join label values into a map key.
+func labelKey(values []string) string {
+	return strings.Join(values, "\xff")
+}
+
+// formatLabels render names and values as {a="x",b="y"}.
+func formatLabels(names, values []string, extra ...string) string {
+	var pairs []string
+	for i, n := range names {
+		pairs = append(pairs, n+"="+strconv.Quote(values[i]))
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, extra[i]+"="+strconv.Quote(extra[i+1]))
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// counterVec is a counter partitioned by labels.
+type counterVec struct {
+	name, help string
+	labels     []string
+
+	mu     sync.Mutex
+	values map[string]float64
+	keys   map[string][]string
+}
+
+func newCounterVec(name, help string, labels ...string) *counterVec {
+	c := &counterVec{
+		name:   name,
+		help:   help,
+		labels: labels,
+		values: make(map[string]float64),
+		keys:   make(map[string][]string),
+	}
+	register(c)
+	return c
+}
+
+// Inc add one to the counter with the given label values.
+func (c *counterVec) Inc(values ...string) {
+	k := labelKey(values)
+	c.mu.Lock()
+	c.values[k]++
+	c.keys[k] = values
+	c.mu.Unlock()
+}
+
+func (c *counterVec) writeTo(w *bufio.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name)
+	if len(c.labels) == 0 {
+		fmt.Fprintf(w, "%s %s\n", c.name, formatFloat(c.values[""]))
+		return
+	}
+	for _, k := range sortedKeys(c.values) {
+		fmt.Fprintf(w, "%s%s %s\n", c.name, formatLabels(c.labels, c.keys[k]), formatFloat(c.values[k]))
+	}
+}
+
+// histogram hold cumulative bucket counts of one label set.
+type histogram struct {
+	values []string
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// histogramVec is a histogram partitioned by labels.
+type histogramVec struct {
+	name, help string
+	labels     []string
+	buckets    []float64
+
+	mu    sync.Mutex
+	hists map[string]*histogram
+}
+
+func newHistogramVec(name, help string, labels ...string) *histogramVec {
+	h := &histogramVec{
+		name:    name,
+		help:    help,
+		labels:  labels,
+		buckets: defaultBuckets,
+		hists:   make(map[string]*histogram),
+	}
+	register(h)
+	return h
+}
+
+// Observe record v for the given label values.
+func (h *histogramVec) Observe(v float64, values ...string) {
+	k := labelKey(values)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	hist, ok := h.hists[k]
+	if !ok {
+		hist = &histogram{values: values, counts: make([]uint64, len(h.buckets))}
+		h.hists[k] = hist
+	}
+	for i, b := range h.buckets {
+		if v <= b {
+			hist.counts[i]++
+		}
+	}
+	hist.count++
+	hist.sum += v
+}
+
+func (h *histogramVec) writeTo(w *bufio.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+	keys := make([]string, 0, len(h.hists))
+	for k := range h.hists {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		hist := h.hists[k]
+		for i, b := range h.buckets {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(h.labels, hist.values, "le", formatFloat(b)), hist.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(h.labels, hist.values, "le", "+Inf"), hist.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, formatLabels(h.labels, hist.values), formatFloat(hist.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, formatLabels(h.labels, hist.values), hist.count)
+	}
+}
+
+// dbStatsCollector report the sql.DB pool statistics at scrape time.
+type dbStatsCollector struct {
+	db *sql.DB
+}
+
+func (c dbStatsCollector) writeTo(w *bufio.Writer) {
+	s := c.db.Stats()
+	gauge := func(name, help string, v float64) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n%s %s\n", name, help, name, name, formatFloat(v))
+	}
+	counter := func(name, help string, v float64) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n%s %s\n", name, help, name, name, formatFloat(v))
+	}
+	gauge("relieve_db_max_open_connections", "Maximum number of open connections to the database.", float64(s.MaxOpenConnections))
+	gauge("relieve_db_open_connections", "Established connections, in use and idle.", float64(s.OpenConnections))
+	gauge("relieve_db_in_use_connections", "Connections currently in use.", float64(s.InUse))
+	gauge("relieve_db_idle_connections", "Idle connections.", float64(s.Idle))
+	counter("relieve_db_wait_count_total", "Connections waited for.", float64(s.WaitCount))
+	counter("relieve_db_wait_duration_seconds_total", "Time blocked waiting for a new connection.", s.WaitDuration.Seconds())
+	counter("relieve_db_max_idle_closed_total", "Connections closed due to max_idle_conns.", float64(s.MaxIdleClosed))
+	counter("relieve_db_max_lifetime_closed_total", "Connections closed due to conn_max_lifetime.", float64(s.MaxLifetimeClosed))
+}
+
+// cacheStatsCollector report the read cache statistics at scrape time.
+type cacheStatsCollector struct {
+	c *database.Cache
+}
+
+func (c cacheStatsCollector) writeTo(w *bufio.Writer) {
+	hits, misses := c.c.Stats()
+	fmt.Fprintf(w, "# HELP relieve_cache_hits_total Reads served from the cache.\n# TYPE relieve_cache_hits_total counter\nrelieve_cache_hits_total %d\n", hits)
+	fmt.Fprintf(w, "# HELP relieve_cache_misses_total Reads that went to the database.\n# TYPE relieve_cache_misses_total counter\nrelieve_cache_misses_total %d\n", misses)
+	fmt.Fprintf(w, "# HELP relieve_cache_entries Entries in the in-process cache.\n# TYPE relieve_cache_entries gauge\nrelieve_cache_entries %d\n", c.c.Len())
+}
+
+func sortedKeys(m map[string]float64) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// observeQuery is given to the postgres store to time its statements.
+func observeQuery(statement string, d time.Duration) {
+	queryDuration.Observe(d.Seconds(), statement)
+}
+
+// metricsHandler serve every registered metric.
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	bw := bufio.NewWriter(w)
+	registryMu.Lock()
+	collectors := append([]collector(nil), registry...)
+	registryMu.Unlock()
+	for _, c := range collectors {
+		c.writeTo(bw)
+	}
+	bw.Flush()
+}
+
+// instrument count and time every request served by h under the route
+// template route.
+func instrument(route string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		sw := &loggingWriter{ResponseWriter: w}
+		h.ServeHTTP(sw, r)
+		if sw.status == 0 {
+			sw.status = http.StatusOK
+		}
+		httpRequests.Inc(route, r.Method, strconv.Itoa(sw.status))
+		httpDuration.Observe(time.Since(start).Seconds(), route, r.Method)
+	})
+}
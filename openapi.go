@@ -0,0 +1,455 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pyk/relieve/database"
+)
+
+// param is a query or form parameter of an operation. Query parameters are
+// positive integers unless paramSchemas says otherwise, like the path
+// parameters read from the path template but {token}, {slug} and {tag},
+// and form parameters are strings.
+type param struct {
+	name        string
+	description string
+	required    bool
+}
+
+// operation describe one method of a route for the OpenAPI document.
+// Request and response shapes are given as values of their Go type and
+// turned into schemas by reflection, so they follow the json and validate
+// tags of the database package.
+type operation struct {
+	method  string
+	path    string
+	summary string
+	query   []param
+	// body is the JSON request body, form the fields of a form encoded one
+	body interface{}
+	form []param
+	// status is the success status, result its JSON body (a slice is an
+	// array) or text its content type when it is not JSON
+	status int
+	result interface{}
+	text   string
+	// errors are the statuses of the errors the operation answers besides
+	// 401, 413, 429 and 500, which are added when they apply
+	errors []int
+	// admin operations need the admin token, admin.token
+	admin bool
+}
+
+// operations document every route registered by newRouter. TestOpenAPI
+// fails when a route is missing.
+var operations = []operation{
+	{method: "GET", path: "/", summary: "Redirect to the Sunday Code site", status: http.StatusFound},
+	{method: "GET", path: "/metrics", summary: "Prometheus metrics", status: http.StatusOK, text: "text/plain"},
+	{method: "GET", path: "/healthz", summary: "Liveness probe", status: http.StatusOK, result: healthResponse{}},
+	{method: "GET", path: "/readyz", summary: "Readiness probe", status: http.StatusOK, result: healthResponse{}, errors: []int{503}},
+	{method: "GET", path: "/openapi.json", summary: "This document", status: http.StatusOK, text: "application/json"},
+	{method: "GET", path: "/docs", summary: "Browse this document", status: http.StatusOK, text: "text/html"},
+
+	// v0
+	{method: "GET", path: "/v0/users", summary: "Check the API is up", status: http.StatusOK, text: "text/plain"},
+	{method: "POST", path: "/v0/users", summary: "Sign up a user", body: database.User{}, status: http.StatusOK, errors: []int{400, 409, 422}},
+	{method: "GET", path: "/v0/reliever", summary: "Get a psikolog profile",
+		query:  []param{{"reliever_id", "ID of the psikolog", true}},
+		status: http.StatusOK, result: []database.Reliever{}, errors: []int{400, 404, 422}},
+	{method: "POST", path: "/v0/psikologs", summary: "Register a psikolog", body: database.Psikolog{}, status: http.StatusOK, errors: []int{400, 409, 422}},
+	{method: "GET", path: "/v0/wisdom", summary: "Sum of the wisdom points of a psikolog",
+		query:  []param{{"psikolog_id", "ID of the psikolog", true}},
+		status: http.StatusOK, result: []database.PsikologPoint{}, errors: []int{422}},
+	{method: "POST", path: "/v0/wisdom", summary: "Give a psikolog a wisdom point", body: database.WisdomPoint{}, status: http.StatusOK, errors: []int{400, 409, 422}},
+	{method: "GET", path: "/v0/checkwisdom", summary: "Check whether a user gave a psikolog a wisdom point",
+		query:  []param{{"psikolog_id", "ID of the psikolog", true}, {"user_id", "ID of the user", true}},
+		status: http.StatusOK, result: []database.WisdomPointStatus{}, errors: []int{422}},
+	{method: "GET", path: "/v0/posts", summary: "List the posts of a user",
+		query:  []param{{"user_id", "ID of the user", true}},
+		status: http.StatusOK, result: []database.Post{}, errors: []int{400, 404, 422}},
+	{method: "POST", path: "/v0/posts", summary: "Write a post",
+		form: []param{
+			{"user_id", "ID of the user", true},
+			{"psikolog_id", `ID of the psikolog, or "auto" to let the server route the post`, true},
+			{"title", "", true},
+			{"category", "slug of an active category", true},
+			{"content", "", true},
+			{"tags", "tags separated by commas", false},
+		},
+		status: http.StatusOK, result: StatusRequest{}, errors: []int{406, 422}},
+	{method: "POST", path: "/v0/comments", summary: "Comment a post", body: database.Comment{}, status: http.StatusOK, errors: []int{400, 422}},
+	{method: "POST", path: "/v0/reports", summary: "Report a post", body: database.Report{}, status: http.StatusOK, errors: []int{400, 422}},
+	{method: "GET", path: "/v0/categories", summary: "List the active categories, in the language of Accept-Language", status: http.StatusOK, result: []localizedCategory{}},
+
+	// v1
+	{method: "POST", path: "/v1/users", summary: "Sign up a user", body: database.User{}, status: http.StatusCreated, result: database.User{}, errors: []int{400, 409, 422}},
+	{method: "GET", path: "/v1/users/{id}", summary: "Get a user, with their email and profile", status: http.StatusOK, result: database.User{}, errors: []int{404, 422}, admin: true},
+	{method: "DELETE", path: "/v1/users/{id}", summary: "Delete the account after the grace period, keeps a pending deletion; the body proves the account is the caller's",
+		body: ownership{}, status: http.StatusAccepted, result: database.UserDeletion{}, errors: []int{400, 403, 404, 422}},
+	{method: "GET", path: "/v1/users/{id}/deletion", summary: "Get the pending deletion of the account", status: http.StatusOK, result: database.UserDeletion{}, errors: []int{404, 422}},
+	{method: "DELETE", path: "/v1/users/{id}/deletion", summary: "Cancel the deletion of the account; the body proves the account is the caller's",
+		body: ownership{}, status: http.StatusNoContent, errors: []int{400, 403, 404, 422}},
+	{method: "POST", path: "/v1/users/{id}/exports", summary: "Export everything kept about the user, the download link is mailed to the account once done; the body proves the account is the caller's",
+		body: ownership{}, status: http.StatusAccepted, result: database.Export{}, errors: []int{400, 403, 404, 422, 503}},
+	{method: "GET", path: "/v1/users/{id}/exports/{export_id}", summary: "Get the status of an export", status: http.StatusOK, result: database.Export{}, errors: []int{404, 422}},
+	{method: "GET", path: "/v1/exports/{token}", summary: "Download the ZIP of an export from the mailed link, until it expires", status: http.StatusOK, text: "application/zip", errors: []int{404, 410}},
+	{method: "POST", path: "/v1/psikologs", summary: "Register a psikolog", body: database.Psikolog{}, status: http.StatusCreated, result: database.Psikolog{}, errors: []int{400, 409, 422}},
+	{method: "GET", path: "/v1/psikologs/{id}", summary: "Get the public profile of a psikolog, without the email", status: http.StatusOK, result: psikologProfile{}, errors: []int{404, 422}},
+	{method: "PATCH", path: "/v1/psikologs/{id}", summary: "Update a psikolog, fields not given and the wisdom are kept", body: database.Psikolog{}, status: http.StatusOK, result: psikologProfile{}, errors: []int{400, 404, 409, 422}, admin: true},
+	{method: "GET", path: "/v1/psikologs/{id}/wisdom", summary: "Sum of the wisdom points of a psikolog", status: http.StatusOK, result: database.PsikologPoint{}, errors: []int{404, 422}},
+	{method: "POST", path: "/v1/psikologs/{id}/wisdom", summary: "Give a psikolog a wisdom point", body: database.WisdomPoint{}, status: http.StatusCreated, result: database.WisdomPoint{}, errors: []int{400, 409, 422}},
+	{method: "GET", path: "/v1/psikologs/{id}/wisdom/{user_id}", summary: "Check whether a user gave a psikolog a wisdom point", status: http.StatusOK, result: database.WisdomPoint{}, errors: []int{404, 422}},
+	{method: "GET", path: "/v1/psikologs/{id}/response-times", summary: "How long the posts of a psikolog wait for their first comment",
+		query:  rangeParams,
+		status: http.StatusOK, result: responseStats{}, errors: []int{404, 422}},
+	{method: "PUT", path: "/v1/psikologs/{id}/verified", summary: "Verify a psikolog, only verified psikologs are routed posts", status: http.StatusNoContent, errors: []int{404, 422}, admin: true},
+	{method: "DELETE", path: "/v1/psikologs/{id}/verified", summary: "Withdraw the verification of a psikolog", status: http.StatusNoContent, errors: []int{404, 422}, admin: true},
+	{method: "GET", path: "/v1/psikologs/{id}/notifications", summary: "List the latest notifications of a psikolog, for the psikolog or a moderator",
+		query:  []param{{"psikolog_id", "ID of the psikolog, unless the admin token is given", false}},
+		status: http.StatusOK, result: []database.Notification{}, errors: []int{400, 403, 404, 422}},
+	{method: "GET", path: "/v1/posts", summary: "List the posts of a user",
+		query:  []param{{"user_id", "ID of the user", true}},
+		status: http.StatusOK, result: []database.Post{}, errors: []int{400, 422}},
+	{method: "POST", path: "/v1/posts", summary: "Write a post, post_psikolog_id \"auto\" routes it to a psikolog", body: database.Post{}, status: http.StatusCreated, result: database.Post{}, errors: []int{400, 422, 503}},
+	{method: "GET", path: "/v1/posts/{id}", summary: "Get a post", status: http.StatusOK, result: database.Post{}, errors: []int{404, 422}},
+	{method: "PATCH", path: "/v1/posts/{id}", summary: "Edit the title, category and content of a post, for its author",
+		query: []param{{"user_id", "ID of the author", true}}, body: database.Post{},
+		status: http.StatusOK, result: database.Post{}, errors: []int{400, 403, 404, 422}},
+	{method: "DELETE", path: "/v1/posts/{id}", summary: "Delete a post, for its author",
+		query:  []param{{"user_id", "ID of the author", true}},
+		status: http.StatusNoContent, errors: []int{400, 403, 404, 422}},
+	{method: "GET", path: "/v1/posts/{id}/revisions", summary: "List the previous versions of a post, for its psikolog",
+		query:  []param{{"psikolog_id", "ID of the psikolog of the post", true}},
+		status: http.StatusOK, result: []database.PostRevision{}, errors: []int{400, 403, 404, 422}},
+	{method: "POST", path: "/v1/posts/{id}/claim", summary: "Take a post of the open pool, for verified psikologs",
+		query:  []param{{"psikolog_id", "ID of the psikolog", true}},
+		status: http.StatusOK, result: database.Post{}, errors: []int{400, 403, 404, 409, 422}},
+	{method: "GET", path: "/v1/pool", summary: "List the posts of the open pool, oldest first, for verified psikologs or a moderator",
+		query:  []param{{"psikolog_id", "ID of a verified psikolog, unless the admin token is given", false}},
+		status: http.StatusOK, result: []database.Post{}, errors: []int{400, 403, 404, 422}},
+	{method: "GET", path: "/v1/posts/{id}/assignments", summary: "List the psikologs a post was assigned to, and why", status: http.StatusOK, result: []database.Assignment{}, errors: []int{404, 422}, admin: true},
+	{method: "GET", path: "/v1/posts/{id}/comments", summary: "List the comments of a post", status: http.StatusOK, result: []database.Comment{}, errors: []int{404, 422}},
+	{method: "POST", path: "/v1/posts/{id}/comments", summary: "Comment a post", body: database.Comment{}, status: http.StatusCreated, result: database.Comment{}, errors: []int{400, 422}},
+	{method: "GET", path: "/v1/comments/{id}", summary: "Get a comment", status: http.StatusOK, result: database.Comment{}, errors: []int{404, 422}},
+	{method: "PATCH", path: "/v1/comments/{id}", summary: "Edit the text of a comment, for its psikolog",
+		query: []param{{"psikolog_id", "ID of the psikolog who wrote the comment", true}}, body: database.Comment{},
+		status: http.StatusOK, result: database.Comment{}, errors: []int{400, 403, 404, 422}},
+	{method: "DELETE", path: "/v1/comments/{id}", summary: "Delete a comment, for its psikolog",
+		query:  []param{{"psikolog_id", "ID of the psikolog who wrote the comment", true}},
+		status: http.StatusNoContent, errors: []int{400, 403, 404, 422}},
+	{method: "GET", path: "/v1/comments/{id}/revisions", summary: "List the previous versions of a comment, for its psikolog or a moderator",
+		query:  []param{{"psikolog_id", "ID of the psikolog who wrote the comment, unless the admin token is given", false}},
+		status: http.StatusOK, result: []database.CommentRevision{}, errors: []int{400, 403, 404, 422}},
+	{method: "PUT", path: "/v1/comments/{id}/hidden", summary: "Hide a comment", status: http.StatusNoContent, errors: []int{404, 422}, admin: true},
+	{method: "DELETE", path: "/v1/comments/{id}/hidden", summary: "Restore a hidden comment", status: http.StatusNoContent, errors: []int{404, 422}, admin: true},
+	{method: "GET", path: "/v1/tags", summary: "Autocomplete tags, the most used first",
+		query:  []param{{"prefix", "start of the tag, normalized like a tag", false}},
+		status: http.StatusOK, result: []database.TagCount{}},
+	{method: "GET", path: "/v1/tags/trending", summary: "The most used tags of the recent posts",
+		query:  []param{{"window", "how far back posts are counted, a week by default", false}},
+		status: http.StatusOK, result: []database.TagCount{}, errors: []int{422}},
+	{method: "GET", path: "/v1/tags/{tag}/posts", summary: "List the posts of a tag, newest first, without their author",
+		query:  []param{{"before_id", "ID of the last post of the previous page", false}},
+		status: http.StatusOK, result: []tagPost{}, errors: []int{422}},
+	{method: "POST", path: "/v1/reports", summary: "Report a post", body: database.Report{}, status: http.StatusCreated, result: database.Report{}, errors: []int{400, 422}},
+	{method: "GET", path: "/v1/reports/{id}", summary: "Get a report, with its reporter", status: http.StatusOK, result: database.Report{}, errors: []int{404, 422}, admin: true},
+	{method: "GET", path: "/v1/admin/audit", summary: "Audit log of a user",
+		query:  []param{{"user_id", "ID of the user", true}},
+		status: http.StatusOK, result: []database.AuditEntry{}, errors: []int{400, 422}, admin: true},
+	{method: "GET", path: "/v1/admin/analytics/activity", summary: "Posts, comments, reports and new users by day or week",
+		query:  analyticsParams(param{"interval", "day (the default) or week, starting on Monday", false}),
+		status: http.StatusOK, result: []database.ActivityPoint{}, errors: []int{422}, admin: true},
+	{method: "GET", path: "/v1/admin/analytics/posts", summary: "Posts with their authors, comments and reports by category, gender, age bucket or profession",
+		query:  analyticsParams(param{"by", "category (the default), gender, age or profession of the author", false}),
+		status: http.StatusOK, result: []database.PostBreakdown{}, errors: []int{422}, admin: true},
+	{method: "GET", path: "/v1/admin/response-times", summary: "How long the posts of every psikolog wait for their first comment",
+		query:  analyticsParams(),
+		status: http.StatusOK, result: []responseStats{}, errors: []int{422}, admin: true},
+	{method: "GET", path: "/v1/admin/notifications", summary: "List the latest notifications of the admins", status: http.StatusOK, result: []database.Notification{}, admin: true},
+	{method: "GET", path: "/v1/admin/categories", summary: "List every category, inactive ones too", status: http.StatusOK, result: []database.Category{}, admin: true},
+	{method: "POST", path: "/v1/admin/categories", summary: "Add a category", body: database.Category{}, status: http.StatusCreated, result: database.Category{}, errors: []int{400, 409, 422}, admin: true},
+	{method: "GET", path: "/v1/admin/categories/{slug}", summary: "Get a category", status: http.StatusOK, result: database.Category{}, errors: []int{404}, admin: true},
+	{method: "PATCH", path: "/v1/admin/categories/{slug}", summary: "Update a category, fields not given are kept", body: database.Category{}, status: http.StatusOK, result: database.Category{}, errors: []int{400, 404, 422}, admin: true},
+	{method: "DELETE", path: "/v1/admin/categories/{slug}", summary: "Delete a category, its posts move to the replacement",
+		query:  []param{{"replace", "slug of the category taking the posts, required while posts use it", false}},
+		status: http.StatusNoContent, errors: []int{404, 409, 422}, admin: true},
+}
+
+// rangeParams are the date range of the analytics routes.
+var rangeParams = []param{
+	{"from", "first day, by default the last 30 days up to to", false},
+	{"to", "last day, included, today by default", false},
+}
+
+// analyticsParams return p followed by the date range and format
+// parameters of the analytics routes.
+func analyticsParams(p ...param) []param {
+	p = append(p, rangeParams...)
+	return append(p, param{"format", "json (the default) or csv", false})
+}
+
+var pathParam = regexp.MustCompile(`{([a-z_]+)}`)
+
+// paramSchemas is the schema of the query parameters that are not IDs.
+var paramSchemas = map[string]map[string]interface{}{
+	"from":     {"type": "string", "format": "date"},
+	"to":       {"type": "string", "format": "date"},
+	"interval": {"type": "string", "enum": []string{database.IntervalDay, database.IntervalWeek}},
+	"by":       {"type": "string", "enum": []string{database.ByCategory, database.ByGender, database.ByAge, database.ByProfession}},
+	"format":   {"type": "string", "enum": []string{"json", "csv"}},
+	"replace":  {"type": "string"},
+	"prefix":   {"type": "string"},
+	"window":   {"type": "string", "enum": []string{"day", "week", "month"}},
+}
+
+// spec build the OpenAPI 3 document of operations.
+type spec struct {
+	schemas map[string]interface{}
+}
+
+func openAPISpec() map[string]interface{} {
+	s := &spec{schemas: make(map[string]interface{})}
+	paths := make(map[string]map[string]interface{})
+	for _, op := range operations {
+		if paths[op.path] == nil {
+			paths[op.path] = make(map[string]interface{})
+		}
+		paths[op.path][strings.ToLower(op.method)] = s.operation(op)
+	}
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":   "Relieve API",
+			"version": "1",
+			"description": "Errors carry a stable `id` to branch on; their messages follow Accept-Language (id or en). " +
+				"v0 wraps every body, errors included, in an array; v1 does not.",
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas": s.schemas,
+			"securitySchemes": map[string]interface{}{
+				"adminToken": map[string]interface{}{"type": "http", "scheme": "bearer", "description": "admin.token of the server"},
+			},
+		},
+	}
+}
+
+func (s *spec) operation(op operation) map[string]interface{} {
+	tag := "ops"
+	if parts := strings.SplitN(op.path, "/", 3); len(parts) == 3 && strings.HasPrefix(parts[1], "v") {
+		tag = parts[1]
+	}
+	o := map[string]interface{}{
+		"summary":     op.summary,
+		"operationId": strings.ToLower(op.method) + strings.NewReplacer("/", "_", "{", "", "}", "", ".", "_").Replace(op.path),
+		"tags":        []string{tag},
+	}
+
+	var params []interface{}
+	for _, m := range pathParam.FindAllStringSubmatch(op.path, -1) {
+		schema := map[string]interface{}{"type": "integer", "minimum": 1}
+		if m[1] == "token" || m[1] == "slug" || m[1] == "tag" {
+			schema = map[string]interface{}{"type": "string"}
+		}
+		params = append(params, map[string]interface{}{
+			"name": m[1], "in": "path", "required": true, "schema": schema,
+		})
+	}
+	for _, p := range op.query {
+		schema, ok := paramSchemas[p.name]
+		if !ok {
+			schema = map[string]interface{}{"type": "integer", "minimum": 1}
+		}
+		params = append(params, map[string]interface{}{
+			"name": p.name, "in": "query", "required": p.required, "description": p.description, "schema": schema,
+		})
+	}
+	if params != nil {
+		o["parameters"] = params
+	}
+
+	errors := append([]int(nil), op.errors...)
+	if op.admin {
+		o["security"] = []interface{}{map[string]interface{}{"adminToken": []string{}}}
+		errors = append(errors, http.StatusUnauthorized)
+	}
+	switch {
+	case op.body != nil:
+		o["requestBody"] = map[string]interface{}{
+			"required": true,
+			"content":  map[string]interface{}{"application/json": map[string]interface{}{"schema": s.schema(reflect.TypeOf(op.body))}},
+		}
+		errors = append(errors, http.StatusRequestEntityTooLarge)
+	case op.form != nil:
+		props := make(map[string]interface{})
+		var required []string
+		for _, f := range op.form {
+			prop := map[string]interface{}{"type": "string"}
+			if f.description != "" {
+				prop["description"] = f.description
+			}
+			props[f.name] = prop
+			if f.required {
+				required = append(required, f.name)
+			}
+		}
+		o["requestBody"] = map[string]interface{}{
+			"required": true,
+			"content": map[string]interface{}{"application/x-www-form-urlencoded": map[string]interface{}{
+				"schema": map[string]interface{}{"type": "object", "properties": props, "required": required},
+			}},
+		}
+		errors = append(errors, http.StatusRequestEntityTooLarge)
+	}
+	if _, ok := config.RateLimits[op.path]; ok && op.method != "GET" {
+		errors = append(errors, http.StatusTooManyRequests)
+	}
+	if tag != "ops" {
+		errors = append(errors, http.StatusInternalServerError)
+	}
+
+	responses := make(map[string]interface{})
+	success := map[string]interface{}{"description": http.StatusText(op.status)}
+	switch {
+	case op.result != nil:
+		success["content"] = map[string]interface{}{"application/json": map[string]interface{}{"schema": s.schema(reflect.TypeOf(op.result))}}
+	case op.text != "":
+		success["content"] = map[string]interface{}{op.text: map[string]interface{}{"schema": map[string]interface{}{"type": "string"}}}
+	}
+	responses[strconv.Itoa(op.status)] = success
+	if _, ok := cachePolicies[op.path]; ok && op.method == "GET" {
+		responses["304"] = map[string]interface{}{"description": "Not Modified, the ETag or Last-Modified sent is current"}
+	}
+
+	// v0 answers errors in an array, v1 as a single object
+	errSchema := s.schema(reflect.TypeOf(apiError{}))
+	if tag == "v0" {
+		errSchema = map[string]interface{}{"type": "array", "items": errSchema}
+	}
+	if tag == "ops" {
+		errSchema = s.schema(reflect.TypeOf(healthResponse{}))
+	}
+	for _, code := range errors {
+		responses[strconv.Itoa(code)] = map[string]interface{}{
+			"description": http.StatusText(code),
+			"content":     map[string]interface{}{"application/json": map[string]interface{}{"schema": errSchema}},
+		}
+	}
+	o["responses"] = responses
+	return o
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// schema return the schema of t, structs are added to the components and
+// referenced.
+func (s *spec) schema(t reflect.Type) map[string]interface{} {
+	switch t.Kind() {
+	case reflect.Ptr:
+		return s.schema(t.Elem())
+	case reflect.Struct:
+		if t == timeType {
+			return map[string]interface{}{"type": "string", "format": "date-time"}
+		}
+		name := strings.ToUpper(t.Name()[:1]) + t.Name()[1:]
+		if _, ok := s.schemas[name]; !ok {
+			s.schemas[name] = nil // break cycles
+			s.schemas[name] = s.object(t)
+		}
+		return map[string]interface{}{"$ref": "#/components/schemas/" + name}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": s.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": s.schema(t.Elem())}
+	case reflect.Interface:
+		return map[string]interface{}{}
+	}
+	return map[string]interface{}{"type": jsonType(t)}
+}
+
+// object return the schema of the struct t from its json and validate
+// tags.
+func (s *spec) object(t reflect.Type) map[string]interface{} {
+	props := make(map[string]interface{})
+	var required []string
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		opts := strings.Split(f.Tag.Get("json"), ",")
+		if f.PkgPath != "" || opts[0] == "-" {
+			continue
+		}
+		name := opts[0]
+		if name == "" {
+			name = f.Name
+		}
+		prop := s.schema(f.Type)
+		if len(opts) > 1 && opts[1] == "string" {
+			prop = map[string]interface{}{"type": "string"}
+		}
+		for _, rule := range strings.Split(f.Tag.Get("validate"), ",") {
+			ruleName, arg, _ := strings.Cut(rule, "=")
+			n, _ := strconv.Atoi(arg)
+			switch ruleName {
+			case "required":
+				required = append(required, name)
+			case "id":
+				if prop["type"] == "string" {
+					prop["pattern"] = "^[1-9][0-9]*$"
+				} else {
+					prop["minimum"] = 1
+				}
+			case "min":
+				prop["minimum"] = n
+			case "max":
+				prop["maximum"] = n
+			case "maxlen":
+				prop["maxLength"] = n
+			case "email":
+				prop["format"] = "email"
+			case "url":
+				prop["format"] = "uri"
+			case "slug":
+				prop["pattern"] = "^[a-z0-9]+(-[a-z0-9]+)*$"
+			case "category":
+				prop["description"] = "slug of an active category, see GET /v0/categories"
+			case "tag":
+				prop["description"] = "normalized to lowercase words joined by dashes, at most posts.max_tags"
+			}
+		}
+		props[name] = prop
+	}
+	o := map[string]interface{}{"type": "object", "properties": props}
+	if required != nil {
+		sort.Strings(required)
+		o["required"] = required
+	}
+	return o
+}
+
+// openAPIHandler serve the OpenAPI document.
+// GET /openapi.json
+func openAPIHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(openAPISpec())
+}
+
+//go:embed docs.html
+var docsPage []byte
+
+// docsHandler serve a viewer of the OpenAPI document. It is a single page
+// without external assets.
+// GET /docs
+func docsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(docsPage)
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestOpenAPI(t *testing.T) {
+	ts := newTestServer(t)
+
+	resp, body := do(t, "GET", ts.URL+"/openapi.json", "", "")
+	checkStatus(t, resp, body, http.StatusOK)
+	var spec struct {
+		OpenAPI    string                                `json:"openapi"`
+		Paths      map[string]map[string]json.RawMessage `json:"paths"`
+		Components struct {
+			Schemas map[string]struct {
+				Properties map[string]map[string]interface{} `json:"properties"`
+				Required   []string                          `json:"required"`
+			} `json:"schemas"`
+		} `json:"components"`
+	}
+	if err := json.Unmarshal([]byte(body), &spec); err != nil {
+		t.Fatal(err)
+	}
+	if spec.OpenAPI != "3.0.3" {
+		t.Errorf("openapi = %q", spec.OpenAPI)
+	}
+
+	// every registered route, and every method of the v1 ones, is
+	// documented
+	for path, methods := range routes {
+		item, ok := spec.Paths[path]
+		if !ok {
+			t.Errorf("route %s is missing from the spec", path)
+			continue
+		}
+		for _, method := range methods {
+			if _, ok := item[strings.ToLower(method)]; !ok {
+				t.Errorf("%s %s is missing from the spec", method, path)
+			}
+		}
+	}
+	// and nothing else
+	for path := range spec.Paths {
+		if _, ok := routes[path]; !ok {
+			t.Errorf("spec documents %s, which is not a route", path)
+		}
+	}
+
+	// schemas follow the json and validate tags
+	user := spec.Components.Schemas["User"]
+	if user.Properties["user_email"]["format"] != "email" || user.Properties["user_age"]["minimum"] != float64(13) {
+		t.Errorf("User schema = %+v", user)
+	}
+	sort.Strings(user.Required)
+	if strings.Join(user.Required, ",") != "user_email" {
+		t.Errorf("User required = %v", user.Required)
+	}
+	if _, ok := spec.Components.Schemas["ApiError"].Properties["id"]; !ok {
+		t.Errorf("ApiError schema = %+v", spec.Components.Schemas["ApiError"])
+	}
+
+	// the v0 post form, tags are optional
+	var post struct {
+		RequestBody struct {
+			Content map[string]struct {
+				Schema struct {
+					Properties map[string]interface{} `json:"properties"`
+					Required   []string               `json:"required"`
+				} `json:"schema"`
+			} `json:"content"`
+		} `json:"requestBody"`
+	}
+	if err := json.Unmarshal(spec.Paths["/v0/posts"]["post"], &post); err != nil {
+		t.Fatal(err)
+	}
+	form := post.RequestBody.Content["application/x-www-form-urlencoded"].Schema
+	if _, ok := form.Properties["tags"]; !ok || len(form.Required) != 5 {
+		t.Errorf("POST /v0/posts form = %+v", form)
+	}
+
+	resp, body = do(t, "GET", ts.URL+"/docs", "", "")
+	checkStatus(t, resp, body, http.StatusOK)
+	if !strings.Contains(body, "/openapi.json") {
+		t.Error("docs page does not load the spec")
+	}
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pyk/relieve/database"
+)
+
+// rateLimit allow Burst requests at once, refilled at Rate per second.
+type rateLimit struct {
+	Rate  float64
+	Burst int
+}
+
+// parseRateLimit parse "N/period" as N requests per period, e.g. 10/h.
+// The period is s, m, h, d or a Go duration.
+func parseRateLimit(s string) (rateLimit, error) {
+	parts := strings.SplitN(s, "/", 2)
+	if len(parts) != 2 {
+		return rateLimit{}, fmt.Errorf("%q must look like 10/h", s)
+	}
+	n, err := strconv.Atoi(parts[0])
+	if err != nil || n < 1 {
+		return rateLimit{}, fmt.Errorf("%q: count must be a positive integer", s)
+	}
+	var period time.Duration
+	switch parts[1] {
+	case "s":
+		period = time.Second
+	case "m":
+		period = time.Minute
+	case "h":
+		period = time.Hour
+	case "d":
+		period = 24 * time.Hour
+	default:
+		period, err = time.ParseDuration(parts[1])
+		if err != nil || period <= 0 {
+			return rateLimit{}, fmt.Errorf("%q: unknown period %q", s, parts[1])
+		}
+	}
+	return rateLimit{Rate: float64(n) / period.Seconds(), Burst: n}, nil
+}
+
+// tokenBackend keep the token buckets. take refill the bucket of key and
+// take a token if it can, returning the tokens left.
+type tokenBackend interface {
+	take(ctx context.Context, key string, limit rateLimit) (allowed bool, tokens float64, err error)
+}
+
+// memoryBackend keep the buckets of this process only.
+type memoryBackend struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+	limit   rateLimit
+}
+
+func newMemoryBackend() *memoryBackend {
+	return &memoryBackend{buckets: make(map[string]*bucket), now: time.Now}
+}
+
+func (m *memoryBackend) take(ctx context.Context, key string, limit rateLimit) (bool, float64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	m.sweep(now)
+	b, ok := m.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(limit.Burst), updated: now, limit: limit}
+		m.buckets[key] = b
+	}
+	b.tokens = math.Min(float64(limit.Burst), b.tokens+now.Sub(b.updated).Seconds()*limit.Rate)
+	b.updated = now
+	if b.tokens < 1 {
+		return false, b.tokens, nil
+	}
+	b.tokens--
+	return true, b.tokens, nil
+}
+
+// sweep drop, once a minute, the buckets that are full again.
+func (m *memoryBackend) sweep(now time.Time) {
+	if now.Sub(m.lastSweep) < time.Minute {
+		return
+	}
+	m.lastSweep = now
+	for k, b := range m.buckets {
+		if b.tokens+now.Sub(b.updated).Seconds()*b.limit.Rate >= float64(b.limit.Burst) {
+			delete(m.buckets, k)
+		}
+	}
+}
+
+// postgresBackend share the buckets between dynos in the rate_limits table.
+type postgresBackend struct {
+	pg *database.Database
+}
+
+func (p postgresBackend) take(ctx context.Context, key string, limit rateLimit) (bool, float64, error) {
+	return p.pg.TakeToken(ctx, key, limit.Rate, limit.Burst)
+}
+
+// purgeRateLimits delete idle buckets from postgres every hour.
+func purgeRateLimits(ctx context.Context, pg *database.Database) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Hour):
+		}
+		if !pg.Prepared() {
+			continue
+		}
+		n, err := pg.PurgeRateLimits(ctx, 24*time.Hour)
+		if err != nil {
+			slog.Error("Purge rate limits", "err", err)
+			continue
+		}
+		slog.Info("Purged rate limits", "buckets", n)
+	}
+}
+
+// rateLimiter limit the write requests of each user, or client IP when the
+// request does not say which user it acts for.
+type rateLimiter struct {
+	backend tokenBackend
+	limits  map[string]rateLimit
+}
+
+// newRateLimiter build the limiter configured in config.
+func newRateLimiter() *rateLimiter {
+	var backend tokenBackend = newMemoryBackend()
+	if pg := pgStore(); pg != nil && config.RateLimitBackend == "postgres" {
+		backend = postgresBackend{pg}
+	}
+	return &rateLimiter{backend: backend, limits: config.RateLimits}
+}
+
+// clientIP return the address of the client. Behind a trusted proxy such
+// as the Heroku router it is the last X-Forwarded-For entry, the one the
+// proxy appended; entries before it are set by the client.
+func clientIP(r *http.Request) string {
+	if config.TrustProxy {
+		if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
+			parts := strings.Split(xff, ",")
+			if ip := strings.TrimSpace(parts[len(parts)-1]); ip != "" {
+				return ip
+			}
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// rateKey identify who a request is counted against. The user_id of the
+// request is chosen by the client, so only the IP is trusted until
+// requests are authenticated; the body is not read here either.
+func rateKey(r *http.Request) string {
+	return "ip:" + clientIP(r)
+}
+
+// tooManyRequests answer with the usual error envelope.
+func tooManyRequests(w http.ResponseWriter, r *http.Request) *apiError {
+	retry, _ := strconv.Atoi(w.Header().Get("Retry-After"))
+	return &apiError{
+		Tag:     "rateLimit",
+		Error:   errors.New("rate limit exceeded"),
+		ID:      "rate_limited",
+		Code:    http.StatusTooManyRequests,
+		Details: map[string]interface{}{"retry_after": retry},
+	}
+}
+
+// wrap limit the write requests to route, if it has a limit. Reads are
+// never limited.
+func (l *rateLimiter) wrap(route string, h http.Handler) http.Handler {
+	limit, ok := l.limits[route]
+	if !ok {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" || r.Method == "HEAD" || r.Method == "OPTIONS" {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		key := route + " " + rateKey(r)
+		allowed, tokens, err := l.backend.take(r.Context(), key, limit)
+		if err != nil {
+			// fail open, an outage of the limiter should not take the
+			// whole API down with it
+			slog.ErrorContext(r.Context(), "Rate limiter failed", "err", err)
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		remaining := int(math.Max(0, math.Floor(tokens)))
+		reset := math.Ceil((float64(limit.Burst) - tokens) / limit.Rate)
+		w.Header().Set("X-RateLimit-Limit", strconv.Itoa(limit.Burst))
+		w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(remaining))
+		w.Header().Set("X-RateLimit-Reset", strconv.Itoa(int(reset)))
+		if !allowed {
+			retry := math.Ceil((1 - tokens) / limit.Rate)
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Max(1, retry))))
+			ApiHandler(tooManyRequests).ServeHTTP(w, r)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
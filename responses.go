@@ -0,0 +1,144 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/pyk/relieve/database"
+)
+
+// Response times measure how long a post waits for its first comment.
+// A post is past the SLA when it waited longer than config.ResponseSLA,
+// answered or not yet.
+
+// responseStats sum up the response times of the posts of a psikolog.
+// Median and P90 are of the answered posts, nil when there is none.
+type responseStats struct {
+	PsikologId int      `json:"psikolog_id"`
+	Posts      int      `json:"posts"`
+	Answered   int      `json:"answered"`
+	Unanswered int      `json:"unanswered"`
+	PastSLA    int      `json:"past_sla"`
+	SLASeconds int64    `json:"sla_seconds"`
+	Median     *float64 `json:"median_seconds"`
+	P90        *float64 `json:"p90_seconds"`
+}
+
+// percentile return the p-th percentile of the sorted values, by nearest
+// rank.
+func percentile(sorted []float64, p float64) *float64 {
+	if len(sorted) == 0 {
+		return nil
+	}
+	i := int(math.Ceil(p*float64(len(sorted)))) - 1
+	if i < 0 {
+		i = 0
+	}
+	v := sorted[i]
+	return &v
+}
+
+// summarize the response times of each psikolog at now, by psikolog ID.
+func summarize(times []database.ResponseTime, now time.Time) []responseStats {
+	sla := config.ResponseSLA
+	waits := make(map[int][]float64)
+	stats := make(map[int]*responseStats)
+	for _, rt := range times {
+		s, ok := stats[rt.PsikologId]
+		if !ok {
+			s = &responseStats{PsikologId: rt.PsikologId, SLASeconds: int64(sla / time.Second)}
+			stats[rt.PsikologId] = s
+		}
+		s.Posts++
+		wait := now.Sub(rt.PostDate)
+		if rt.FirstComment != nil {
+			s.Answered++
+			wait = rt.FirstComment.Sub(rt.PostDate)
+			waits[rt.PsikologId] = append(waits[rt.PsikologId], wait.Seconds())
+		} else {
+			s.Unanswered++
+		}
+		if wait > sla {
+			s.PastSLA++
+		}
+	}
+	summary := []responseStats{}
+	for id, s := range stats {
+		sort.Float64s(waits[id])
+		s.Median = percentile(waits[id], 0.5)
+		s.P90 = percentile(waits[id], 0.9)
+		summary = append(summary, *s)
+	}
+	sort.Slice(summary, func(i, j int) bool { return summary[i].PsikologId < summary[j].PsikologId })
+	return summary
+}
+
+// seconds format an optional number of seconds for CSV.
+func seconds(v *float64) string {
+	if v == nil {
+		return ""
+	}
+	return strconv.FormatFloat(*v, 'f', 0, 64)
+}
+
+// GET /v1/admin/response-times the response times of every psikolog, for
+// the posts written between from and to.
+func v1ListResponseTimes(w http.ResponseWriter, r *http.Request) *apiError {
+	tag := "v1ListResponseTimes"
+	if apiErr := requireAdmin(tag, r); apiErr != nil {
+		return apiErr
+	}
+	format, apiErr := oneOf(tag, r, "format", "json", "json", "csv")
+	if apiErr != nil {
+		return apiErr
+	}
+	from, to, apiErr := dateRange(tag, r)
+	if apiErr != nil {
+		return apiErr
+	}
+	times, err := db.GetResponseTimes(r.Context(), 0, from, to)
+	if err != nil {
+		return storeError(tag+" db.GetResponseTimes", err, "")
+	}
+	summary := summarize(times, time.Now())
+	if format == "json" {
+		return writeJSON(tag, w, http.StatusOK, summary)
+	}
+	rows := make([][]string, len(summary))
+	for i, s := range summary {
+		rows[i] = []string{
+			strconv.Itoa(s.PsikologId), strconv.Itoa(s.Posts), strconv.Itoa(s.Answered), strconv.Itoa(s.Unanswered),
+			strconv.Itoa(s.PastSLA), strconv.FormatInt(s.SLASeconds, 10), seconds(s.Median), seconds(s.P90),
+		}
+	}
+	return writeCSV(w, "response-times", []string{"psikolog_id", "posts", "answered", "unanswered", "past_sla", "sla_seconds", "median_seconds", "p90_seconds"}, rows)
+}
+
+// GET /v1/psikologs/{id}/response-times the response times of a psikolog,
+// for the psikolog to follow their own.
+func v1GetResponseTimes(w http.ResponseWriter, r *http.Request) *apiError {
+	tag := "v1GetResponseTimes"
+	id, apiErr := pathID(tag, r, "id")
+	if apiErr != nil {
+		return apiErr
+	}
+	from, to, apiErr := dateRange(tag, r)
+	if apiErr != nil {
+		return apiErr
+	}
+	if _, err := db.GetPsikolog(r.Context(), id); err != nil {
+		return storeError(tag+" db.GetPsikolog", err, "psikolog.not_found")
+	}
+	times, err := db.GetResponseTimes(r.Context(), id, from, to)
+	if err != nil {
+		return storeError(tag+" db.GetResponseTimes", err, "")
+	}
+	stats := responseStats{PsikologId: id, SLASeconds: int64(config.ResponseSLA / time.Second)}
+	if summary := summarize(times, time.Now()); len(summary) == 1 {
+		stats = summary[0]
+	}
+	return writeJSON(tag, w, http.StatusOK, stats)
+}
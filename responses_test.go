@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pyk/relieve/database"
+)
+
+func TestSummarize(t *testing.T) {
+	defer func(c Config) { config = c }(config)
+	config.ResponseSLA = 24 * time.Hour
+	now := time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC)
+	posted := now.Add(-72 * time.Hour)
+	var times []database.ResponseTime
+	// psikolog 1 answers ten posts after 1..10 hours, one after two days
+	for h := 1; h <= 10; h++ {
+		first := posted.Add(time.Duration(h) * time.Hour)
+		times = append(times, database.ResponseTime{PostId: h, PsikologId: 1, PostDate: posted, FirstComment: &first})
+	}
+	late := posted.Add(48 * time.Hour)
+	times = append(times,
+		database.ResponseTime{PostId: 11, PsikologId: 1, PostDate: posted, FirstComment: &late},
+		// psikolog 2 left one post waiting for three days and one for an hour
+		database.ResponseTime{PostId: 12, PsikologId: 2, PostDate: posted},
+		database.ResponseTime{PostId: 13, PsikologId: 2, PostDate: now.Add(-time.Hour)},
+	)
+
+	summary := summarize(times, now)
+	if len(summary) != 2 {
+		t.Fatalf("summary = %+v", summary)
+	}
+	s := summary[0]
+	if s.PsikologId != 1 || s.Posts != 11 || s.Answered != 11 || s.Unanswered != 0 || s.PastSLA != 1 || s.SLASeconds != 86400 {
+		t.Errorf("psikolog 1 = %+v", s)
+	}
+	if s.Median == nil || *s.Median != 6*3600 || s.P90 == nil || *s.P90 != 10*3600 {
+		t.Errorf("psikolog 1 median %v, p90 %v", s.Median, s.P90)
+	}
+	s = summary[1]
+	if s.PsikologId != 2 || s.Posts != 2 || s.Answered != 0 || s.Unanswered != 2 || s.PastSLA != 1 || s.Median != nil || s.P90 != nil {
+		t.Errorf("psikolog 2 = %+v", s)
+	}
+}
+
+func TestV1ResponseTimes(t *testing.T) {
+	ts := newTestServer(t)
+	defer func(c Config) { config = c }(config)
+	config.AdminToken = "admin-secret"
+
+	var user database.User
+	resp, body := postJSON(t, ts.URL+"/v1/users", database.User{Email: uniqueEmail("sla"), Age: 20})
+	checkCreated(t, resp, body, &user)
+	var psikolog database.Psikolog
+	resp, body = postJSON(t, ts.URL+"/v1/psikologs", database.Psikolog{Email: uniqueEmail("sla"), Name: "Dr. Quick"})
+	loc := checkCreated(t, resp, body, &psikolog)
+	for i := 0; i < 2; i++ {
+		var post database.Post
+		resp, body = postJSON(t, ts.URL+"/v1/posts", database.Post{UserId: strconv.Itoa(user.Id), PsikologId: strconv.Itoa(psikolog.Id), Title: "t", Category: "c", Content: "c"})
+		postLoc := checkCreated(t, resp, body, &post)
+		if i == 0 {
+			resp, body = postJSON(t, ts.URL+postLoc+"/comments", map[string]interface{}{
+				"comment_user_id": user.Id, "comment_psikolog_id": psikolog.Id, "comment_text": "hello",
+			})
+			checkStatus(t, resp, body, http.StatusCreated)
+		}
+	}
+
+	resp, body = do(t, "GET", ts.URL+loc+"/response-times", "", "")
+	checkStatus(t, resp, body, http.StatusOK)
+	var s responseStats
+	if err := json.Unmarshal([]byte(body), &s); err != nil || s.Posts != 2 || s.Answered != 1 || s.Unanswered != 1 || s.PastSLA != 0 || s.Median == nil {
+		t.Errorf("GET %s/response-times = %s", loc, body)
+	}
+
+	// every post is late with a 1ns SLA
+	config.ResponseSLA = time.Nanosecond
+	resp, body = doAdmin(t, "GET", ts.URL+"/v1/admin/response-times", "admin-secret")
+	checkStatus(t, resp, body, http.StatusOK)
+	var summary []responseStats
+	decodeArray(t, body, &summary)
+	found := false
+	for _, s := range summary {
+		if s.PsikologId == psikolog.Id {
+			found = s.PastSLA == 2 && s.Posts == 2
+		}
+	}
+	if !found {
+		t.Errorf("GET /v1/admin/response-times = %s", body)
+	}
+	resp, body = doAdmin(t, "GET", ts.URL+"/v1/admin/response-times?format=csv", "admin-secret")
+	if !strings.HasPrefix(body, "psikolog_id,posts,answered,unanswered,past_sla,sla_seconds,median_seconds,p90_seconds\n") ||
+		!strings.Contains(body, "\n"+strconv.Itoa(psikolog.Id)+",2,1,1,2,0,") {
+		t.Errorf("response times as CSV = %q", body)
+	}
+
+	resp, body = do(t, "GET", ts.URL+"/v1/psikologs/999999/response-times", "", "")
+	checkV1Error(t, resp, body, http.StatusNotFound, "psikolog.not_found")
+	resp, body = do(t, "GET", ts.URL+"/v1/admin/response-times", "", "")
+	checkV1Error(t, resp, body, http.StatusUnauthorized, "admin.unauthorized")
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/pyk/relieve/database"
+)
+
+// purgeDeletedPosts remove every hour the posts deleted more than
+// config.PostRetention ago.
+func purgeDeletedPosts(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Hour):
+		}
+		n, err := db.PurgeDeletedPosts(ctx, time.Now().Add(-config.PostRetention))
+		if errors.Is(err, database.ErrNotReady) {
+			continue
+		}
+		if err != nil {
+			slog.Error("Purge deleted posts", "err", err)
+			continue
+		}
+		if n > 0 {
+			slog.Info("Purged deleted posts", "posts", n)
+		}
+	}
+}
+
+// deleteAccounts carry out every hour the account deletions whose grace
+// period is over.
+func deleteAccounts(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Hour):
+		}
+		deleteDueAccounts(ctx, time.Now())
+	}
+}
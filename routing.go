@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/pyk/relieve/database"
+)
+
+// A post written with psikolog_id "auto" is routed by the server. Only
+// verified and available psikologs under config.MaxOpenPosts are routed
+// posts, looked for in order among:
+//
+//	specialist  psikologs specialized in the category of the post
+//	generalist  psikologs with no specialization
+//	any         every other psikolog
+//
+// and within a tier the one with the fewest open posts wins, the oldest
+// account on a tie. Two posts routed at once may go to the same psikolog,
+// the load evens out on the next ones.
+
+// autoAssign is the psikolog_id asking the server to route the post.
+const autoAssign = "auto"
+
+// Assignment reasons. A psikolog picked by the client is "client", a
+// routed one the tier the psikolog was found in. Escalations are recorded
+// as "escalated" to another psikolog or "pool", and a post taken from the
+// pool as "claimed".
+const (
+	reasonClient     = "client"
+	reasonSpecialist = "specialist"
+	reasonGeneralist = "generalist"
+	reasonAny        = "any"
+	reasonEscalated  = "escalated"
+	reasonPool       = "pool"
+	reasonClaimed    = "claimed"
+)
+
+// errNoPsikolog is returned by route when no psikolog can take the post.
+var errNoPsikolog = errors.New("no psikolog to route the post to")
+
+// route pick the psikolog of a post in category among candidates, and
+// return the reason they were picked.
+func route(candidates []database.RoutingCandidate, category string) (int, string, error) {
+	tiers := []struct {
+		reason string
+		match  func(c database.RoutingCandidate) bool
+	}{
+		{reasonSpecialist, func(c database.RoutingCandidate) bool {
+			for _, s := range c.Specializations {
+				if s == category {
+					return true
+				}
+			}
+			return false
+		}},
+		{reasonGeneralist, func(c database.RoutingCandidate) bool { return len(c.Specializations) == 0 }},
+		{reasonAny, func(c database.RoutingCandidate) bool { return true }},
+	}
+	for _, tier := range tiers {
+		var best *database.RoutingCandidate
+		for i, c := range candidates {
+			if !c.Verified || !c.Available || !tier.match(c) {
+				continue
+			}
+			if config.MaxOpenPosts > 0 && c.OpenPosts >= config.MaxOpenPosts {
+				continue
+			}
+			if best == nil || c.OpenPosts < best.OpenPosts || (c.OpenPosts == best.OpenPosts && c.Id < best.Id) {
+				best = &candidates[i]
+			}
+		}
+		if best != nil {
+			return best.Id, tier.reason, nil
+		}
+	}
+	return 0, "", errNoPsikolog
+}
+
+// assign set the psikolog of p when the client asked for routing, and
+// return the reason of the assignment.
+func assign(ctx context.Context, p *database.Post) (string, error) {
+	if p.PsikologId != autoAssign {
+		return reasonClient, nil
+	}
+	candidates, err := db.GetRoutingCandidates(ctx)
+	if err != nil {
+		return "", err
+	}
+	id, reason, err := route(candidates, p.Category)
+	if err != nil {
+		return "", err
+	}
+	p.PsikologId = strconv.Itoa(id)
+	return reason, nil
+}
+
+// assignError is the answer when assign failed.
+func assignError(tag string, err error) *apiError {
+	if errors.Is(err, errNoPsikolog) {
+		return &apiError{Tag: tag, Error: err, ID: "routing.no_psikolog", Code: http.StatusServiceUnavailable}
+	}
+	return storeError(tag+" db.GetRoutingCandidates", err, "")
+}
+
+// recordAssignment record the psikolog a post was given to. A failure is
+// logged only, the post is already written.
+func recordAssignment(ctx context.Context, postID, psikologID int, reason string) {
+	a := database.Assignment{PostId: postID, PsikologId: psikologID, Reason: reason}
+	if err := db.InsertAssignment(ctx, &a); err != nil {
+		slog.ErrorContext(ctx, "Assignment write failed", "post_id", postID, "psikolog_id", psikologID, "reason", reason, "err", err)
+		return
+	}
+	slog.InfoContext(ctx, "Post assigned", "post_id", postID, "psikolog_id", psikologID, "reason", reason)
+}
+
+// PUT /v1/psikologs/{id}/verified verify a psikolog, for moderators. Only
+// verified psikologs are routed posts.
+func v1VerifyPsikolog(w http.ResponseWriter, r *http.Request) *apiError {
+	return setPsikologVerified("v1VerifyPsikolog", w, r, true)
+}
+
+// DELETE /v1/psikologs/{id}/verified withdraw the verification of a
+// psikolog, for moderators.
+func v1UnverifyPsikolog(w http.ResponseWriter, r *http.Request) *apiError {
+	return setPsikologVerified("v1UnverifyPsikolog", w, r, false)
+}
+
+func setPsikologVerified(tag string, w http.ResponseWriter, r *http.Request, verified bool) *apiError {
+	if apiErr := requireAdmin(tag, r); apiErr != nil {
+		return apiErr
+	}
+	id, apiErr := pathID(tag, r, "id")
+	if apiErr != nil {
+		return apiErr
+	}
+	if err := db.SetPsikologVerified(r.Context(), id, verified); err != nil {
+		return storeError(tag+" db.SetPsikologVerified", err, "psikolog.not_found")
+	}
+	slog.InfoContext(r.Context(), "Psikolog verification changed", "psikolog_id", id, "verified", verified)
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// GET /v1/posts/{id}/assignments the routing steps of a post, for
+// moderators.
+func v1ListAssignments(w http.ResponseWriter, r *http.Request) *apiError {
+	tag := "v1ListAssignments"
+	if apiErr := requireAdmin(tag, r); apiErr != nil {
+		return apiErr
+	}
+	id, apiErr := pathID(tag, r, "id")
+	if apiErr != nil {
+		return apiErr
+	}
+	if _, err := db.GetPost(r.Context(), id); err != nil {
+		return storeError(tag+" db.GetPost", err, "post.not_found")
+	}
+	assignments, err := db.GetAssignments(r.Context(), id)
+	if err != nil {
+		return storeError(tag+" db.GetAssignments", err, "")
+	}
+	return writeJSON(tag, w, http.StatusOK, assignments)
+}
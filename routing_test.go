@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/pyk/relieve/database"
+)
+
+func TestRoute(t *testing.T) {
+	defer func(c Config) { config = c }(config)
+	config.MaxOpenPosts = 3
+	candidate := func(id, open int, verified, available bool, specs ...string) database.RoutingCandidate {
+		return database.RoutingCandidate{
+			Psikolog:  database.Psikolog{Id: id, Verified: verified, Available: available, Specializations: specs},
+			OpenPosts: open,
+		}
+	}
+	candidates := []database.RoutingCandidate{
+		candidate(1, 0, false, true, "stress"), // not verified
+		candidate(2, 0, true, false, "stress"), // away
+		candidate(3, 2, true, true, "stress", "family"),
+		candidate(4, 1, true, true, "stress"),
+		candidate(5, 3, true, true, "work"), // full
+		candidate(6, 1, true, true),
+		candidate(7, 1, true, true),
+		candidate(8, 0, true, true, "school"),
+	}
+	for _, tt := range []struct {
+		category string
+		id       int
+		reason   string
+	}{
+		{"stress", 4, reasonSpecialist},
+		{"family", 3, reasonSpecialist},
+		{"work", 6, reasonGeneralist},
+		{"love", 6, reasonGeneralist},
+	} {
+		id, reason, err := route(candidates, tt.category)
+		if err != nil || id != tt.id || reason != tt.reason {
+			t.Errorf("route(%q) = %d, %q, %v, want %d, %q", tt.category, id, reason, err, tt.id, tt.reason)
+		}
+	}
+
+	// without generalists any psikolog left takes the post
+	id, reason, err := route(candidates[:5], "love")
+	if err != nil || id != 4 || reason != reasonAny {
+		t.Errorf("route without generalists = %d, %q, %v", id, reason, err)
+	}
+	config.MaxOpenPosts = 0
+	if id, _, _ := route(candidates[4:5], "work"); id != 5 {
+		t.Errorf("route without limit = %d, want 5", id)
+	}
+	if _, _, err := route(candidates[:2], "stress"); err != errNoPsikolog {
+		t.Errorf("route to nobody = %v, want errNoPsikolog", err)
+	}
+}
+
+func TestV1Routing(t *testing.T) {
+	ts := newTestServer(t)
+	defer func(c Config) { config = c }(config)
+	config.AdminToken = "admin-secret"
+	// a category of its own, whatever the store already holds
+	category := "routing-" + strconv.FormatInt(time.Now().UnixNano(), 36)
+
+	var user database.User
+	resp, body := postJSON(t, ts.URL+"/v1/users", database.User{Email: uniqueEmail("route")})
+	checkCreated(t, resp, body, &user)
+	var psikologs [2]database.Psikolog
+	for i := range psikologs {
+		resp, body = postJSON(t, ts.URL+"/v1/psikologs", map[string]interface{}{
+			"psikolog_email": uniqueEmail("route"), "psikolog_name": "Dr. Route", "psikolog_specializations": []string{category},
+			"psikolog_verified": true,
+		})
+		loc := checkCreated(t, resp, body, &psikologs[i])
+		if !psikologs[i].Available || psikologs[i].Verified {
+			t.Errorf("POST /v1/psikologs = %s", body)
+		}
+		resp, body = doAdmin(t, "PUT", ts.URL+loc+"/verified", "")
+		checkV1Error(t, resp, body, http.StatusUnauthorized, "admin.unauthorized")
+		resp, body = doAdmin(t, "PUT", ts.URL+loc+"/verified", config.AdminToken)
+		checkStatus(t, resp, body, http.StatusNoContent)
+	}
+	resp, body = doAdmin(t, "PUT", ts.URL+"/v1/psikologs/999999/verified", config.AdminToken)
+	checkV1Error(t, resp, body, http.StatusNotFound, "psikolog.not_found")
+
+	// the first psikolog is away
+	away := psikologs[0]
+	away.Available = false
+	resp, body = doAdmin(t, "PATCH", ts.URL+"/v1/psikologs/"+strconv.Itoa(away.Id), config.AdminToken, map[string]bool{"psikolog_available": false, "psikolog_verified": false})
+	checkStatus(t, resp, body, http.StatusOK)
+	if err := json.Unmarshal([]byte(body), &away); err != nil || away.Available || !away.Verified {
+		t.Errorf("PATCH psikolog = %s", body)
+	}
+
+	post := database.Post{UserId: strconv.Itoa(user.Id), PsikologId: autoAssign, Title: "t", Category: category, Content: "c"}
+	resp, body = postJSON(t, ts.URL+"/v1/posts", post)
+	loc := checkCreated(t, resp, body, &post)
+	if post.PsikologId != strconv.Itoa(psikologs[1].Id) {
+		t.Errorf("routed to %s, want %d", post.PsikologId, psikologs[1].Id)
+	}
+	resp, body = do(t, "GET", ts.URL+loc+"/assignments", "", "")
+	checkV1Error(t, resp, body, http.StatusUnauthorized, "admin.unauthorized")
+	resp, body = doAdmin(t, "GET", ts.URL+loc+"/assignments", config.AdminToken)
+	checkStatus(t, resp, body, http.StatusOK)
+	var assignments []database.Assignment
+	decodeArray(t, body, &assignments)
+	if len(assignments) != 1 || assignments[0].PsikologId != psikologs[1].Id || assignments[0].Reason != reasonSpecialist {
+		t.Errorf("GET %s/assignments = %s", loc, body)
+	}
+
+	// a psikolog picked by the client is recorded too
+	post = database.Post{UserId: strconv.Itoa(user.Id), PsikologId: strconv.Itoa(away.Id), Title: "t", Category: category, Content: "c"}
+	resp, body = postJSON(t, ts.URL+"/v1/posts", post)
+	loc = checkCreated(t, resp, body, &post)
+	resp, body = doAdmin(t, "GET", ts.URL+loc+"/assignments", config.AdminToken)
+	decodeArray(t, body, &assignments)
+	if len(assignments) != 1 || assignments[0].PsikologId != away.Id || assignments[0].Reason != reasonClient {
+		t.Errorf("GET %s/assignments = %s", loc, body)
+	}
+
+	resp, body = postJSON(t, ts.URL+"/v1/posts", database.Post{UserId: strconv.Itoa(user.Id), PsikologId: "anyone", Title: "t", Category: category, Content: "c"})
+	checkV1Error(t, resp, body, http.StatusUnprocessableEntity, "request.invalid")
+
+	form := url.Values{
+		"user_id":     {strconv.Itoa(user.Id)},
+		"psikolog_id": {autoAssign},
+		"title":       {"t"},
+		"category":    {category},
+		"content":     {"c"},
+	}
+	resp, body = do(t, "POST", ts.URL+"/v0/posts", "application/x-www-form-urlencoded", form.Encode())
+	checkStatus(t, resp, body, http.StatusOK)
+
+	// nobody is left once the other psikolog is unverified
+	resp, body = doAdmin(t, "DELETE", ts.URL+"/v1/psikologs/"+strconv.Itoa(psikologs[1].Id)+"/verified", config.AdminToken)
+	checkStatus(t, resp, body, http.StatusNoContent)
+	// other verified psikologs of a shared database would take it
+	if _, ok := db.(*database.Memory); ok {
+		resp, body = postJSON(t, ts.URL+"/v1/posts", database.Post{UserId: strconv.Itoa(user.Id), PsikologId: autoAssign, Title: "t", Category: category, Content: "c"})
+		checkV1Error(t, resp, body, http.StatusServiceUnavailable, "routing.no_psikolog")
+	}
+}
+
+func TestValidateSlice(t *testing.T) {
+	newTestServer(t)
+	withCategories(t, "stress", "family")
+	p := database.Psikolog{Email: "a@example.com", Name: "Dr. Slice", Specializations: []string{"family", "love"}}
+	errs := validate(&p)
+	if len(errs) != 1 || errs[0].Field != "psikolog_specializations[1]" || errs[0].ID != "field.category" {
+		t.Errorf("validate = %+v", errs)
+	}
+}
@@ -1,12 +1,18 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
+	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
 	// "time"
 
 	"github.com/gorilla/mux"
@@ -14,19 +20,27 @@ import (
 )
 
 var (
-	PORT = os.Getenv("PORT")
+	// config is replaced by the loaded configuration in main
+	config = defaultConfig()
+	db     database.Store
+	// bg run the background jobs, it is drained on shutdown
+	bg = newJobs()
+	// routes map the path of every route registered by newRouter to the
+	// methods of its v1 resource; TestOpenAPI checks they are documented
+	routes map[string][]string
 )
 
-var (
-	db *database.Database
-)
-
-// apiError define structure of API error
+// apiError define structure of API error. ID is the stable identifier
+// clients branch on, Message is filled from the catalog of ID in the
+// language of the request, see messages.go.
 type apiError struct {
-	Tag     string `json:"-"`
-	Error   error  `json:"-"`
-	Message string `json:"error"`
-	Code    int    `json:"code"`
+	Tag     string                 `json:"-"`
+	Error   error                  `json:"-"`
+	ID      string                 `json:"id"`
+	Message string                 `json:"error"`
+	Code    int                    `json:"code"`
+	Details map[string]interface{} `json:"details,omitempty"`
+	Fields  []fieldError           `json:"fields,omitempty"`
 }
 
 type StatusRequest struct {
@@ -44,34 +58,96 @@ func (api ApiHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	var errs []*apiError
 
 	// add header on every response
-	w.Header().Add("Server", "Relieve by Sunday Code")
-	w.Header().Add("X-Wisdom-Media-Type", "relieve.v0")
+	w.Header().Add("Server", config.ServerHeader)
+	w.Header().Add("X-Wisdom-Media-Type", config.MediaType)
 	w.Header().Add("Content-Type", "application/json; charset=utf-8")
 
+	// limit the size of request body
+	r.Body = http.MaxBytesReader(w, r.Body, config.MaxBodyBytes)
+
 	// if handler return an &apiError
 	err := api(w, r)
 	errs = append(errs, err)
 	if err != nil {
-		// http log
-		log.Printf("%s %s %s [%s] %s", r.RemoteAddr, r.Method, r.URL, err.Tag, err.Error)
+		mapStoreError(err)
+		lang := language(r)
+		err.localize(lang)
+		w.Header().Set("Content-Language", lang)
+		w.Header().Add("Vary", "Accept-Language")
+
+		// the request itself is logged by requestLogger
+		level := slog.LevelWarn
+		if err.Code >= http.StatusInternalServerError {
+			level = slog.LevelError
+		}
+		slog.Log(r.Context(), level, "api error", "tag", err.Tag, "id", err.ID, "err", err.Error, "code", err.Code)
+		apiErrors.Inc(err.Tag)
 
 		// response proper http status code
 		w.WriteHeader(err.Code)
 
-		// response JSON
+		// response JSON, v1 does not wrap single objects in arrays
+		var body interface{} = errs
+		if strings.HasPrefix(r.URL.Path, "/v1/") {
+			body = err
+		}
 		resp := json.NewEncoder(w)
-		err_json := resp.Encode(errs)
+		err_json := resp.Encode(body)
 		if err_json != nil {
-			log.Println("Encode JSON for error response was failed.")
+			slog.ErrorContext(r.Context(), "Encode JSON for error response was failed.", "err", err_json)
 
 			return
 		}
 
 		return
 	}
+}
 
-	// http log
-	log.Printf("%s %s %s", r.RemoteAddr, r.Method, r.URL)
+// conflictIDs name the error of each unique constraint clients can hit.
+var conflictIDs = map[string]string{
+	"users_user_email_key":                                "user.email_taken",
+	"psikologs_psikolog_email_key":                        "psikolog.email_taken",
+	"wisdom_points_wisdom_user_id_wisdom_psikolog_id_key": "wisdom.already_given",
+	"categories_pkey":                                     "category.exists",
+	"categories_in_use":                                   "category.in_use",
+}
+
+// referenceID name the error of a foreign key constraint after the record
+// it references, e.g. comments_comment_post_id_fkey is post.not_found.
+func referenceID(constraint string) string {
+	column := strings.TrimSuffix(constraint, "_fkey")
+	for _, name := range []string{"user", "psikolog", "post"} {
+		if strings.HasSuffix(column, "_"+name+"_id") {
+			return name + ".not_found"
+		}
+	}
+	return "record.not_found"
+}
+
+// mapStoreError give a generic 500 caused by a database domain error the
+// status and ID it deserves, so every handler answer them the same.
+// Handlers that already picked another status keep it.
+func mapStoreError(e *apiError) {
+	var dbErr *database.Error
+	if e.Code != http.StatusInternalServerError || !errors.As(e.Error, &dbErr) {
+		return
+	}
+	switch {
+	case errors.Is(e.Error, database.ErrNotFound):
+		e.Code, e.ID = http.StatusNotFound, "record.not_found"
+	case errors.Is(e.Error, database.ErrConflict):
+		e.Code, e.ID = http.StatusConflict, "record.exists"
+		if id, ok := conflictIDs[dbErr.Constraint]; ok {
+			e.ID = id
+		}
+	case errors.Is(e.Error, database.ErrInvalidReference):
+		e.Code, e.ID = http.StatusUnprocessableEntity, referenceID(dbErr.Constraint)
+	case errors.Is(e.Error, database.ErrConstraint), errors.Is(e.Error, database.ErrInvalidInput):
+		e.Code, e.ID = http.StatusUnprocessableEntity, "request.invalid_value"
+		if dbErr.Column != "" {
+			e.Fields = []fieldError{{Field: dbErr.Column, ID: "field.invalid"}}
+		}
+	}
 }
 
 // get information about reliever with specified ID
@@ -82,27 +158,31 @@ func relieverHandler(w http.ResponseWriter, r *http.Request) *apiError {
 		relieverID := r.FormValue("reliever_id")
 		if relieverID == "" {
 			return &apiError{
-				"relieverHandler GET",
-				errors.New("relieverHandler reliever_id not specified"),
-				"reliever_id not specified.",
-				http.StatusBadRequest,
+				Tag:     "relieverHandler GET",
+				Error:   errors.New("relieverHandler reliever_id not specified"),
+				ID:      "request.missing_parameter",
+				Details: map[string]interface{}{"parameter": "reliever_id"},
+				Code:    http.StatusBadRequest,
 			}
 		}
-		rl, err := db.GetPsikologByID(relieverID)
+		if apiErr := validateID("relieverHandler GET", "reliever_id", relieverID); apiErr != nil {
+			return apiErr
+		}
+		rl, err := db.GetPsikologByID(r.Context(), relieverID)
 		if err != nil {
-			if err.Error() == "sql: no rows in result set" {
+			if errors.Is(err, database.ErrNotFound) {
 				return &apiError{
-					"relieverHandler GET",
-					err,
-					"reliever_id not exists",
-					http.StatusBadRequest,
+					Tag:   "relieverHandler GET",
+					Error: err,
+					ID:    "reliever.not_found",
+					Code:  http.StatusNotFound,
 				}
 			}
 			return &apiError{
-				"relieverHandler GET",
-				err,
-				"Bad request",
-				http.StatusBadRequest,
+				Tag:   "relieverHandler GET",
+				Error: err,
+				ID:    "internal_error",
+				Code:  http.StatusInternalServerError,
 			}
 		}
 		var rls []database.Reliever
@@ -111,10 +191,10 @@ func relieverHandler(w http.ResponseWriter, r *http.Request) *apiError {
 		err = enc.Encode(rls)
 		if err != nil {
 			return &apiError{
-				"relieverHandler Encode",
-				err,
-				"Encoding JSON failed",
-				http.StatusInternalServerError,
+				Tag:   "relieverHandler Encode",
+				Error: err,
+				ID:    "internal_error",
+				Code:  http.StatusInternalServerError,
 			}
 		}
 
@@ -135,14 +215,21 @@ func checkWisdomHandler(w http.ResponseWriter, r *http.Request) *apiError {
 
 	psikologID := r.FormValue("psikolog_id")
 	userID := r.FormValue("user_id")
+	setUserID(r, userID)
 	if psikologID != "" && userID != "" {
-		s, err := db.CheckWisdomPoint(userID, psikologID)
+		if apiErr := validateID("checkWisdomHandler", "psikolog_id", psikologID); apiErr != nil {
+			return apiErr
+		}
+		if apiErr := validateID("checkWisdomHandler", "user_id", userID); apiErr != nil {
+			return apiErr
+		}
+		s, err := db.CheckWisdomPoint(r.Context(), userID, psikologID)
 		if err != nil {
 			return &apiError{
-				"checkWisdomHandler CheckWisdomPoint",
-				err,
-				"Internal server error",
-				http.StatusInternalServerError,
+				Tag:   "checkWisdomHandler CheckWisdomPoint",
+				Error: err,
+				ID:    "internal_error",
+				Code:  http.StatusInternalServerError,
 			}
 		}
 
@@ -151,10 +238,10 @@ func checkWisdomHandler(w http.ResponseWriter, r *http.Request) *apiError {
 		err = enc.Encode(status)
 		if err != nil {
 			return &apiError{
-				"checkWisdomHandler CheckWisdomPoint encode JSON",
-				err,
-				"Internal server error",
-				http.StatusInternalServerError,
+				Tag:   "checkWisdomHandler CheckWisdomPoint encode JSON",
+				Error: err,
+				ID:    "internal_error",
+				Code:  http.StatusInternalServerError,
 			}
 		}
 		return nil
@@ -171,36 +258,24 @@ func checkWisdomHandler(w http.ResponseWriter, r *http.Request) *apiError {
 func wisdomHandler(w http.ResponseWriter, r *http.Request) *apiError {
 	// insert new wisdom point
 	if r.Method == "POST" {
-		var wp *database.WisdomPoint
-		decoder := json.NewDecoder(r.Body)
-		err := decoder.Decode(&wp)
-		if err != nil {
-			return &apiError{
-				"wisdomHandler Decode",
-				err,
-				"Internal server error",
-				http.StatusInternalServerError,
-			}
+		var wp database.WisdomPoint
+		if apiErr := decodeJSON("wisdomHandler Decode", r, &wp); apiErr != nil {
+			return apiErr
 		}
 
+		setUserID(r, strconv.Itoa(wp.UserID))
+
 		// insert data to database
-		err = db.InsertWisdomPoint(wp)
+		err := db.InsertWisdomPoint(r.Context(), &wp)
 		if err != nil {
-			if err.Error() == "pq: duplicate key value violates unique constraint \"wisdom_points_wisdom_user_id_wisdom_psikolog_id_key\"" {
-				return &apiError{
-					"wisdomHandler db.InsertWisdomPoint",
-					err,
-					"Bad request. Record exists.",
-					http.StatusBadRequest,
-				}
-			}
 			return &apiError{
-				"wisdomHandler db.InsertWisdomPoint",
-				err,
-				"Internal server error",
-				http.StatusInternalServerError,
+				Tag:   "wisdomHandler db.InsertWisdomPoint",
+				Error: err,
+				ID:    "internal_error",
+				Code:  http.StatusInternalServerError,
 			}
 		}
+		wisdomGiven.Inc()
 		return nil
 	}
 
@@ -209,13 +284,16 @@ func wisdomHandler(w http.ResponseWriter, r *http.Request) *apiError {
 	// response should be an array
 	var psikolog_points []database.PsikologPoint
 	if psikologID != "" {
-		wp, err := db.GetWisdomPointByID(psikologID)
+		if apiErr := validateID("wisdomHandler GET", "psikolog_id", psikologID); apiErr != nil {
+			return apiErr
+		}
+		wp, err := db.GetWisdomPointByID(r.Context(), psikologID)
 		if err != nil {
 			return &apiError{
-				"wisdomHandler GetWisdomPointById",
-				err,
-				"Internal server error",
-				http.StatusInternalServerError,
+				Tag:   "wisdomHandler GetWisdomPointById",
+				Error: err,
+				ID:    "internal_error",
+				Code:  http.StatusInternalServerError,
 			}
 		}
 
@@ -224,10 +302,10 @@ func wisdomHandler(w http.ResponseWriter, r *http.Request) *apiError {
 		err = enc.Encode(psikolog_points)
 		if err != nil {
 			return &apiError{
-				"wisdomHandler GetWisdomPointById encode JSON",
-				err,
-				"Internal server error",
-				http.StatusInternalServerError,
+				Tag:   "wisdomHandler GetWisdomPointById encode JSON",
+				Error: err,
+				ID:    "internal_error",
+				Code:  http.StatusInternalServerError,
 			}
 		}
 		return nil
@@ -239,33 +317,29 @@ func wisdomHandler(w http.ResponseWriter, r *http.Request) *apiError {
 // reportHandler handle report endpoint
 func reportHandler(w http.ResponseWriter, r *http.Request) *apiError {
 	if r.Method != "POST" {
-		http.Redirect(w, r, "https://sundaycode.co", 302)
+		http.Redirect(w, r, config.RedirectURL, 302)
 		return nil
 	}
 
 	// read data from POST request and decode data to *database.Report type
-	var rp *database.Report
-	decoder := json.NewDecoder(r.Body)
-	err := decoder.Decode(&rp)
-	if err != nil {
-		return &apiError{
-			"commentHandler Decode",
-			err,
-			"Internal server error",
-			http.StatusInternalServerError,
-		}
+	var rp database.Report
+	if apiErr := decodeJSON("reportHandler Decode", r, &rp); apiErr != nil {
+		return apiErr
 	}
 
+	setUserID(r, strconv.Itoa(rp.UserId))
+
 	// insert data to database
-	err = db.InsertReport(rp)
+	err := db.InsertReport(r.Context(), &rp)
 	if err != nil {
 		return &apiError{
-			"commentHandler db.InsertComment",
-			err,
-			"Internal server error",
-			http.StatusInternalServerError,
+			Tag:   "reportHandler db.InsertReport",
+			Error: err,
+			ID:    "internal_error",
+			Code:  http.StatusInternalServerError,
 		}
 	}
+	reportsFiled.Inc()
 
 	return nil
 }
@@ -273,33 +347,29 @@ func reportHandler(w http.ResponseWriter, r *http.Request) *apiError {
 // commentHandler handle comment endpoint
 func commentHandler(w http.ResponseWriter, r *http.Request) *apiError {
 	if r.Method != "POST" {
-		http.Redirect(w, r, "https://sundaycode.co", 302)
+		http.Redirect(w, r, config.RedirectURL, 302)
 		return nil
 	}
 
 	// read data from POST request and decode data to *database.Comment type
-	var c *database.Comment
-	decoder := json.NewDecoder(r.Body)
-	err := decoder.Decode(&c)
-	if err != nil {
-		return &apiError{
-			"commentHandler Decode",
-			err,
-			"Internal server error",
-			http.StatusInternalServerError,
-		}
+	var c database.Comment
+	if apiErr := decodeJSON("commentHandler Decode", r, &c); apiErr != nil {
+		return apiErr
 	}
 
+	setUserID(r, strconv.Itoa(c.UserId))
+
 	// insert data to database
-	err = db.InsertComment(c)
+	err := db.InsertComment(r.Context(), &c)
 	if err != nil {
 		return &apiError{
-			"commentHandler db.InsertComment",
-			err,
-			"Internal server error",
-			http.StatusInternalServerError,
+			Tag:   "commentHandler db.InsertComment",
+			Error: err,
+			ID:    "internal_error",
+			Code:  http.StatusInternalServerError,
 		}
 	}
+	commentsCreated.Inc()
 
 	return nil
 }
@@ -312,43 +382,46 @@ func postHandler(w http.ResponseWriter, r *http.Request) *apiError {
 	var err error
 
 	// GET /v0/posts?user_id=ID
-	// TODO: use regex to handle params. make sure input integer.
-	// if inpit is plain string it make a server panic.
 	if r.Method == "GET" {
 		userID := r.FormValue("user_id")
+		setUserID(r, userID)
 		if userID == "" {
 			return &apiError{
-				"postHandler GET",
-				errors.New("postHandler user_id not specified"),
-				"user_id not specified.",
-				http.StatusBadRequest,
+				Tag:     "postHandler GET",
+				Error:   errors.New("postHandler user_id not specified"),
+				ID:      "request.missing_parameter",
+				Details: map[string]interface{}{"parameter": "user_id"},
+				Code:    http.StatusBadRequest,
 			}
 		}
-		posts, err = db.GetAllPostsByUserID(userID)
+		if apiErr := validateID("postHandler GET", "user_id", userID); apiErr != nil {
+			return apiErr
+		}
+		posts, err = db.GetAllPostsByUserID(r.Context(), userID)
 		if err != nil {
-			if err.Error() == "cannot found a list of posts" {
+			if errors.Is(err, database.ErrPostsNotFound) {
 				return &apiError{
-					"postHandler GET",
-					err,
-					err.Error(),
-					http.StatusNotFound,
+					Tag:   "postHandler GET",
+					Error: err,
+					ID:    "post.not_found",
+					Code:  http.StatusNotFound,
 				}
 			}
 			return &apiError{
-				"postHandler GET",
-				err,
-				"Internal server error",
-				http.StatusInternalServerError,
+				Tag:   "postHandler GET",
+				Error: err,
+				ID:    "internal_error",
+				Code:  http.StatusInternalServerError,
 			}
 		}
 		enc := json.NewEncoder(w)
 		err = enc.Encode(posts)
 		if err != nil {
 			return &apiError{
-				"postHandler GetAllPosts encode JSON",
-				err,
-				"Internal server error",
-				http.StatusInternalServerError,
+				Tag:   "postHandler GetAllPosts encode JSON",
+				Error: err,
+				ID:    "internal_error",
+				Code:  http.StatusInternalServerError,
 			}
 		}
 
@@ -357,7 +430,8 @@ func postHandler(w http.ResponseWriter, r *http.Request) *apiError {
 
 	// TODO: nyelesain endpoint post for POST
 	// POST /v0/posts
-	// data: user_id, psikolog_id, title, category, content
+	// data: user_id, psikolog_id, title, category, content, optional tags
+	// separated by commas
 	if r.Method == "POST" {
 		// save data from params
 		userID := r.FormValue("user_id")
@@ -365,14 +439,15 @@ func postHandler(w http.ResponseWriter, r *http.Request) *apiError {
 		title := r.FormValue("title")
 		category := r.FormValue("category")
 		content := r.FormValue("content")
+		setUserID(r, userID)
 
 		// all params should not empty
 		if userID == "" || psikologID == "" || title == "" || category == "" || content == "" {
 			return &apiError{
-				"postHandler POST",
-				errors.New("data incomplete"),
-				"POST data incomplete",
-				http.StatusNotAcceptable,
+				Tag:   "postHandler POST",
+				Error: errors.New("data incomplete"),
+				ID:    "request.incomplete",
+				Code:  http.StatusNotAcceptable,
 			}
 		}
 		p := database.Post{
@@ -382,28 +457,51 @@ func postHandler(w http.ResponseWriter, r *http.Request) *apiError {
 			Category:   category,
 			Content:    content,
 		}
+		if tags := r.FormValue("tags"); tags != "" {
+			p.Tags = strings.Split(tags, ",")
+		}
+		errs := validate(&p)
+		if len(errs) == 0 {
+			errs = normalizeTags(&p)
+		}
+		if len(errs) > 0 {
+			// report the names of the form values, not of the JSON fields
+			for i := range errs {
+				errs[i].Field = strings.TrimPrefix(errs[i].Field, "post_")
+			}
+			return invalidRequest("postHandler POST", errs)
+		}
+		normalizeCategory(&p)
+
+		// psikolog_id=auto let the server pick the psikolog
+		reason, err := assign(r.Context(), &p)
+		if err != nil {
+			return assignError("postHandler POST", err)
+		}
 
 		// insert data to database
-		// TODO: cari tahu kemungkinan error nya apa aja
-		err = db.InsertPost(&p)
+		err = db.InsertPost(r.Context(), &p)
 		if err != nil {
 			return &apiError{
-				"postHandler db.InsertPost",
-				err,
-				"Internal server error. Cannot insert data to database",
-				http.StatusInternalServerError,
+				Tag:   "postHandler db.InsertPost",
+				Error: err,
+				ID:    "internal_error",
+				Code:  http.StatusInternalServerError,
 			}
 		}
+		postsCreated.Inc()
+		assigned, _ := strconv.Atoi(p.PsikologId)
+		recordAssignment(r.Context(), p.Id, assigned, reason)
 
 		// send a success message
 		enc := json.NewEncoder(w)
 		err = enc.Encode(successReq)
 		if err != nil {
 			return &apiError{
-				"postHandler POST encode JSON",
-				err,
-				"Internal server error",
-				http.StatusInternalServerError,
+				Tag:   "postHandler POST encode JSON",
+				Error: err,
+				ID:    "internal_error",
+				Code:  http.StatusInternalServerError,
 			}
 		}
 
@@ -416,31 +514,25 @@ func postHandler(w http.ResponseWriter, r *http.Request) *apiError {
 // psikologHandler handle psikolog endpoint
 func psikologHandler(w http.ResponseWriter, r *http.Request) *apiError {
 	if r.Method != "POST" {
-		http.Redirect(w, r, "https://sundaycode.co", 302)
+		http.Redirect(w, r, config.RedirectURL, 302)
 		return nil
 	}
 
 	// read data from POST request and decode data to *database.Psikolog type
-	var p *database.Psikolog
-	decoder := json.NewDecoder(r.Body)
-	err := decoder.Decode(&p)
-	if err != nil {
-		return &apiError{
-			"psikologHandler Decode",
-			err,
-			"Internal server error",
-			http.StatusInternalServerError,
-		}
+	p := database.Psikolog{Available: true}
+	if apiErr := decodeJSON("psikologHandler Decode", r, &p); apiErr != nil {
+		return apiErr
 	}
+	normalizeSpecializations(&p)
 
 	// insert data to database
-	err = db.InsertPsikolog(p)
+	err := db.InsertPsikolog(r.Context(), &p)
 	if err != nil {
 		return &apiError{
-			"psikologHandler db.InsertPsikolog",
-			err,
-			"Internal server error",
-			http.StatusInternalServerError,
+			Tag:   "psikologHandler db.InsertPsikolog",
+			Error: err,
+			ID:    "internal_error",
+			Code:  http.StatusInternalServerError,
 		}
 	}
 
@@ -449,35 +541,28 @@ func psikologHandler(w http.ResponseWriter, r *http.Request) *apiError {
 
 // userHandler handle user endpoint
 func userHandler(w http.ResponseWriter, r *http.Request) *apiError {
-	var user *database.User
 	if r.Method == "GET" {
 		fmt.Fprintln(w, "200 OK")
 		return nil
 	}
 	if r.Method == "POST" {
 		// read data from POST request and decode data to User type
-
-		decoder := json.NewDecoder(r.Body)
-		err := decoder.Decode(&user)
-		if err != nil {
-			return &apiError{
-				"usersHandler Decode",
-				err,
-				"Internal server error",
-				http.StatusInternalServerError,
-			}
+		var user database.User
+		if apiErr := decodeJSON("usersHandler Decode", r, &user); apiErr != nil {
+			return apiErr
 		}
 
 		// insert data to database
-		err = db.InsertUser(user)
+		err := db.InsertUser(r.Context(), &user)
 		if err != nil {
 			return &apiError{
-				"usersHandler db.InsertUser",
-				err,
-				"Internal server error",
-				http.StatusInternalServerError,
+				Tag:   "usersHandler db.InsertUser",
+				Error: err,
+				ID:    "internal_error",
+				Code:  http.StatusInternalServerError,
 			}
 		}
+		setUserID(r, strconv.Itoa(user.Id))
 
 		return nil
 	}
@@ -487,63 +572,207 @@ func userHandler(w http.ResponseWriter, r *http.Request) *apiError {
 // notFoundHandler handle a not found response
 func notFoundHandler(w http.ResponseWriter, r *http.Request) *apiError {
 	return &apiError{
-		"notFoundHandler",
-		errors.New("Not Found"),
-		"Not Found",
-		http.StatusNotFound,
+		Tag:   "notFoundHandler",
+		Error: errors.New("Not Found"),
+		ID:    "route.not_found",
+		Code:  http.StatusNotFound,
 	}
 }
 
 // indexHandler handle request to '/'
 // redirect to github pages
 func indexHandler(w http.ResponseWriter, r *http.Request) *apiError {
-	http.Redirect(w, r, "https://sundaycode.co", 302)
+	http.Redirect(w, r, config.RedirectURL, 302)
 	return nil
 }
 
-func main() {
-	var err error
-	db, err = database.New()
-	if err != nil {
-		log.Fatal(err)
+// newRouter register every endpoint on a new router. Handlers use the
+// package-level db store, so it must be set before serving requests.
+func newRouter() http.Handler {
+	r := mux.NewRouter()
+	limiter := newRateLimiter()
+	routes = make(map[string][]string)
+	// handle register h on path, instrumented under that route template,
+	// rate limited if path has a limit and cached if it has a policy
+	handle := func(path string, h http.Handler) {
+		routes[path] = nil
+		if m, ok := h.(methods); ok {
+			for method := range m {
+				routes[path] = append(routes[path], method)
+			}
+		}
+		r.Handle(path, instrument(path, limiter.wrap(path, conditional(path, h))))
 	}
 
-	r := mux.NewRouter()
 	// index handler doesn't need database utils
-	r.Handle("/", ApiHandler(indexHandler))
+	handle("/", ApiHandler(indexHandler))
 
 	// not found handler
-	r.NotFoundHandler = ApiHandler(notFoundHandler)
+	r.NotFoundHandler = instrument("notfound", ApiHandler(notFoundHandler))
+
+	// prometheus metrics
+	handle("/metrics", http.HandlerFunc(metricsHandler))
+
+	// liveness & readiness probes
+	handle("/healthz", http.HandlerFunc(healthzHandler))
+	handle("/readyz", http.HandlerFunc(readyzHandler))
+
+	// API documentation, see openapi.go
+	handle("/openapi.json", http.HandlerFunc(openAPIHandler))
+	handle("/docs", http.HandlerFunc(docsHandler))
 
 	// insert data to users table
 	// POST /v0/users
-	r.Handle("/v0/users", ApiHandler(userHandler))
+	handle("/v0/users", ApiHandler(userHandler))
 
 	// reliever handler
-	r.Handle("/v0/reliever", ApiHandler(relieverHandler))
+	handle("/v0/reliever", ApiHandler(relieverHandler))
 
 	// insert data to psikologs table
 	// POST /v0/psikolog
-	r.Handle("/v0/psikologs", ApiHandler(psikologHandler))
+	handle("/v0/psikologs", ApiHandler(psikologHandler))
 
 	// get & post a wisdom points
-	r.Handle("/v0/wisdom", ApiHandler(wisdomHandler))
-	r.Handle("/v0/checkwisdom", ApiHandler(checkWisdomHandler))
+	handle("/v0/wisdom", ApiHandler(wisdomHandler))
+	handle("/v0/checkwisdom", ApiHandler(checkWisdomHandler))
 
 	// insert data to posts table
 	// POST /v0/posts
-	r.Handle("/v0/posts", ApiHandler(postHandler))
+	handle("/v0/posts", ApiHandler(postHandler))
 
 	// insert data to comments table
 	// POST /v0/comments
-	r.Handle("/v0/comments", ApiHandler(commentHandler))
+	handle("/v0/comments", ApiHandler(commentHandler))
 
 	// insert data to reports table
 	// POST /v0/reports
-	r.Handle("/v0/reports", ApiHandler(reportHandler))
+	handle("/v0/reports", ApiHandler(reportHandler))
+
+	// list the active categories
+	// GET /v0/categories
+	handle("/v0/categories", ApiHandler(categoriesHandler))
+
+	// RESTful API, see v1.go
+	handleV1(handle)
+
+	return requestLogger(cors(r))
+}
+
+// cors add the CORS headers for the origins allowed by config.CORSOrigins
+// and answer preflight requests.
+func cors(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" || !corsAllowed(origin) {
+			h.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		w.Header().Add("Vary", "Origin")
+		if r.Method == "OPTIONS" && r.Header.Get("Access-Control-Request-Method") != "" {
+			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
+			w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Accept, Accept-Language, Authorization")
+			w.Header().Set("Access-Control-Max-Age", "600")
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+func corsAllowed(origin string) bool {
+	for _, o := range config.CORSOrigins {
+		if o == "*" || strings.TrimSuffix(o, "/") == origin {
+			return true
+		}
+	}
+	return false
+}
+
+func main() {
+	var err error
+	config, err = loadConfig(os.Args[1:], os.Getenv)
+	if err != nil {
+		log.Fatal(err)
+	}
+	setupLogging(os.Stderr)
+
+	if config.Demo {
+		slog.Info("Demo mode: using in-memory store")
+		db = database.NewMemory()
+	} else {
+		pg, err := database.Open(config.DatabaseURL)
+		if err != nil {
+			slog.Error("Error opening database", "err", err)
+			os.Exit(1)
+		}
+		pg.Conn.SetMaxOpenConns(config.MaxOpenConns)
+		pg.Conn.SetMaxIdleConns(config.MaxIdleConns)
+		pg.Conn.SetConnMaxLifetime(config.ConnMaxLifetime)
+		pg.ObserveQuery = observeQuery
+		register(dbStatsCollector{pg.Conn})
+		db = pg
+		bg.Go("database setup", func(ctx context.Context) { setupDatabase(ctx, pg) })
+		if config.RateLimitBackend == "postgres" {
+			bg.Go("purge rate limits", func(ctx context.Context) { purgeRateLimits(ctx, pg) })
+		}
+	}
+	if config.CacheSize > 0 {
+		db = setupCache(db)
+	}
+	bg.Go("purge deleted posts", purgeDeletedPosts)
+	bg.Go("delete accounts", deleteAccounts)
+	bg.Go("exports", exportWorker)
+	bg.Go("purge exports", purgeExports)
+	bg.Go("escalate posts", escalatePosts)
 
 	// server listener
-	http.Handle("/", r)
-	log.Printf("Listening on :%s", PORT)
-	log.Fatal(http.ListenAndServe(":"+PORT, nil))
+	srv := &http.Server{
+		Addr:         ":" + config.Port,
+		Handler:      newRouter(),
+		ReadTimeout:  config.ReadTimeout,
+		WriteTimeout: config.WriteTimeout,
+		IdleTimeout:  config.IdleTimeout,
+	}
+	serveErr := make(chan error, 1)
+	go func() {
+		slog.Info("Listening", "port", config.Port)
+		serveErr <- srv.ListenAndServe()
+	}()
+
+	// wait for the platform to stop us
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGTERM, syscall.SIGINT)
+	select {
+	case err := <-serveErr:
+		slog.Error("Server stopped", "err", err)
+		os.Exit(1)
+	case sig := <-stop:
+		slog.Info("Shutting down", "signal", sig.String())
+	}
+
+	// stop accepting connections and let in-flight requests finish, then
+	// flush background jobs. Each gets its own config.ShutdownTimeout, so
+	// slow requests do not eat the time of the jobs.
+	ctx, cancel := context.WithTimeout(context.Background(), config.ShutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		slog.Error("Shutdown: requests still in flight were dropped", "err", err)
+		// Shutdown leaves the open connections behind on timeout
+		srv.Close()
+	}
+	bgCtx, bgCancel := context.WithTimeout(context.Background(), config.ShutdownTimeout)
+	defer bgCancel()
+	if err := bg.Shutdown(bgCtx); err != nil {
+		// the jobs left running still use the store, exiting drop its
+		// connections anyway
+		slog.Error("Shutdown: background jobs did not finish, store left open", "err", err)
+		return
+	}
+
+	// statements and connections are closed last, once nothing uses them
+	if err := db.Close(); err != nil {
+		slog.Error("Shutdown: closing store", "err", err)
+	}
+	slog.Info("Shutdown complete")
 }
@@ -0,0 +1,740 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pyk/relieve/database"
+)
+
+// The suite runs against the in-memory store. When DATABASE_URL is set it
+// runs against that postgres database instead; the database is migrated
+// and every fixture uses unique emails so existing rows are left alone.
+func newTestServer(t *testing.T) *httptest.Server {
+	if url := os.Getenv("DATABASE_URL"); url != "" {
+		pg, err := database.Open(url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := pg.Migrate(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		if err := pg.Prepare(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		db = pg
+	} else {
+		db = database.NewMemory()
+	}
+	ts := httptest.NewServer(newRouter())
+	t.Cleanup(func() {
+		ts.Close()
+		db.Close()
+	})
+	return ts
+}
+
+// uniqueEmail returns an email that does not exist yet in the store.
+func uniqueEmail(prefix string) string {
+	return fmt.Sprintf("%s-%d@example.com", prefix, time.Now().UnixNano())
+}
+
+// fixtures create a user, a psikolog and a post written by the user.
+type fixtures struct {
+	user     database.User
+	psikolog database.Psikolog
+	post     database.Post
+}
+
+func newFixtures(t *testing.T) fixtures {
+	var f fixtures
+	f.user = database.User{Email: uniqueEmail("user"), Gender: "F", Age: 21, Profession: "student"}
+	if err := db.InsertUser(context.Background(), &f.user); err != nil {
+		t.Fatal(err)
+	}
+	f.psikolog = database.Psikolog{Email: uniqueEmail("psikolog"), Name: "Dr. Sunday", Bio: "listener"}
+	if err := db.InsertPsikolog(context.Background(), &f.psikolog); err != nil {
+		t.Fatal(err)
+	}
+	f.post = database.Post{
+		UserId:     strconv.Itoa(f.user.Id),
+		PsikologId: strconv.Itoa(f.psikolog.Id),
+		Title:      "exam",
+		Category:   "study",
+		Content:    "I am anxious",
+	}
+	if err := db.InsertPost(context.Background(), &f.post); err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+// do send a request and return the response with its body already read.
+func do(t *testing.T, method, u, contentType, body string) (*http.Response, string) {
+	req, err := http.NewRequest(method, u, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	client := &http.Client{
+		// redirects go to an external site, check them instead
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, string(b)
+}
+
+func postJSON(t *testing.T, u string, v interface{}) (*http.Response, string) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return do(t, "POST", u, "application/json", string(b))
+}
+
+func checkStatus(t *testing.T, resp *http.Response, body string, code int) {
+	t.Helper()
+	if resp.StatusCode != code {
+		t.Fatalf("%s %s: got status %d, want %d; body: %s", resp.Request.Method, resp.Request.URL, resp.StatusCode, code, body)
+	}
+}
+
+// checkHeaders verify the headers ApiHandler adds to every response.
+func checkHeaders(t *testing.T, resp *http.Response) {
+	t.Helper()
+	want := map[string]string{
+		"Server":              "Relieve by Sunday Code",
+		"X-Wisdom-Media-Type": "relieve.v0",
+		"Content-Type":        "application/json; charset=utf-8",
+	}
+	for k, v := range want {
+		if got := resp.Header.Get(k); got != v {
+			t.Errorf("%s %s: header %s = %q, want %q", resp.Request.Method, resp.Request.URL, k, got, v)
+		}
+	}
+}
+
+// checkError verify the error envelope: an array with a single error.
+func checkError(t *testing.T, resp *http.Response, body string, code int, id string) {
+	t.Helper()
+	checkStatus(t, resp, body, code)
+	var errs []struct {
+		ID    string `json:"id"`
+		Error string `json:"error"`
+		Code  int    `json:"code"`
+	}
+	if err := json.Unmarshal([]byte(body), &errs); err != nil {
+		t.Fatalf("error response is not an array: %v; body: %s", err, body)
+	}
+	if len(errs) != 1 || errs[0].Code != code || errs[0].ID != id || errs[0].Error == "" {
+		t.Fatalf("got errors %+v, want [{%s %d}]", errs, id, code)
+	}
+}
+
+// decodeArray decode body into v and fail if body is not a JSON array.
+func decodeArray(t *testing.T, body string, v interface{}) {
+	t.Helper()
+	if !strings.HasPrefix(strings.TrimSpace(body), "[") {
+		t.Fatalf("response is not an array: %s", body)
+	}
+	if err := json.Unmarshal([]byte(body), v); err != nil {
+		t.Fatalf("decode %s: %v", body, err)
+	}
+}
+
+func TestIndex(t *testing.T) {
+	ts := newTestServer(t)
+
+	resp, body := do(t, "GET", ts.URL+"/", "", "")
+	checkStatus(t, resp, body, http.StatusFound)
+	checkHeaders(t, resp)
+	if loc := resp.Header.Get("Location"); loc != "https://sundaycode.co" {
+		t.Errorf("Location = %q", loc)
+	}
+}
+
+func TestNotFound(t *testing.T) {
+	ts := newTestServer(t)
+
+	for _, method := range []string{"GET", "POST"} {
+		resp, body := do(t, method, ts.URL+"/v0/nothing", "", "")
+		checkHeaders(t, resp)
+		checkError(t, resp, body, http.StatusNotFound, "route.not_found")
+	}
+}
+
+func TestUsers(t *testing.T) {
+	ts := newTestServer(t)
+
+	resp, body := do(t, "GET", ts.URL+"/v0/users", "", "")
+	checkStatus(t, resp, body, http.StatusOK)
+	checkHeaders(t, resp)
+	if body != "200 OK\n" {
+		t.Errorf("GET body = %q", body)
+	}
+
+	user := database.User{Email: uniqueEmail("signup"), Gender: "M", Age: 30, Profession: "teacher"}
+	resp, body = postJSON(t, ts.URL+"/v0/users", user)
+	checkStatus(t, resp, body, http.StatusOK)
+	checkHeaders(t, resp)
+
+	// email is unique
+	resp, body = postJSON(t, ts.URL+"/v0/users", user)
+	checkError(t, resp, body, http.StatusConflict, "user.email_taken")
+
+	resp, body = do(t, "POST", ts.URL+"/v0/users", "application/json", "{")
+	checkError(t, resp, body, http.StatusBadRequest, "request.malformed_json")
+
+	// other methods are ignored
+	resp, body = do(t, "PUT", ts.URL+"/v0/users", "", "")
+	checkStatus(t, resp, body, http.StatusOK)
+}
+
+func TestReliever(t *testing.T) {
+	ts := newTestServer(t)
+	f := newFixtures(t)
+
+	resp, body := do(t, "GET", ts.URL+"/v0/reliever", "", "")
+	checkHeaders(t, resp)
+	checkError(t, resp, body, http.StatusBadRequest, "request.missing_parameter")
+
+	resp, body = do(t, "GET", ts.URL+"/v0/reliever?reliever_id=999999999", "", "")
+	checkError(t, resp, body, http.StatusNotFound, "reliever.not_found")
+
+	resp, body = do(t, "GET", ts.URL+"/v0/reliever?reliever_id=abc", "", "")
+	checkError(t, resp, body, http.StatusUnprocessableEntity, "request.invalid")
+
+	resp, body = do(t, "GET", ts.URL+"/v0/reliever?reliever_id="+strconv.Itoa(f.psikolog.Id), "", "")
+	checkStatus(t, resp, body, http.StatusOK)
+	checkHeaders(t, resp)
+	var rls []database.Reliever
+	decodeArray(t, body, &rls)
+	if len(rls) != 1 || rls[0].Name != f.psikolog.Name || rls[0].Bio != f.psikolog.Bio {
+		t.Errorf("got relievers %+v", rls)
+	}
+}
+
+func TestPsikologs(t *testing.T) {
+	ts := newTestServer(t)
+
+	resp, body := do(t, "GET", ts.URL+"/v0/psikologs", "", "")
+	checkStatus(t, resp, body, http.StatusFound)
+	checkHeaders(t, resp)
+
+	p := database.Psikolog{Email: uniqueEmail("psikolog"), Name: "Dr. Monday", Wisdom: 0, Bio: "bio"}
+	resp, body = postJSON(t, ts.URL+"/v0/psikologs", p)
+	checkStatus(t, resp, body, http.StatusOK)
+	checkHeaders(t, resp)
+
+	resp, body = postJSON(t, ts.URL+"/v0/psikologs", p)
+	checkError(t, resp, body, http.StatusConflict, "psikolog.email_taken")
+
+	resp, body = do(t, "POST", ts.URL+"/v0/psikologs", "application/json", "not json")
+	checkError(t, resp, body, http.StatusBadRequest, "request.malformed_json")
+}
+
+func TestWisdom(t *testing.T) {
+	ts := newTestServer(t)
+	f := newFixtures(t)
+	psikologID := strconv.Itoa(f.psikolog.Id)
+	userID := strconv.Itoa(f.user.Id)
+
+	check := func(want string) {
+		t.Helper()
+		resp, body := do(t, "GET", ts.URL+"/v0/checkwisdom?psikolog_id="+psikologID+"&user_id="+userID, "", "")
+		checkStatus(t, resp, body, http.StatusOK)
+		checkHeaders(t, resp)
+		var status []database.WisdomPointStatus
+		decodeArray(t, body, &status)
+		if len(status) != 1 || status[0].Status != want {
+			t.Errorf("got wisdom status %+v, want %s", status, want)
+		}
+	}
+	points := func(want string) {
+		t.Helper()
+		resp, body := do(t, "GET", ts.URL+"/v0/wisdom?psikolog_id="+psikologID, "", "")
+		checkStatus(t, resp, body, http.StatusOK)
+		checkHeaders(t, resp)
+		var pp []database.PsikologPoint
+		decodeArray(t, body, &pp)
+		if len(pp) != 1 || pp[0].PsikologID != psikologID || pp[0].Point != want {
+			t.Errorf("got wisdom points %+v, want %s", pp, want)
+		}
+	}
+
+	check("false")
+	points("0")
+
+	wp := database.WisdomPoint{UserID: f.user.Id, PsikologID: f.psikolog.Id}
+	resp, body := postJSON(t, ts.URL+"/v0/wisdom", wp)
+	checkStatus(t, resp, body, http.StatusOK)
+	checkHeaders(t, resp)
+
+	check("true")
+	points("10")
+
+	resp, body = postJSON(t, ts.URL+"/v0/wisdom", wp)
+	checkError(t, resp, body, http.StatusConflict, "wisdom.already_given")
+
+	resp, body = do(t, "POST", ts.URL+"/v0/wisdom", "application/json", "[")
+	checkError(t, resp, body, http.StatusBadRequest, "request.malformed_json")
+
+	// without parameters both endpoints do nothing
+	resp, body = do(t, "GET", ts.URL+"/v0/wisdom", "", "")
+	checkStatus(t, resp, body, http.StatusOK)
+	resp, body = do(t, "GET", ts.URL+"/v0/checkwisdom", "", "")
+	checkStatus(t, resp, body, http.StatusOK)
+	if body != "200 OK\n" {
+		t.Errorf("GET /v0/checkwisdom body = %q", body)
+	}
+}
+
+func TestPosts(t *testing.T) {
+	ts := newTestServer(t)
+	f := newFixtures(t)
+	userID := strconv.Itoa(f.user.Id)
+
+	resp, body := do(t, "GET", ts.URL+"/v0/posts", "", "")
+	checkHeaders(t, resp)
+	checkError(t, resp, body, http.StatusBadRequest, "request.missing_parameter")
+
+	var other database.User
+	other.Email = uniqueEmail("quiet")
+	if err := db.InsertUser(context.Background(), &other); err != nil {
+		t.Fatal(err)
+	}
+	resp, body = do(t, "GET", ts.URL+"/v0/posts?user_id="+strconv.Itoa(other.Id), "", "")
+	checkError(t, resp, body, http.StatusNotFound, "post.not_found")
+
+	form := url.Values{
+		"user_id":     {userID},
+		"psikolog_id": {strconv.Itoa(f.psikolog.Id)},
+		"title":       {"family"},
+		"category":    {"home"},
+		"content":     {"my parents"},
+	}
+	resp, body = do(t, "POST", ts.URL+"/v0/posts", "application/x-www-form-urlencoded", form.Encode())
+	checkStatus(t, resp, body, http.StatusOK)
+	checkHeaders(t, resp)
+	var status StatusRequest
+	if err := json.Unmarshal([]byte(body), &status); err != nil || !status.Status {
+		t.Errorf("POST body = %s", body)
+	}
+
+	incomplete := url.Values{"user_id": {userID}}
+	resp, body = do(t, "POST", ts.URL+"/v0/posts", "application/x-www-form-urlencoded", incomplete.Encode())
+	checkError(t, resp, body, http.StatusNotAcceptable, "request.incomplete")
+
+	resp, body = do(t, "GET", ts.URL+"/v0/posts?user_id="+userID, "", "")
+	checkStatus(t, resp, body, http.StatusOK)
+	checkHeaders(t, resp)
+	var posts []database.Post
+	decodeArray(t, body, &posts)
+	if len(posts) != 2 {
+		t.Fatalf("got %d posts, want 2", len(posts))
+	}
+	if posts[0].Id != f.post.Id || posts[0].Title != "exam" || posts[1].Title != "family" {
+		t.Errorf("got posts %+v", posts)
+	}
+	for _, p := range posts {
+		if p.UserId != userID || p.Date == nil {
+			t.Errorf("bad post %+v", p)
+		}
+	}
+}
+
+func TestComments(t *testing.T) {
+	ts := newTestServer(t)
+	f := newFixtures(t)
+
+	resp, body := do(t, "GET", ts.URL+"/v0/comments", "", "")
+	checkStatus(t, resp, body, http.StatusFound)
+	checkHeaders(t, resp)
+
+	c := database.Comment{UserId: f.user.Id, PsikologId: f.psikolog.Id, PostId: f.post.Id, Text: "hang in there"}
+	resp, body = postJSON(t, ts.URL+"/v0/comments", c)
+	checkStatus(t, resp, body, http.StatusOK)
+	checkHeaders(t, resp)
+
+	// the post must exist
+	c.PostId = 999999999
+	resp, body = postJSON(t, ts.URL+"/v0/comments", c)
+	checkError(t, resp, body, http.StatusUnprocessableEntity, "post.not_found")
+}
+
+func TestReports(t *testing.T) {
+	ts := newTestServer(t)
+	f := newFixtures(t)
+
+	resp, body := do(t, "GET", ts.URL+"/v0/reports", "", "")
+	checkStatus(t, resp, body, http.StatusFound)
+	checkHeaders(t, resp)
+
+	rp := database.Report{UserId: f.user.Id, PostId: f.post.Id}
+	resp, body = postJSON(t, ts.URL+"/v0/reports", rp)
+	checkStatus(t, resp, body, http.StatusOK)
+	checkHeaders(t, resp)
+
+	rp.UserId = 999999999
+	resp, body = postJSON(t, ts.URL+"/v0/reports", rp)
+	checkError(t, resp, body, http.StatusUnprocessableEntity, "user.not_found")
+}
+
+func TestRequestID(t *testing.T) {
+	ts := newTestServer(t)
+
+	var buf bytes.Buffer
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(newLogger(&buf, "json", slog.LevelInfo))
+
+	req, err := http.NewRequest("GET", ts.URL+"/v0/reliever?reliever_id=999999999", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("X-Request-ID", "heroku-router-id")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if got := resp.Header.Get("X-Request-ID"); got != "heroku-router-id" {
+		t.Errorf("X-Request-ID = %q, want the propagated one", got)
+	}
+
+	// the api error and the access log share the request ID
+	var lines []map[string]interface{}
+	dec := json.NewDecoder(&buf)
+	for dec.More() {
+		var line map[string]interface{}
+		if err := dec.Decode(&line); err != nil {
+			t.Fatal(err)
+		}
+		lines = append(lines, line)
+	}
+	if len(lines) != 2 {
+		t.Fatalf("got %d log lines, want 2: %v", len(lines), lines)
+	}
+	for _, line := range lines {
+		if line["request_id"] != "heroku-router-id" {
+			t.Errorf("log line without request_id: %v", line)
+		}
+	}
+	access := lines[1]
+	if access["status"] != float64(http.StatusNotFound) || access["method"] != "GET" || access["path"] != "/v0/reliever" {
+		t.Errorf("bad access log: %v", access)
+	}
+
+	// a request without ID get a generated one
+	resp, _ = do(t, "GET", ts.URL+"/", "", "")
+	if id := resp.Header.Get("X-Request-ID"); len(id) != 32 {
+		t.Errorf("generated X-Request-ID = %q", id)
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	ts := newTestServer(t)
+	f := newFixtures(t)
+
+	do(t, "GET", ts.URL+"/v0/reliever?reliever_id="+strconv.Itoa(f.psikolog.Id), "", "")
+	do(t, "GET", ts.URL+"/v0/reliever", "", "")
+	form := url.Values{
+		"user_id":     {strconv.Itoa(f.user.Id)},
+		"psikolog_id": {strconv.Itoa(f.psikolog.Id)},
+		"title":       {"t"},
+		"category":    {"c"},
+		"content":     {"c"},
+	}
+	do(t, "POST", ts.URL+"/v0/posts", "application/x-www-form-urlencoded", form.Encode())
+
+	resp, body := do(t, "GET", ts.URL+"/metrics", "", "")
+	checkStatus(t, resp, body, http.StatusOK)
+	if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	for _, want := range []string{
+		`relieve_http_requests_total{route="/v0/reliever",method="GET",code="200"}`,
+		`relieve_http_requests_total{route="/v0/reliever",method="GET",code="400"}`,
+		`relieve_http_request_duration_seconds_bucket{route="/v0/posts",method="POST",le="+Inf"}`,
+		`relieve_api_errors_total{tag="relieverHandler GET"}`,
+		"# TYPE relieve_posts_created_total counter",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("metrics missing %s", want)
+		}
+	}
+	if strings.Contains(body, "relieve_posts_created_total 0\n") {
+		t.Errorf("posts created not counted")
+	}
+}
+
+func TestHealth(t *testing.T) {
+	ts := newTestServer(t)
+
+	resp, body := do(t, "GET", ts.URL+"/healthz", "", "")
+	checkStatus(t, resp, body, http.StatusOK)
+	if strings.TrimSpace(body) != `{"status":"ok"}` {
+		t.Errorf("healthz body = %s", body)
+	}
+
+	resp, body = do(t, "GET", ts.URL+"/readyz", "", "")
+	checkStatus(t, resp, body, http.StatusOK)
+	var ready healthResponse
+	if err := json.Unmarshal([]byte(body), &ready); err != nil {
+		t.Fatal(err)
+	}
+	if ready.Status != "ready" || ready.Checks["database"].Status != "ok" {
+		t.Errorf("readyz body = %s", body)
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	defer func(limits map[string]rateLimit) { config.RateLimits = limits }(config.RateLimits)
+	config.RateLimits = map[string]rateLimit{"/v0/reports": {Rate: 2.0 / 3600, Burst: 2}}
+	ts := newTestServer(t)
+	f := newFixtures(t)
+
+	rp := database.Report{UserId: f.user.Id, PostId: f.post.Id}
+	for i := 0; i < 2; i++ {
+		resp, body := postJSON(t, ts.URL+"/v0/reports", rp)
+		checkStatus(t, resp, body, http.StatusOK)
+		if got := resp.Header.Get("X-RateLimit-Remaining"); got != strconv.Itoa(1-i) {
+			t.Errorf("X-RateLimit-Remaining = %q, want %d", got, 1-i)
+		}
+	}
+	// another user_id is the same client
+	resp, body := postJSON(t, ts.URL+"/v0/reports?user_id=999", rp)
+	checkHeaders(t, resp)
+	checkError(t, resp, body, http.StatusTooManyRequests, "rate_limited")
+	if got := resp.Header.Get("Retry-After"); got != "1800" {
+		t.Errorf("Retry-After = %q, want 1800", got)
+	}
+	if got := resp.Header.Get("X-RateLimit-Limit"); got != "2" {
+		t.Errorf("X-RateLimit-Limit = %q, want 2", got)
+	}
+
+	// reads are not limited
+	resp, body = do(t, "GET", ts.URL+"/v0/reports", "", "")
+	checkStatus(t, resp, body, http.StatusFound)
+}
+
+func TestValidation(t *testing.T) {
+	ts := newTestServer(t)
+	withCategories(t, "study", "family")
+	f := newFixtures(t)
+
+	// checkFields verify the 4xx response lists exactly the given fields
+	checkFields := func(resp *http.Response, body string, code int, fields ...string) {
+		t.Helper()
+		checkStatus(t, resp, body, code)
+		var errs []apiError
+		if err := json.Unmarshal([]byte(body), &errs); err != nil || len(errs) != 1 {
+			t.Fatalf("bad error envelope %s", body)
+		}
+		var got []string
+		for _, fe := range errs[0].Fields {
+			got = append(got, fe.Field)
+		}
+		if strings.Join(got, ",") != strings.Join(fields, ",") {
+			t.Errorf("invalid fields = %v, want %v; body: %s", got, fields, body)
+		}
+	}
+
+	resp, body := do(t, "POST", ts.URL+"/v0/users", "application/json", `{"user_email":"a@example.com","admin":true}`)
+	checkFields(resp, body, http.StatusBadRequest, "admin")
+
+	resp, body = do(t, "POST", ts.URL+"/v0/users", "application/json", `{"user_email":"a@example.com","user_age":"old"}`)
+	checkFields(resp, body, http.StatusUnprocessableEntity, "user_age")
+
+	resp, body = do(t, "POST", ts.URL+"/v0/users", "application/json", `{"user_email":"a@example.com"} {}`)
+	checkFields(resp, body, http.StatusBadRequest)
+
+	resp, body = do(t, "POST", ts.URL+"/v0/users", "application/json", "")
+	checkFields(resp, body, http.StatusBadRequest)
+
+	resp, body = postJSON(t, ts.URL+"/v0/users", database.User{Email: "not an email", Age: 7})
+	checkFields(resp, body, http.StatusUnprocessableEntity, "user_email", "user_age")
+
+	resp, body = postJSON(t, ts.URL+"/v0/psikologs", database.Psikolog{Email: uniqueEmail("p"), ImageURL: "ftp://x"})
+	checkFields(resp, body, http.StatusUnprocessableEntity, "psikolog_name", "psikolog_image_url")
+
+	resp, body = postJSON(t, ts.URL+"/v0/comments", database.Comment{UserId: f.user.Id, PostId: -1, Text: " "})
+	checkFields(resp, body, http.StatusUnprocessableEntity, "comment_psikolog_id", "comment_post_id", "comment_text")
+
+	resp, body = postJSON(t, ts.URL+"/v0/wisdom", database.WisdomPoint{UserID: f.user.Id})
+	checkFields(resp, body, http.StatusUnprocessableEntity, "psikolog_id")
+
+	resp, body = postJSON(t, ts.URL+"/v0/reports", database.Report{UserId: f.user.Id})
+	checkFields(resp, body, http.StatusUnprocessableEntity, "report_post_id")
+
+	// query parameters
+	resp, body = do(t, "GET", ts.URL+"/v0/posts?user_id=1%20OR%201=1", "", "")
+	checkFields(resp, body, http.StatusUnprocessableEntity, "user_id")
+	resp, body = do(t, "GET", ts.URL+"/v0/wisdom?psikolog_id=x", "", "")
+	checkFields(resp, body, http.StatusUnprocessableEntity, "psikolog_id")
+	resp, body = do(t, "GET", ts.URL+"/v0/checkwisdom?psikolog_id=1&user_id=-1", "", "")
+	checkFields(resp, body, http.StatusUnprocessableEntity, "user_id")
+
+	form := url.Values{
+		"user_id":     {"abc"},
+		"psikolog_id": {strconv.Itoa(f.psikolog.Id)},
+		"title":       {"t"},
+		"category":    {"gossip"},
+		"content":     {"c"},
+	}
+	resp, body = do(t, "POST", ts.URL+"/v0/posts", "application/x-www-form-urlencoded", form.Encode())
+	checkFields(resp, body, http.StatusUnprocessableEntity, "user_id", "category")
+
+	// a body over the limit
+	defer func(n int64) { config.MaxBodyBytes = n }(config.MaxBodyBytes)
+	config.MaxBodyBytes = 16
+	resp, body = postJSON(t, ts.URL+"/v0/users", database.User{Email: uniqueEmail("long")})
+	checkFields(resp, body, http.StatusRequestEntityTooLarge)
+}
+
+func TestErrorLanguage(t *testing.T) {
+	ts := newTestServer(t)
+
+	tests := []struct {
+		accept, lang, message string
+	}{
+		{"", "en", "reliever_id not specified."},
+		{"id-ID,id;q=0.9,en;q=0.8", "id", "reliever_id belum diisi."},
+		{"en;q=0.5, id;q=0.7", "id", "reliever_id belum diisi."},
+		{"fr, en-GB;q=0.3", "en", "reliever_id not specified."},
+		{"fr", "en", "reliever_id not specified."},
+	}
+	for _, test := range tests {
+		req, _ := http.NewRequest("GET", ts.URL+"/v0/reliever", nil)
+		req.Header.Set("Accept-Language", test.accept)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var errs []struct {
+			ID      string                 `json:"id"`
+			Error   string                 `json:"error"`
+			Details map[string]interface{} `json:"details"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&errs)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := resp.Header.Get("Content-Language"); got != test.lang {
+			t.Errorf("Accept-Language %q: Content-Language = %q, want %q", test.accept, got, test.lang)
+		}
+		if len(errs) != 1 || errs[0].ID != "request.missing_parameter" || errs[0].Error != test.message || errs[0].Details["parameter"] != "reliever_id" {
+			t.Errorf("Accept-Language %q: got %+v", test.accept, errs)
+		}
+	}
+
+	// every message exists in every language
+	for lang, catalog := range catalogs {
+		for id := range catalogs[defaultLanguage] {
+			if catalog[id] == "" {
+				t.Errorf("catalog %s has no message for %s", lang, id)
+			}
+		}
+		for id := range catalog {
+			if catalogs[defaultLanguage][id] == "" {
+				t.Errorf("catalog %s has unknown id %s", lang, id)
+			}
+		}
+	}
+}
+
+func TestConditionalGET(t *testing.T) {
+	ts := newTestServer(t)
+	f := newFixtures(t)
+
+	get := func(path string, header ...string) (*http.Response, string) {
+		req, _ := http.NewRequest("GET", ts.URL+path, nil)
+		for i := 0; i < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		b, _ := ioutil.ReadAll(resp.Body)
+		return resp, string(b)
+	}
+
+	path := "/v0/reliever?reliever_id=" + strconv.Itoa(f.psikolog.Id)
+	resp, body := get(path)
+	checkStatus(t, resp, body, http.StatusOK)
+	etag := resp.Header.Get("ETag")
+	if !strings.HasPrefix(etag, `W/"`) {
+		t.Fatalf("ETag = %q", etag)
+	}
+	if cc := resp.Header.Get("Cache-Control"); cc != "public, max-age=300" {
+		t.Errorf("Cache-Control = %q", cc)
+	}
+
+	resp, body = get(path, "If-None-Match", etag)
+	checkStatus(t, resp, body, http.StatusNotModified)
+	if body != "" || resp.Header.Get("ETag") != etag {
+		t.Errorf("304 with body %q and ETag %q", body, resp.Header.Get("ETag"))
+	}
+	resp, body = get(path, "If-None-Match", `"other", `+strings.TrimPrefix(etag, "W/"))
+	checkStatus(t, resp, body, http.StatusNotModified)
+	resp, body = get(path, "If-None-Match", `W/"other"`)
+	checkStatus(t, resp, body, http.StatusOK)
+
+	// a new wisdom point changes the total, and so its ETag
+	wisdom := "/v0/wisdom?psikolog_id=" + strconv.Itoa(f.psikolog.Id)
+	resp, body = get(wisdom)
+	etag = resp.Header.Get("ETag")
+	resp, body = postJSON(t, ts.URL+"/v0/wisdom", database.WisdomPoint{UserID: f.user.Id, PsikologID: f.psikolog.Id})
+	checkStatus(t, resp, body, http.StatusOK)
+	if resp.Header.Get("ETag") != "" {
+		t.Error("POST answer has an ETag")
+	}
+	resp, body = get(wisdom, "If-None-Match", etag)
+	checkStatus(t, resp, body, http.StatusOK)
+
+	// Last-Modified of a post
+	post := "/v1/posts/" + strconv.Itoa(f.post.Id)
+	resp, body = get(post)
+	checkStatus(t, resp, body, http.StatusOK)
+	lm := resp.Header.Get("Last-Modified")
+	if lm == "" || resp.Header.Get("Cache-Control") != "private, no-cache" {
+		t.Fatalf("headers = %v", resp.Header)
+	}
+	resp, body = get(post, "If-Modified-Since", lm)
+	checkStatus(t, resp, body, http.StatusNotModified)
+	resp, body = get(post, "If-Modified-Since", "Mon, 02 Jan 2006 15:04:05 GMT")
+	checkStatus(t, resp, body, http.StatusOK)
+
+	// errors are not cached
+	resp, body = get("/v1/posts/999999999")
+	checkStatus(t, resp, body, http.StatusNotFound)
+	if resp.Header.Get("ETag") != "" || resp.Header.Get("Cache-Control") != "" {
+		t.Errorf("error headers = %v", resp.Header)
+	}
+}
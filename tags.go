@@ -0,0 +1,127 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/pyk/relieve/database"
+)
+
+// Tags are free-form labels of a post next to its single category. They
+// are normalized like category slugs, "#Exam Stress" is exam-stress, kept
+// once, and a post carries at most config.MaxTags of them. Unlike
+// categories nobody manages them: a tag exists while a live post has it.
+
+const (
+	// tagSuggestions is how many tags autocomplete answers
+	tagSuggestions = 10
+	// trendingTags is how many tags are trending
+	trendingTags = 20
+	// tagPageSize is how many posts of a tag are listed at once
+	tagPageSize = 50
+)
+
+// trendingWindows are the windows trending tags are counted over.
+var trendingWindows = map[string]time.Duration{
+	"day":   24 * time.Hour,
+	"week":  7 * 24 * time.Hour,
+	"month": 30 * 24 * time.Hour,
+}
+
+// tagName normalize a tag, "" when nothing is left of it.
+func tagName(s string) string {
+	return slugify(s)
+}
+
+// normalizeTags normalize the tags of p, dropping the duplicates, and
+// check their number. It is called after validate, so every tag has a
+// name left.
+func normalizeTags(p *database.Post) []fieldError {
+	names := []string{}
+	seen := map[string]bool{}
+	for _, t := range p.Tags {
+		if name := tagName(t); name != "" && !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	p.Tags = names
+	if len(names) > config.MaxTags {
+		return []fieldError{{Field: "post_tags", ID: "field.max_items", arg: strconv.Itoa(config.MaxTags)}}
+	}
+	return nil
+}
+
+// GET /v1/tags?prefix=TEXT the most used tags starting with prefix, to
+// autocomplete the tags of a post.
+func v1ListTags(w http.ResponseWriter, r *http.Request) *apiError {
+	tags, err := db.GetTags(r.Context(), tagName(r.FormValue("prefix")), tagSuggestions)
+	if err != nil {
+		return storeError("v1ListTags db.GetTags", err, "")
+	}
+	return writeJSON("v1ListTags", w, http.StatusOK, tags)
+}
+
+// GET /v1/tags/trending?window=day|week|month the most used tags of the
+// posts written in the window, a week by default.
+func v1TrendingTags(w http.ResponseWriter, r *http.Request) *apiError {
+	tag := "v1TrendingTags"
+	window, apiErr := oneOf(tag, r, "window", "week", "day", "week", "month")
+	if apiErr != nil {
+		return apiErr
+	}
+	tags, err := db.GetTrendingTags(r.Context(), time.Now().Add(-trendingWindows[window]), trendingTags)
+	if err != nil {
+		return storeError(tag+" db.GetTrendingTags", err, "")
+	}
+	return writeJSON(tag, w, http.StatusOK, tags)
+}
+
+// tagPost is a post as the readers of a tag see it. Anyone browses the
+// tags, so the author is left out.
+type tagPost struct {
+	Id         int        `json:"post_id"`
+	PsikologId string     `json:"post_psikolog_id"`
+	Date       *time.Time `json:"post_date"`
+	Title      string     `json:"post_title"`
+	Category   string     `json:"post_category"`
+	Content    string     `json:"post_content"`
+	ImageURL   string     `json:"post_image_url"`
+	Tags       []string   `json:"post_tags"`
+	UpdatedAt  *time.Time `json:"post_updated_at,omitempty"`
+}
+
+// GET /v1/tags/{tag}/posts?before_id=ID the posts of a tag, newest first,
+// without their author. Give the ID of the last post listed as before_id
+// for the next page.
+func v1ListTagPosts(w http.ResponseWriter, r *http.Request) *apiError {
+	tag := "v1ListTagPosts"
+	beforeID := 0
+	if v := r.FormValue("before_id"); v != "" {
+		if apiErr := validateID(tag, "before_id", v); apiErr != nil {
+			return apiErr
+		}
+		beforeID, _ = strconv.Atoi(v)
+	}
+	posts, err := db.GetPostsByTag(r.Context(), tagName(mux.Vars(r)["tag"]), beforeID, tagPageSize)
+	if err != nil {
+		return storeError(tag+" db.GetPostsByTag", err, "")
+	}
+	list := []tagPost{}
+	for _, p := range posts {
+		list = append(list, tagPost{
+			Id:         p.Id,
+			PsikologId: p.PsikologId,
+			Date:       p.Date,
+			Title:      p.Title,
+			Category:   p.Category,
+			Content:    p.Content,
+			ImageURL:   p.ImageURL,
+			Tags:       p.Tags,
+			UpdatedAt:  p.UpdatedAt,
+		})
+	}
+	return writeJSON(tag, w, http.StatusOK, list)
+}
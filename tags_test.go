@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/pyk/relieve/database"
+)
+
+func TestTagName(t *testing.T) {
+	for in, want := range map[string]string{
+		"exam":          "exam",
+		"#Exam":         "exam",
+		" Exam Stress ": "exam-stress",
+		"break_up!!":    "break-up",
+		"#":             "",
+	} {
+		if got := tagName(in); got != want {
+			t.Errorf("tagName(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestTags(t *testing.T) {
+	ts := newTestServer(t)
+	if _, ok := db.(*database.Memory); !ok {
+		// the tags of the other tests would be counted too
+		t.Skip("needs the in-memory store")
+	}
+	defer func(c Config) { config = c }(config)
+	config.MaxTags = 3
+	f := newFixtures(t)
+	uid := strconv.Itoa(f.user.Id)
+
+	newPost := func(tags ...string) (database.Post, string) {
+		t.Helper()
+		var p database.Post
+		resp, body := postJSON(t, ts.URL+"/v1/posts", database.Post{
+			UserId: uid, PsikologId: strconv.Itoa(f.psikolog.Id), Title: "t", Category: "c", Content: "c", Tags: tags,
+		})
+		return p, checkCreated(t, resp, body, &p)
+	}
+	exam, loc := newPost("#Exam", "exam", "Family")
+	if !reflect.DeepEqual(exam.Tags, []string{"exam", "family"}) {
+		t.Errorf("tags = %q, want exam and family", exam.Tags)
+	}
+	newPost("exam-stress")
+	newPost("family", "breakup")
+	resp, body := postJSON(t, ts.URL+"/v1/posts", database.Post{
+		UserId: uid, PsikologId: strconv.Itoa(f.psikolog.Id), Title: "t", Category: "c", Content: "c", Tags: []string{"a", "b", "c", "d"},
+	})
+	checkV1Error(t, resp, body, http.StatusUnprocessableEntity, "request.invalid")
+	resp, body = postJSON(t, ts.URL+"/v1/posts", database.Post{
+		UserId: uid, PsikologId: strconv.Itoa(f.psikolog.Id), Title: "t", Category: "c", Content: "c", Tags: []string{"ok", "!!"},
+	})
+	checkV1Error(t, resp, body, http.StatusUnprocessableEntity, "request.invalid")
+
+	// v0 takes the tags separated by commas
+	form := url.Values{
+		"user_id":     {uid},
+		"psikolog_id": {strconv.Itoa(f.psikolog.Id)},
+		"title":       {"t"},
+		"category":    {"c"},
+		"content":     {"c"},
+		"tags":        {"Exam,sleep"},
+	}
+	resp, body = do(t, "POST", ts.URL+"/v0/posts", "application/x-www-form-urlencoded", form.Encode())
+	checkStatus(t, resp, body, http.StatusOK)
+
+	counts := func(path string) []database.TagCount {
+		t.Helper()
+		resp, body := do(t, "GET", ts.URL+path, "", "")
+		checkStatus(t, resp, body, http.StatusOK)
+		var c []database.TagCount
+		decodeArray(t, body, &c)
+		return c
+	}
+	want := []database.TagCount{{Name: "exam", Posts: 2}, {Name: "exam-stress", Posts: 1}}
+	if got := counts("/v1/tags?prefix=%23Ex"); !reflect.DeepEqual(got, want) {
+		t.Errorf("GET /v1/tags?prefix=#Ex = %+v, want %+v", got, want)
+	}
+	if got := counts("/v1/tags/trending?window=day"); len(got) != 5 || got[0] != (database.TagCount{Name: "exam", Posts: 2}) || got[1] != (database.TagCount{Name: "family", Posts: 2}) {
+		t.Errorf("GET /v1/tags/trending = %+v", got)
+	}
+	resp, body = do(t, "GET", ts.URL+"/v1/tags/trending?window=year", "", "")
+	checkV1Error(t, resp, body, http.StatusUnprocessableEntity, "request.invalid")
+	if got, err := db.GetTrendingTags(context.Background(), time.Now().Add(time.Hour), trendingTags); err != nil || len(got) != 0 {
+		t.Errorf("trending tags of the future = %+v, %v", got, err)
+	}
+
+	// the author can change the tags, other fields keep theirs
+	resp, body = do(t, "PATCH", ts.URL+loc+"?user_id="+uid, "application/json", `{"post_tags": ["Sleep"]}`)
+	checkStatus(t, resp, body, http.StatusOK)
+	resp, body = do(t, "PATCH", ts.URL+loc+"?user_id="+uid, "application/json", `{"post_title": "still tagged"}`)
+	checkStatus(t, resp, body, http.StatusOK)
+	if p, err := db.GetPost(context.Background(), exam.Id); err != nil || !reflect.DeepEqual(p.Tags, []string{"sleep"}) {
+		t.Errorf("tags after edit = %q, %v", p.Tags, err)
+	}
+
+	// the posts of a tag, newest first and paged
+	posts := func(path string) []database.Post {
+		t.Helper()
+		resp, body := do(t, "GET", ts.URL+path, "", "")
+		checkStatus(t, resp, body, http.StatusOK)
+		var p []database.Post
+		decodeArray(t, body, &p)
+		return p
+	}
+	sleep := posts("/v1/tags/Sleep/posts")
+	if len(sleep) != 2 || sleep[1].Id != exam.Id || sleep[1].UserId != "" || sleep[1].Content != "c" {
+		t.Fatalf("posts of sleep = %+v", sleep)
+	}
+	if got := posts("/v1/tags/sleep/posts?before_id=" + strconv.Itoa(sleep[0].Id)); len(got) != 1 || got[0].Id != exam.Id {
+		t.Errorf("second page = %+v", got)
+	}
+	if got := posts("/v1/tags/nothing/posts"); len(got) != 0 {
+		t.Errorf("posts of an unused tag = %+v", got)
+	}
+	resp, body = do(t, "GET", ts.URL+"/v1/tags/sleep/posts?before_id=x", "", "")
+	checkV1Error(t, resp, body, http.StatusUnprocessableEntity, "request.invalid")
+}
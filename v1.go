@@ -0,0 +1,702 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/pyk/relieve/database"
+)
+
+// The v1 API lives next to v0, which keeps working unchanged. IDs are in
+// the path, single resources are not wrapped in arrays, creates answer
+// 201 with a Location header and the new resource, deletes answer 204 and
+// unsupported methods 405 with an Allow header.
+func handleV1(handle func(path string, h http.Handler)) {
+	handle("/v1/users", methods{"POST": v1CreateUser})
+	handle("/v1/users/{id}", methods{"GET": v1GetUser, "DELETE": v1DeleteUser})
+	handle("/v1/users/{id}/deletion", methods{"GET": v1GetDeletion, "DELETE": v1CancelDeletion})
+	handle("/v1/users/{id}/exports", methods{"POST": v1CreateExport})
+	handle("/v1/users/{id}/exports/{export_id}", methods{"GET": v1GetExport})
+	handle("/v1/exports/{token}", methods{"GET": v1DownloadExport})
+
+	handle("/v1/psikologs", methods{"POST": v1CreatePsikolog})
+	handle("/v1/psikologs/{id}", methods{"GET": v1GetPsikolog, "PATCH": v1UpdatePsikolog})
+	handle("/v1/psikologs/{id}/wisdom", methods{"GET": v1GetWisdom, "POST": v1GiveWisdom})
+	handle("/v1/psikologs/{id}/wisdom/{user_id}", methods{"GET": v1CheckWisdom})
+	handle("/v1/psikologs/{id}/response-times", methods{"GET": v1GetResponseTimes})
+	handle("/v1/psikologs/{id}/verified", methods{"PUT": v1VerifyPsikolog, "DELETE": v1UnverifyPsikolog})
+	handle("/v1/psikologs/{id}/notifications", methods{"GET": v1ListNotifications})
+
+	handle("/v1/posts", methods{"GET": v1ListPosts, "POST": v1CreatePost})
+	handle("/v1/posts/{id}", methods{"GET": v1GetPost, "PATCH": v1UpdatePost, "DELETE": v1DeletePost})
+	handle("/v1/posts/{id}/revisions", methods{"GET": v1ListRevisions})
+	handle("/v1/posts/{id}/assignments", methods{"GET": v1ListAssignments})
+	handle("/v1/posts/{id}/claim", methods{"POST": v1ClaimPost})
+	handle("/v1/pool", methods{"GET": v1ListPool})
+	handle("/v1/posts/{id}/comments", methods{"GET": v1ListComments, "POST": v1CreateComment})
+	handle("/v1/comments/{id}", methods{"GET": v1GetComment, "PATCH": v1UpdateComment, "DELETE": v1DeleteComment})
+	handle("/v1/comments/{id}/revisions", methods{"GET": v1ListCommentRevisions})
+	handle("/v1/comments/{id}/hidden", methods{"PUT": v1HideComment, "DELETE": v1RestoreComment})
+
+	handle("/v1/tags", methods{"GET": v1ListTags})
+	handle("/v1/tags/trending", methods{"GET": v1TrendingTags})
+	handle("/v1/tags/{tag}/posts", methods{"GET": v1ListTagPosts})
+
+	handle("/v1/reports", methods{"POST": v1CreateReport})
+	handle("/v1/reports/{id}", methods{"GET": v1GetReport})
+
+	handle("/v1/admin/audit", methods{"GET": v1ListAudit})
+	handle("/v1/admin/analytics/activity", methods{"GET": v1AnalyticsActivity})
+	handle("/v1/admin/analytics/posts", methods{"GET": v1AnalyticsPosts})
+	handle("/v1/admin/response-times", methods{"GET": v1ListResponseTimes})
+	handle("/v1/admin/notifications", methods{"GET": v1ListAdminNotifications})
+	handle("/v1/admin/categories", methods{"GET": v1ListCategories, "POST": v1CreateCategory})
+	handle("/v1/admin/categories/{slug}", methods{"GET": v1GetCategory, "PATCH": v1UpdateCategory, "DELETE": v1DeleteCategory})
+}
+
+// methods dispatch a v1 resource on the request method. HEAD is served by
+// GET, OPTIONS list the allowed methods and any other method is a 405.
+type methods map[string]ApiHandler
+
+func (m methods) allow() string {
+	var allow []string
+	for method := range m {
+		allow = append(allow, method)
+	}
+	if _, ok := m["GET"]; ok {
+		allow = append(allow, "HEAD")
+	}
+	allow = append(allow, "OPTIONS")
+	sort.Strings(allow)
+	return strings.Join(allow, ", ")
+}
+
+func (m methods) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h, ok := m[r.Method]
+	if !ok && r.Method == "HEAD" {
+		h, ok = m["GET"]
+	}
+	if ok {
+		h.ServeHTTP(w, r)
+		return
+	}
+	w.Header().Set("Allow", m.allow())
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	ApiHandler(methodNotAllowed).ServeHTTP(w, r)
+}
+
+func methodNotAllowed(w http.ResponseWriter, r *http.Request) *apiError {
+	return &apiError{
+		Tag:     "methodNotAllowed",
+		Error:   fmt.Errorf("method %s not allowed", r.Method),
+		ID:      "method.not_allowed",
+		Code:    http.StatusMethodNotAllowed,
+		Details: map[string]interface{}{"allow": w.Header().Get("Allow")},
+	}
+}
+
+// pathID return the path variable name, which must be a positive integer.
+func pathID(tag string, r *http.Request, name string) (int, *apiError) {
+	v := mux.Vars(r)[name]
+	if apiErr := validateID(tag, name, v); apiErr != nil {
+		return 0, apiErr
+	}
+	id, _ := strconv.Atoi(v)
+	return id, nil
+}
+
+// storeError answer an error of the store. A missing record is a 404 with
+// notFoundID, other errors are left to mapStoreError.
+func storeError(tag string, err error, notFoundID string) *apiError {
+	if notFoundID != "" && errors.Is(err, database.ErrNotFound) {
+		return &apiError{
+			Tag:   tag,
+			Error: err,
+			ID:    notFoundID,
+			Code:  http.StatusNotFound,
+		}
+	}
+	return &apiError{
+		Tag:   tag,
+		Error: err,
+		ID:    "internal_error",
+		Code:  http.StatusInternalServerError,
+	}
+}
+
+// writeJSON answer v with the status code.
+func writeJSON(tag string, w http.ResponseWriter, code int, v interface{}) *apiError {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return &apiError{
+			Tag:   tag + " encode JSON",
+			Error: err,
+			ID:    "internal_error",
+			Code:  http.StatusInternalServerError,
+		}
+	}
+	w.WriteHeader(code)
+	w.Write(append(b, '\n'))
+	return nil
+}
+
+// created answer 201 with the location and the representation of the new
+// resource.
+func created(tag string, w http.ResponseWriter, location string, v interface{}) *apiError {
+	w.Header().Set("Location", location)
+	return writeJSON(tag, w, http.StatusCreated, v)
+}
+
+// POST /v1/users
+func v1CreateUser(w http.ResponseWriter, r *http.Request) *apiError {
+	var u database.User
+	if apiErr := decodeJSON("v1CreateUser Decode", r, &u); apiErr != nil {
+		return apiErr
+	}
+	if err := db.InsertUser(r.Context(), &u); err != nil {
+		return storeError("v1CreateUser db.InsertUser", err, "")
+	}
+	setUserID(r, strconv.Itoa(u.Id))
+	return created("v1CreateUser", w, fmt.Sprintf("/v1/users/%d", u.Id), u)
+}
+
+// GET /v1/users/{id} ; the record holds the email and profile of the
+// user, so only moderators can read it.
+func v1GetUser(w http.ResponseWriter, r *http.Request) *apiError {
+	if apiErr := requireAdmin("v1GetUser", r); apiErr != nil {
+		return apiErr
+	}
+	id, apiErr := pathID("v1GetUser", r, "id")
+	if apiErr != nil {
+		return apiErr
+	}
+	u, err := db.GetUser(r.Context(), id)
+	if err != nil {
+		return storeError("v1GetUser db.GetUser", err, "user.not_found")
+	}
+	return writeJSON("v1GetUser", w, http.StatusOK, u)
+}
+
+// POST /v1/psikologs
+func v1CreatePsikolog(w http.ResponseWriter, r *http.Request) *apiError {
+	p := database.Psikolog{Available: true}
+	if apiErr := decodeJSON("v1CreatePsikolog Decode", r, &p); apiErr != nil {
+		return apiErr
+	}
+	normalizeSpecializations(&p)
+	if err := db.InsertPsikolog(r.Context(), &p); err != nil {
+		return storeError("v1CreatePsikolog db.InsertPsikolog", err, "")
+	}
+	return created("v1CreatePsikolog", w, fmt.Sprintf("/v1/psikologs/%d", p.Id), p)
+}
+
+// psikologProfile is the public view of a psikolog, cached by anyone on
+// the way: everything but the email.
+type psikologProfile struct {
+	Id              int      `json:"psikolog_id"`
+	Name            string   `json:"psikolog_name"`
+	ImageURL        string   `json:"psikolog_image_url"`
+	Wisdom          int      `json:"psikolog_wisdom,string"`
+	Bio             string   `json:"psikolog_bio"`
+	Specializations []string `json:"psikolog_specializations"`
+	Available       bool     `json:"psikolog_available"`
+	Verified        bool     `json:"psikolog_verified"`
+}
+
+// GET /v1/psikologs/{id} the public profile of a psikolog.
+func v1GetPsikolog(w http.ResponseWriter, r *http.Request) *apiError {
+	id, apiErr := pathID("v1GetPsikolog", r, "id")
+	if apiErr != nil {
+		return apiErr
+	}
+	p, err := db.GetPsikolog(r.Context(), id)
+	if err != nil {
+		return storeError("v1GetPsikolog db.GetPsikolog", err, "psikolog.not_found")
+	}
+	return writeJSON("v1GetPsikolog", w, http.StatusOK, newPsikologProfile(p))
+}
+
+func newPsikologProfile(p database.Psikolog) psikologProfile {
+	return psikologProfile{
+		Id:              p.Id,
+		Name:            p.Name,
+		ImageURL:        p.ImageURL,
+		Wisdom:          p.Wisdom,
+		Bio:             p.Bio,
+		Specializations: p.Specializations,
+		Available:       p.Available,
+		Verified:        p.Verified,
+	}
+}
+
+// PATCH /v1/psikologs/{id} ; the fields in the body replace the ones of
+// the psikolog, the others are kept. Psikologs cannot log in yet, so only
+// moderators change them. The wisdom is kept, it counts the points given.
+func v1UpdatePsikolog(w http.ResponseWriter, r *http.Request) *apiError {
+	if apiErr := requireAdmin("v1UpdatePsikolog", r); apiErr != nil {
+		return apiErr
+	}
+	id, apiErr := pathID("v1UpdatePsikolog", r, "id")
+	if apiErr != nil {
+		return apiErr
+	}
+	p, err := db.GetPsikolog(r.Context(), id)
+	if err != nil {
+		return storeError("v1UpdatePsikolog db.GetPsikolog", err, "psikolog.not_found")
+	}
+	if apiErr := decodeJSON("v1UpdatePsikolog Decode", r, &p); apiErr != nil {
+		return apiErr
+	}
+	normalizeSpecializations(&p)
+	p.Id = id
+	if err := db.UpdatePsikolog(r.Context(), &p); err != nil {
+		return storeError("v1UpdatePsikolog db.UpdatePsikolog", err, "psikolog.not_found")
+	}
+	return writeJSON("v1UpdatePsikolog", w, http.StatusOK, newPsikologProfile(p))
+}
+
+// GET /v1/psikologs/{id}/wisdom return the sum of the psikolog wisdom
+// points.
+func v1GetWisdom(w http.ResponseWriter, r *http.Request) *apiError {
+	id, apiErr := pathID("v1GetWisdom", r, "id")
+	if apiErr != nil {
+		return apiErr
+	}
+	if _, err := db.GetPsikolog(r.Context(), id); err != nil {
+		return storeError("v1GetWisdom db.GetPsikolog", err, "psikolog.not_found")
+	}
+	p, err := db.GetWisdomPointByID(r.Context(), strconv.Itoa(id))
+	if err != nil {
+		return storeError("v1GetWisdom db.GetWisdomPointByID", err, "")
+	}
+	return writeJSON("v1GetWisdom", w, http.StatusOK, p)
+}
+
+// POST /v1/psikologs/{id}/wisdom ; with data: {"user_id": 1}
+func v1GiveWisdom(w http.ResponseWriter, r *http.Request) *apiError {
+	id, apiErr := pathID("v1GiveWisdom", r, "id")
+	if apiErr != nil {
+		return apiErr
+	}
+	// the psikolog is the one in the path, whatever the body says
+	wp := database.WisdomPoint{PsikologID: id}
+	if apiErr := decodeJSON("v1GiveWisdom Decode", r, &wp); apiErr != nil {
+		return apiErr
+	}
+	wp.PsikologID = id
+	setUserID(r, strconv.Itoa(wp.UserID))
+	if err := db.InsertWisdomPoint(r.Context(), &wp); err != nil {
+		return storeError("v1GiveWisdom db.InsertWisdomPoint", err, "")
+	}
+	wisdomGiven.Inc()
+	return created("v1GiveWisdom", w, fmt.Sprintf("/v1/psikologs/%d/wisdom/%d", id, wp.UserID), wp)
+}
+
+// wisdomPoint read the psikolog and the user of a wisdom point from the
+// path.
+func wisdomPoint(tag string, r *http.Request) (database.WisdomPoint, *apiError) {
+	var wp database.WisdomPoint
+	var apiErr *apiError
+	if wp.PsikologID, apiErr = pathID(tag, r, "id"); apiErr != nil {
+		return wp, apiErr
+	}
+	if wp.UserID, apiErr = pathID(tag, r, "user_id"); apiErr != nil {
+		return wp, apiErr
+	}
+	setUserID(r, strconv.Itoa(wp.UserID))
+	return wp, nil
+}
+
+// GET /v1/psikologs/{id}/wisdom/{user_id} is found if the user gave the
+// psikolog a wisdom point.
+func v1CheckWisdom(w http.ResponseWriter, r *http.Request) *apiError {
+	wp, apiErr := wisdomPoint("v1CheckWisdom", r)
+	if apiErr != nil {
+		return apiErr
+	}
+	s, err := db.CheckWisdomPoint(r.Context(), strconv.Itoa(wp.UserID), strconv.Itoa(wp.PsikologID))
+	if err != nil {
+		return storeError("v1CheckWisdom db.CheckWisdomPoint", err, "")
+	}
+	if s.Status != "true" {
+		return &apiError{
+			Tag:   "v1CheckWisdom",
+			Error: errors.New("wisdom point not given"),
+			ID:    "wisdom.not_found",
+			Code:  http.StatusNotFound,
+		}
+	}
+	return writeJSON("v1CheckWisdom", w, http.StatusOK, wp)
+}
+
+// GET /v1/posts?user_id=ID list the posts of a user, oldest first.
+func v1ListPosts(w http.ResponseWriter, r *http.Request) *apiError {
+	userID := r.FormValue("user_id")
+	setUserID(r, userID)
+	if userID == "" {
+		return &apiError{
+			Tag:     "v1ListPosts",
+			Error:   errors.New("v1ListPosts user_id not specified"),
+			ID:      "request.missing_parameter",
+			Code:    http.StatusBadRequest,
+			Details: map[string]interface{}{"parameter": "user_id"},
+		}
+	}
+	if apiErr := validateID("v1ListPosts", "user_id", userID); apiErr != nil {
+		return apiErr
+	}
+	posts, err := db.GetAllPostsByUserID(r.Context(), userID)
+	if errors.Is(err, database.ErrNotFound) {
+		posts, err = []database.Post{}, nil
+	}
+	if err != nil {
+		return storeError("v1ListPosts db.GetAllPostsByUserID", err, "")
+	}
+	return writeJSON("v1ListPosts", w, http.StatusOK, posts)
+}
+
+// POST /v1/posts
+func v1CreatePost(w http.ResponseWriter, r *http.Request) *apiError {
+	var p database.Post
+	if apiErr := decodeJSON("v1CreatePost Decode", r, &p); apiErr != nil {
+		return apiErr
+	}
+	normalizeCategory(&p)
+	if errs := normalizeTags(&p); errs != nil {
+		return invalidRequest("v1CreatePost", errs)
+	}
+	setUserID(r, p.UserId)
+	reason, err := assign(r.Context(), &p)
+	if err != nil {
+		return assignError("v1CreatePost", err)
+	}
+	if err := db.InsertPost(r.Context(), &p); err != nil {
+		return storeError("v1CreatePost db.InsertPost", err, "")
+	}
+	postsCreated.Inc()
+	psikologID, _ := strconv.Atoi(p.PsikologId)
+	recordAssignment(r.Context(), p.Id, psikologID, reason)
+	return created("v1CreatePost", w, fmt.Sprintf("/v1/posts/%d", p.Id), p)
+}
+
+// GET /v1/posts/{id}
+func v1GetPost(w http.ResponseWriter, r *http.Request) *apiError {
+	id, apiErr := pathID("v1GetPost", r, "id")
+	if apiErr != nil {
+		return apiErr
+	}
+	p, err := db.GetPost(r.Context(), id)
+	if err != nil {
+		return storeError("v1GetPost db.GetPost", err, "post.not_found")
+	}
+	if p.UpdatedAt != nil {
+		setLastModified(w, p.UpdatedAt)
+	} else {
+		setLastModified(w, p.Date)
+	}
+	return writeJSON("v1GetPost", w, http.StatusOK, p)
+}
+
+// postFor load the post in the path for the user or psikolog in the query
+// parameter param, who must be its author or its psikolog. Anyone else
+// gets a 403 with ID forbiddenID.
+func postFor(tag string, r *http.Request, param, forbiddenID string) (database.Post, *apiError) {
+	id, apiErr := pathID(tag, r, "id")
+	if apiErr != nil {
+		return database.Post{}, apiErr
+	}
+	who, apiErr := queryID(tag, r, param)
+	if apiErr != nil {
+		return database.Post{}, apiErr
+	}
+	p, err := db.GetPost(r.Context(), id)
+	if err != nil {
+		return p, storeError(tag+" db.GetPost", err, "post.not_found")
+	}
+	owner := p.UserId
+	if param == "psikolog_id" {
+		owner = p.PsikologId
+	}
+	if strconv.Itoa(who) != owner {
+		return p, forbidden(tag, fmt.Errorf("%s %d is not the one of post %d", param, who, id), forbiddenID)
+	}
+	return p, nil
+}
+
+// queryID return the query parameter name, which is required and must be
+// a positive integer.
+func queryID(tag string, r *http.Request, name string) (int, *apiError) {
+	v := r.FormValue(name)
+	if v == "" {
+		return 0, &apiError{
+			Tag:     tag,
+			Error:   fmt.Errorf("%s %s not specified", tag, name),
+			ID:      "request.missing_parameter",
+			Code:    http.StatusBadRequest,
+			Details: map[string]interface{}{"parameter": name},
+		}
+	}
+	if apiErr := validateID(tag, name, v); apiErr != nil {
+		return 0, apiErr
+	}
+	id, _ := strconv.Atoi(v)
+	return id, nil
+}
+
+func forbidden(tag string, err error, id string) *apiError {
+	return &apiError{
+		Tag:   tag,
+		Error: err,
+		ID:    id,
+		Code:  http.StatusForbidden,
+	}
+}
+
+// PATCH /v1/posts/{id}?user_id=ID ; the author can change the title,
+// category, content and tags, the previous version is kept as a revision.
+func v1UpdatePost(w http.ResponseWriter, r *http.Request) *apiError {
+	setUserID(r, r.FormValue("user_id"))
+	p, apiErr := postFor("v1UpdatePost", r, "user_id", "post.not_author")
+	if apiErr != nil {
+		return apiErr
+	}
+	edit := p
+	if edit.PsikologId == "" {
+		// a post of the open pool has no psikolog, which Post requires
+		edit.PsikologId = autoAssign
+	}
+	if apiErr := decodeJSON("v1UpdatePost Decode", r, &edit); apiErr != nil {
+		return apiErr
+	}
+	normalizeCategory(&edit)
+	if errs := normalizeTags(&edit); errs != nil {
+		return invalidRequest("v1UpdatePost", errs)
+	}
+	p.Title, p.Category, p.Content, p.Tags = edit.Title, edit.Category, edit.Content, edit.Tags
+	if err := db.UpdatePost(r.Context(), &p); err != nil {
+		return storeError("v1UpdatePost db.UpdatePost", err, "post.not_found")
+	}
+	return writeJSON("v1UpdatePost", w, http.StatusOK, p)
+}
+
+// DELETE /v1/posts/{id}?user_id=ID ; the post is hidden at once and
+// purged after config.PostRetention.
+func v1DeletePost(w http.ResponseWriter, r *http.Request) *apiError {
+	setUserID(r, r.FormValue("user_id"))
+	p, apiErr := postFor("v1DeletePost", r, "user_id", "post.not_author")
+	if apiErr != nil {
+		return apiErr
+	}
+	if err := db.DeletePost(r.Context(), &p); err != nil {
+		return storeError("v1DeletePost db.DeletePost", err, "post.not_found")
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// GET /v1/posts/{id}/revisions?psikolog_id=ID list the previous versions
+// of a post, oldest first, for the psikolog of the post.
+func v1ListRevisions(w http.ResponseWriter, r *http.Request) *apiError {
+	p, apiErr := postFor("v1ListRevisions", r, "psikolog_id", "post.not_assigned")
+	if apiErr != nil {
+		return apiErr
+	}
+	revisions, err := db.GetPostRevisions(r.Context(), p.Id)
+	if err != nil {
+		return storeError("v1ListRevisions db.GetPostRevisions", err, "")
+	}
+	return writeJSON("v1ListRevisions", w, http.StatusOK, revisions)
+}
+
+// GET /v1/posts/{id}/comments list the comments of a post, oldest first.
+func v1ListComments(w http.ResponseWriter, r *http.Request) *apiError {
+	id, apiErr := pathID("v1ListComments", r, "id")
+	if apiErr != nil {
+		return apiErr
+	}
+	if _, err := db.GetPost(r.Context(), id); err != nil {
+		return storeError("v1ListComments db.GetPost", err, "post.not_found")
+	}
+	comments, err := db.GetCommentsByPostID(r.Context(), id)
+	if err != nil {
+		return storeError("v1ListComments db.GetCommentsByPostID", err, "")
+	}
+	return writeJSON("v1ListComments", w, http.StatusOK, comments)
+}
+
+// POST /v1/posts/{id}/comments
+func v1CreateComment(w http.ResponseWriter, r *http.Request) *apiError {
+	id, apiErr := pathID("v1CreateComment", r, "id")
+	if apiErr != nil {
+		return apiErr
+	}
+	// the post is the one in the path, whatever the body says
+	c := database.Comment{PostId: id}
+	if apiErr := decodeJSON("v1CreateComment Decode", r, &c); apiErr != nil {
+		return apiErr
+	}
+	c.PostId = id
+	setUserID(r, strconv.Itoa(c.UserId))
+	if err := db.InsertComment(r.Context(), &c); err != nil {
+		return storeError("v1CreateComment db.InsertComment", err, "")
+	}
+	commentsCreated.Inc()
+	return created("v1CreateComment", w, fmt.Sprintf("/v1/comments/%d", c.Id), c)
+}
+
+// GET /v1/comments/{id}
+func v1GetComment(w http.ResponseWriter, r *http.Request) *apiError {
+	id, apiErr := pathID("v1GetComment", r, "id")
+	if apiErr != nil {
+		return apiErr
+	}
+	c, err := db.GetComment(r.Context(), id)
+	if err != nil {
+		return storeError("v1GetComment db.GetComment", err, "comment.not_found")
+	}
+	if c.UpdatedAt != nil {
+		setLastModified(w, c.UpdatedAt)
+	} else {
+		setLastModified(w, c.Date)
+	}
+	return writeJSON("v1GetComment", w, http.StatusOK, c)
+}
+
+// commentFor load the comment in the path for the psikolog in the
+// psikolog_id query parameter, who must have written it. Moderators may
+// act on any comment when anyone is true.
+func commentFor(tag string, r *http.Request, anyone bool) (database.Comment, *apiError) {
+	id, apiErr := pathID(tag, r, "id")
+	if apiErr != nil {
+		return database.Comment{}, apiErr
+	}
+	moderator := anyone && isAdmin(r)
+	var who int
+	if !moderator {
+		if who, apiErr = queryID(tag, r, "psikolog_id"); apiErr != nil {
+			return database.Comment{}, apiErr
+		}
+	}
+	c, err := db.GetComment(r.Context(), id)
+	if err != nil {
+		return c, storeError(tag+" db.GetComment", err, "comment.not_found")
+	}
+	if !moderator && who != c.PsikologId {
+		return c, forbidden(tag, fmt.Errorf("psikolog_id %d did not write comment %d", who, id), "comment.not_author")
+	}
+	return c, nil
+}
+
+// PATCH /v1/comments/{id}?psikolog_id=ID ; the psikolog who wrote the
+// comment can change its text, the previous version is kept as a revision.
+func v1UpdateComment(w http.ResponseWriter, r *http.Request) *apiError {
+	c, apiErr := commentFor("v1UpdateComment", r, false)
+	if apiErr != nil {
+		return apiErr
+	}
+	edit := c
+	if apiErr := decodeJSON("v1UpdateComment Decode", r, &edit); apiErr != nil {
+		return apiErr
+	}
+	c.Text = edit.Text
+	if err := db.UpdateComment(r.Context(), &c); err != nil {
+		return storeError("v1UpdateComment db.UpdateComment", err, "comment.not_found")
+	}
+	return writeJSON("v1UpdateComment", w, http.StatusOK, c)
+}
+
+// DELETE /v1/comments/{id}?psikolog_id=ID retract a comment.
+func v1DeleteComment(w http.ResponseWriter, r *http.Request) *apiError {
+	c, apiErr := commentFor("v1DeleteComment", r, false)
+	if apiErr != nil {
+		return apiErr
+	}
+	if err := db.DeleteComment(r.Context(), &c); err != nil {
+		return storeError("v1DeleteComment db.DeleteComment", err, "comment.not_found")
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// GET /v1/comments/{id}/revisions?psikolog_id=ID list the previous
+// versions of a comment, oldest first, for its psikolog or a moderator.
+func v1ListCommentRevisions(w http.ResponseWriter, r *http.Request) *apiError {
+	c, apiErr := commentFor("v1ListCommentRevisions", r, true)
+	if apiErr != nil {
+		return apiErr
+	}
+	revisions, err := db.GetCommentRevisions(r.Context(), c.Id)
+	if err != nil {
+		return storeError("v1ListCommentRevisions db.GetCommentRevisions", err, "")
+	}
+	return writeJSON("v1ListCommentRevisions", w, http.StatusOK, revisions)
+}
+
+// PUT /v1/comments/{id}/hidden hide a comment from every read, for
+// moderators.
+func v1HideComment(w http.ResponseWriter, r *http.Request) *apiError {
+	return setCommentHidden("v1HideComment", w, r, true)
+}
+
+// DELETE /v1/comments/{id}/hidden restore a hidden comment, for
+// moderators.
+func v1RestoreComment(w http.ResponseWriter, r *http.Request) *apiError {
+	return setCommentHidden("v1RestoreComment", w, r, false)
+}
+
+func setCommentHidden(tag string, w http.ResponseWriter, r *http.Request, hidden bool) *apiError {
+	if apiErr := requireAdmin(tag, r); apiErr != nil {
+		return apiErr
+	}
+	id, apiErr := pathID(tag, r, "id")
+	if apiErr != nil {
+		return apiErr
+	}
+	c := database.Comment{Id: id}
+	if err := db.SetCommentHidden(r.Context(), &c, hidden); err != nil {
+		return storeError(tag+" db.SetCommentHidden", err, "comment.not_found")
+	}
+	slog.InfoContext(r.Context(), "Comment moderated", "comment_id", id, "hidden", hidden)
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// POST /v1/reports
+func v1CreateReport(w http.ResponseWriter, r *http.Request) *apiError {
+	var rp database.Report
+	if apiErr := decodeJSON("v1CreateReport Decode", r, &rp); apiErr != nil {
+		return apiErr
+	}
+	setUserID(r, strconv.Itoa(rp.UserId))
+	if err := db.InsertReport(r.Context(), &rp); err != nil {
+		return storeError("v1CreateReport db.InsertReport", err, "")
+	}
+	reportsFiled.Inc()
+	return created("v1CreateReport", w, fmt.Sprintf("/v1/reports/%d", rp.Id), rp)
+}
+
+// GET /v1/reports/{id} ; moderators only, the report name its reporter.
+func v1GetReport(w http.ResponseWriter, r *http.Request) *apiError {
+	if apiErr := requireAdmin("v1GetReport", r); apiErr != nil {
+		return apiErr
+	}
+	id, apiErr := pathID("v1GetReport", r, "id")
+	if apiErr != nil {
+		return apiErr
+	}
+	rp, err := db.GetReport(r.Context(), id)
+	if err != nil {
+		return storeError("v1GetReport db.GetReport", err, "report.not_found")
+	}
+	return writeJSON("v1GetReport", w, http.StatusOK, rp)
+}
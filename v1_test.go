@@ -0,0 +1,398 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pyk/relieve/database"
+)
+
+// checkCreated verify a 201 answer and decode the new resource into v. It
+// return the Location.
+func checkCreated(t *testing.T, resp *http.Response, body string, v interface{}) string {
+	t.Helper()
+	checkStatus(t, resp, body, http.StatusCreated)
+	loc := resp.Header.Get("Location")
+	if loc == "" {
+		t.Fatalf("%s %s: no Location", resp.Request.Method, resp.Request.URL)
+	}
+	if err := json.Unmarshal([]byte(body), v); err != nil {
+		t.Fatalf("decode %s: %v", body, err)
+	}
+	return loc
+}
+
+// checkV1Error verify a v1 error, a single object rather than an array.
+func checkV1Error(t *testing.T, resp *http.Response, body string, code int, id string) {
+	t.Helper()
+	checkStatus(t, resp, body, code)
+	var e struct {
+		ID   string `json:"id"`
+		Code int    `json:"code"`
+	}
+	if err := json.Unmarshal([]byte(body), &e); err != nil {
+		t.Fatalf("error response is not an object: %v; body: %s", err, body)
+	}
+	if e.ID != id || e.Code != code {
+		t.Fatalf("got error %+v, want {%s %d}", e, id, code)
+	}
+}
+
+func TestV1(t *testing.T) {
+	ts := newTestServer(t)
+	defer func(c Config) { config = c }(config)
+	config.AdminToken = "admin-secret"
+	get := func(path string) (*http.Response, string) {
+		return do(t, "GET", ts.URL+path, "", "")
+	}
+
+	var user database.User
+	resp, body := postJSON(t, ts.URL+"/v1/users", database.User{Email: uniqueEmail("v1"), Age: 25})
+	loc := checkCreated(t, resp, body, &user)
+	if user.Id == 0 || loc != "/v1/users/"+strconv.Itoa(user.Id) {
+		t.Fatalf("created user %+v at %s", user, loc)
+	}
+	resp, body = get(loc)
+	checkV1Error(t, resp, body, http.StatusUnauthorized, "admin.unauthorized")
+	resp, body = doAdmin(t, "GET", ts.URL+loc, config.AdminToken)
+	checkStatus(t, resp, body, http.StatusOK)
+	checkHeaders(t, resp)
+	var got database.User
+	if err := json.Unmarshal([]byte(body), &got); err != nil || got != user {
+		t.Errorf("GET %s = %s, want %+v", loc, body, user)
+	}
+	resp, body = doAdmin(t, "GET", ts.URL+"/v1/users/999999999", config.AdminToken)
+	checkV1Error(t, resp, body, http.StatusNotFound, "user.not_found")
+	resp, body = doAdmin(t, "GET", ts.URL+"/v1/users/abc", config.AdminToken)
+	checkV1Error(t, resp, body, http.StatusUnprocessableEntity, "request.invalid")
+
+	var psikolog database.Psikolog
+	resp, body = postJSON(t, ts.URL+"/v1/psikologs", database.Psikolog{Email: uniqueEmail("v1"), Name: "Dr. Tuesday"})
+	loc = checkCreated(t, resp, body, &psikolog)
+	resp, body = get(loc)
+	checkStatus(t, resp, body, http.StatusOK)
+	if strings.Contains(body, "psikolog_email") || !strings.Contains(body, `"psikolog_name":"Dr. Tuesday"`) {
+		t.Errorf("public profile = %s", body)
+	}
+	pid := strconv.Itoa(psikolog.Id)
+	uid := strconv.Itoa(user.Id)
+
+	// wisdom points
+	resp, body = postJSON(t, ts.URL+"/v1/psikologs/"+pid+"/wisdom", map[string]int{"user_id": user.Id})
+	var wp database.WisdomPoint
+	loc = checkCreated(t, resp, body, &wp)
+	if loc != "/v1/psikologs/"+pid+"/wisdom/"+uid || wp.PsikologID != psikolog.Id {
+		t.Errorf("created wisdom point %+v at %s", wp, loc)
+	}
+	resp, body = postJSON(t, ts.URL+"/v1/psikologs/"+pid+"/wisdom", map[string]int{"user_id": user.Id})
+	checkV1Error(t, resp, body, http.StatusConflict, "wisdom.already_given")
+	resp, body = get(loc)
+	checkStatus(t, resp, body, http.StatusOK)
+	resp, body = get("/v1/psikologs/" + pid + "/wisdom")
+	checkStatus(t, resp, body, http.StatusOK)
+	var points database.PsikologPoint
+	if err := json.Unmarshal([]byte(body), &points); err != nil || points.Point != "10" {
+		t.Errorf("GET wisdom = %s", body)
+	}
+	// a wisdom point cannot be taken back
+	resp, body = do(t, "DELETE", ts.URL+loc, "", "")
+	checkV1Error(t, resp, body, http.StatusMethodNotAllowed, "method.not_allowed")
+	resp, body = get("/v1/psikologs/" + pid + "/wisdom/999999999")
+	checkV1Error(t, resp, body, http.StatusNotFound, "wisdom.not_found")
+	resp, body = get("/v1/psikologs/999999999/wisdom")
+	checkV1Error(t, resp, body, http.StatusNotFound, "psikolog.not_found")
+
+	// posts
+	var post database.Post
+	resp, body = postJSON(t, ts.URL+"/v1/posts", database.Post{UserId: uid, PsikologId: pid, Title: "t", Category: "c", Content: "text"})
+	loc = checkCreated(t, resp, body, &post)
+	if post.Date == nil {
+		t.Errorf("created post without date: %s", body)
+	}
+	resp, body = get(loc)
+	checkStatus(t, resp, body, http.StatusOK)
+	resp, body = get("/v1/posts?user_id=" + uid)
+	var posts []database.Post
+	decodeArray(t, body, &posts)
+	if len(posts) != 1 || posts[0].Id != post.Id {
+		t.Errorf("GET /v1/posts = %s", body)
+	}
+	resp, body = get("/v1/posts?user_id=999999999")
+	checkStatus(t, resp, body, http.StatusOK)
+	if body != "[]\n" {
+		t.Errorf("posts of an unknown user = %q, want []", body)
+	}
+	resp, body = get("/v1/posts")
+	checkV1Error(t, resp, body, http.StatusBadRequest, "request.missing_parameter")
+	resp, body = get("/v1/posts/999999999")
+	checkV1Error(t, resp, body, http.StatusNotFound, "post.not_found")
+	resp, body = postJSON(t, ts.URL+"/v1/posts", database.Post{UserId: "999999999", PsikologId: pid, Title: "t", Category: "c", Content: "text"})
+	checkV1Error(t, resp, body, http.StatusUnprocessableEntity, "user.not_found")
+
+	// comments
+	postPath := "/v1/posts/" + strconv.Itoa(post.Id)
+	var comment database.Comment
+	resp, body = postJSON(t, ts.URL+postPath+"/comments", map[string]interface{}{
+		"comment_user_id": user.Id, "comment_psikolog_id": psikolog.Id, "comment_text": "hang in there",
+	})
+	loc = checkCreated(t, resp, body, &comment)
+	if comment.PostId != post.Id || comment.Date == nil {
+		t.Errorf("created comment %s", body)
+	}
+	resp, body = get(loc)
+	checkStatus(t, resp, body, http.StatusOK)
+	resp, body = get(postPath + "/comments")
+	var comments []database.Comment
+	decodeArray(t, body, &comments)
+	if len(comments) != 1 || comments[0].Id != comment.Id {
+		t.Errorf("GET comments = %s", body)
+	}
+	resp, body = get("/v1/posts/999999999/comments")
+	checkV1Error(t, resp, body, http.StatusNotFound, "post.not_found")
+
+	// reports
+	var report database.Report
+	resp, body = postJSON(t, ts.URL+"/v1/reports", database.Report{UserId: user.Id, PostId: post.Id})
+	loc = checkCreated(t, resp, body, &report)
+	resp, body = get(loc)
+	checkV1Error(t, resp, body, http.StatusUnauthorized, "admin.unauthorized")
+	resp, body = doAdmin(t, "GET", ts.URL+loc, config.AdminToken)
+	checkStatus(t, resp, body, http.StatusOK)
+	resp, body = doAdmin(t, "GET", ts.URL+"/v1/reports/999999999", config.AdminToken)
+	checkV1Error(t, resp, body, http.StatusNotFound, "report.not_found")
+
+	// unsupported methods
+	resp, body = do(t, "PUT", ts.URL+"/v1/posts", "", "")
+	checkV1Error(t, resp, body, http.StatusMethodNotAllowed, "method.not_allowed")
+	if allow := resp.Header.Get("Allow"); allow != "GET, HEAD, OPTIONS, POST" {
+		t.Errorf("Allow = %q", allow)
+	}
+	resp, body = do(t, "OPTIONS", ts.URL+"/v1/reports", "", "")
+	checkStatus(t, resp, body, http.StatusNoContent)
+	if allow := resp.Header.Get("Allow"); allow != "OPTIONS, POST" {
+		t.Errorf("Allow = %q", allow)
+	}
+	resp, body = do(t, "HEAD", ts.URL+postPath, "", "")
+	checkStatus(t, resp, body, http.StatusOK)
+}
+
+func TestV1UpdatePsikolog(t *testing.T) {
+	ts := newTestServer(t)
+	defer func(c Config) { config = c }(config)
+	config.AdminToken = "admin-secret"
+	// reads go through the cache, as in production
+	db = database.NewCache(db, 100, time.Minute)
+	patch := func(u, body string) (*http.Response, string) {
+		return doAdmin(t, "PATCH", u, config.AdminToken, json.RawMessage(body))
+	}
+
+	var p, other database.Psikolog
+	resp, body := postJSON(t, ts.URL+"/v1/psikologs", database.Psikolog{Email: uniqueEmail("v1"), Name: "Dr. Tuesday"})
+	loc := checkCreated(t, resp, body, &p)
+	resp, body = postJSON(t, ts.URL+"/v1/psikologs", database.Psikolog{Email: uniqueEmail("v1"), Name: "Dr. Friday"})
+	checkCreated(t, resp, body, &other)
+	resp, body = do(t, "GET", ts.URL+loc, "", "")
+	checkStatus(t, resp, body, http.StatusOK)
+
+	// only moderators, and the wisdom counts the points given
+	resp, body = do(t, "PATCH", ts.URL+loc, "application/json", `{"psikolog_available": false}`)
+	checkV1Error(t, resp, body, http.StatusUnauthorized, "admin.unauthorized")
+	resp, body = patch(ts.URL+loc, `{"psikolog_bio": "Listens.", "psikolog_wisdom": "1000"}`)
+	checkStatus(t, resp, body, http.StatusOK)
+	if strings.Contains(body, "psikolog_email") {
+		t.Errorf("PATCH answered the email: %s", body)
+	}
+	resp, body = do(t, "GET", ts.URL+loc, "", "")
+	var got database.Psikolog
+	if err := json.Unmarshal([]byte(body), &got); err != nil || got.Bio != "Listens." || got.Name != p.Name || got.Wisdom != 0 {
+		t.Errorf("GET after PATCH = %s", body)
+	}
+
+	resp, body = patch(ts.URL+loc, `{"psikolog_email": "`+other.Email+`"}`)
+	checkV1Error(t, resp, body, http.StatusConflict, "psikolog.email_taken")
+	resp, body = patch(ts.URL+loc, `{"psikolog_name": ""}`)
+	checkV1Error(t, resp, body, http.StatusUnprocessableEntity, "request.invalid")
+	resp, body = patch(ts.URL+"/v1/psikologs/999999999", `{}`)
+	checkV1Error(t, resp, body, http.StatusNotFound, "psikolog.not_found")
+}
+
+func TestV1EditPost(t *testing.T) {
+	ts := newTestServer(t)
+	get := func(path string) (*http.Response, string) {
+		return do(t, "GET", ts.URL+path, "", "")
+	}
+
+	var user, other database.User
+	resp, body := postJSON(t, ts.URL+"/v1/users", database.User{Email: uniqueEmail("edit"), Age: 25})
+	checkCreated(t, resp, body, &user)
+	resp, body = postJSON(t, ts.URL+"/v1/users", database.User{Email: uniqueEmail("edit"), Age: 25})
+	checkCreated(t, resp, body, &other)
+	var psikolog database.Psikolog
+	resp, body = postJSON(t, ts.URL+"/v1/psikologs", database.Psikolog{Email: uniqueEmail("edit"), Name: "Dr. Tuesday"})
+	checkCreated(t, resp, body, &psikolog)
+	uid, pid := strconv.Itoa(user.Id), strconv.Itoa(psikolog.Id)
+
+	var post database.Post
+	resp, body = postJSON(t, ts.URL+"/v1/posts", database.Post{UserId: uid, PsikologId: pid, Title: "first", Category: "c", Content: "text"})
+	loc := checkCreated(t, resp, body, &post)
+
+	// only the author edits
+	edit := `{"post_title": "second", "post_content": "better words"}`
+	resp, body = do(t, "PATCH", ts.URL+loc, "application/json", edit)
+	checkV1Error(t, resp, body, http.StatusBadRequest, "request.missing_parameter")
+	resp, body = do(t, "PATCH", ts.URL+loc+"?user_id="+strconv.Itoa(other.Id), "application/json", edit)
+	checkV1Error(t, resp, body, http.StatusForbidden, "post.not_author")
+	resp, body = do(t, "PATCH", ts.URL+loc+"?user_id="+uid, "application/json", `{"post_title": ""}`)
+	checkV1Error(t, resp, body, http.StatusUnprocessableEntity, "request.invalid")
+	resp, body = do(t, "PATCH", ts.URL+loc+"?user_id="+uid, "application/json", edit)
+	checkStatus(t, resp, body, http.StatusOK)
+	resp, body = get(loc)
+	var got database.Post
+	if err := json.Unmarshal([]byte(body), &got); err != nil || got.Title != "second" || got.Category != "c" || got.UpdatedAt == nil {
+		t.Errorf("GET after PATCH = %s", body)
+	}
+
+	// the psikolog of the post sees the history
+	revisions := loc + "/revisions?psikolog_id="
+	resp, body = get(revisions + "999999999")
+	checkV1Error(t, resp, body, http.StatusForbidden, "post.not_assigned")
+	resp, body = get(revisions + pid)
+	var revs []database.PostRevision
+	decodeArray(t, body, &revs)
+	if len(revs) != 1 || revs[0].Title != "first" || revs[0].Content != "text" {
+		t.Errorf("revisions = %s", body)
+	}
+
+	// deleted posts are hidden everywhere
+	resp, body = do(t, "DELETE", ts.URL+loc+"?user_id="+strconv.Itoa(other.Id), "", "")
+	checkV1Error(t, resp, body, http.StatusForbidden, "post.not_author")
+	resp, body = do(t, "DELETE", ts.URL+loc+"?user_id="+uid, "", "")
+	checkStatus(t, resp, body, http.StatusNoContent)
+	resp, body = get(loc)
+	checkV1Error(t, resp, body, http.StatusNotFound, "post.not_found")
+	resp, body = get(revisions + pid)
+	checkV1Error(t, resp, body, http.StatusNotFound, "post.not_found")
+	resp, body = get("/v1/posts?user_id=" + uid)
+	if body != "[]\n" {
+		t.Errorf("posts after delete = %s", body)
+	}
+	resp, body = postJSON(t, ts.URL+loc+"/comments", map[string]interface{}{
+		"comment_user_id": user.Id, "comment_psikolog_id": psikolog.Id, "comment_text": "too late",
+	})
+	checkV1Error(t, resp, body, http.StatusUnprocessableEntity, "post.not_found")
+	resp, body = do(t, "DELETE", ts.URL+loc+"?user_id="+uid, "", "")
+	checkV1Error(t, resp, body, http.StatusNotFound, "post.not_found")
+}
+
+// doAdmin send a request with the admin token token, and body as JSON when
+// given.
+func doAdmin(t *testing.T, method, u, token string, body ...interface{}) (*http.Response, string) {
+	var r io.Reader
+	if len(body) > 0 {
+		b, err := json.Marshal(body[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		r = bytes.NewReader(b)
+	}
+	req, err := http.NewRequest(method, u, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, string(b)
+}
+
+func TestV1ModerateComment(t *testing.T) {
+	ts := newTestServer(t)
+	defer func(token string) { config.AdminToken = token }(config.AdminToken)
+	config.AdminToken = "moderator-secret"
+	get := func(path string) (*http.Response, string) {
+		return do(t, "GET", ts.URL+path, "", "")
+	}
+
+	var user database.User
+	resp, body := postJSON(t, ts.URL+"/v1/users", database.User{Email: uniqueEmail("mod"), Age: 25})
+	checkCreated(t, resp, body, &user)
+	var psikolog, other database.Psikolog
+	resp, body = postJSON(t, ts.URL+"/v1/psikologs", database.Psikolog{Email: uniqueEmail("mod"), Name: "Dr. Tuesday"})
+	checkCreated(t, resp, body, &psikolog)
+	resp, body = postJSON(t, ts.URL+"/v1/psikologs", database.Psikolog{Email: uniqueEmail("mod"), Name: "Dr. Friday"})
+	checkCreated(t, resp, body, &other)
+	var post database.Post
+	resp, body = postJSON(t, ts.URL+"/v1/posts", database.Post{UserId: strconv.Itoa(user.Id), PsikologId: strconv.Itoa(psikolog.Id), Title: "t", Category: "c", Content: "text"})
+	postLoc := checkCreated(t, resp, body, &post)
+	var comment database.Comment
+	resp, body = postJSON(t, ts.URL+postLoc+"/comments", map[string]interface{}{
+		"comment_user_id": user.Id, "comment_psikolog_id": psikolog.Id, "comment_text": "hang in thre",
+	})
+	loc := checkCreated(t, resp, body, &comment)
+	author := "?psikolog_id=" + strconv.Itoa(psikolog.Id)
+
+	// edits by the psikolog who wrote it
+	edit := `{"comment_text": "hang in there"}`
+	resp, body = do(t, "PATCH", ts.URL+loc, "application/json", edit)
+	checkV1Error(t, resp, body, http.StatusBadRequest, "request.missing_parameter")
+	resp, body = do(t, "PATCH", ts.URL+loc+"?psikolog_id="+strconv.Itoa(other.Id), "application/json", edit)
+	checkV1Error(t, resp, body, http.StatusForbidden, "comment.not_author")
+	resp, body = do(t, "PATCH", ts.URL+loc+author, "application/json", edit)
+	checkStatus(t, resp, body, http.StatusOK)
+	resp, body = get(loc)
+	var got database.Comment
+	if err := json.Unmarshal([]byte(body), &got); err != nil || got.Text != "hang in there" || got.UpdatedAt == nil {
+		t.Errorf("GET after PATCH = %s", body)
+	}
+	resp, body = get(loc + "/revisions" + author)
+	var revs []database.CommentRevision
+	decodeArray(t, body, &revs)
+	if len(revs) != 1 || revs[0].Text != "hang in thre" {
+		t.Errorf("revisions = %s", body)
+	}
+	resp, body = doAdmin(t, "GET", ts.URL+loc+"/revisions", "moderator-secret")
+	checkStatus(t, resp, body, http.StatusOK)
+
+	// moderators hide and restore
+	resp, body = doAdmin(t, "PUT", ts.URL+loc+"/hidden", "wrong")
+	checkV1Error(t, resp, body, http.StatusUnauthorized, "admin.unauthorized")
+	resp, body = doAdmin(t, "PUT", ts.URL+loc+"/hidden", "moderator-secret")
+	checkStatus(t, resp, body, http.StatusNoContent)
+	resp, body = get(loc)
+	checkV1Error(t, resp, body, http.StatusNotFound, "comment.not_found")
+	resp, body = get(postLoc + "/comments")
+	if body != "[]\n" {
+		t.Errorf("comments with the hidden one = %s", body)
+	}
+	resp, body = do(t, "PATCH", ts.URL+loc+author, "application/json", edit)
+	checkV1Error(t, resp, body, http.StatusNotFound, "comment.not_found")
+	resp, body = doAdmin(t, "DELETE", ts.URL+loc+"/hidden", "moderator-secret")
+	checkStatus(t, resp, body, http.StatusNoContent)
+	resp, body = get(loc)
+	checkStatus(t, resp, body, http.StatusOK)
+
+	// the author retracts it
+	resp, body = do(t, "DELETE", ts.URL+loc+author, "", "")
+	checkStatus(t, resp, body, http.StatusNoContent)
+	resp, body = get(loc)
+	checkV1Error(t, resp, body, http.StatusNotFound, "comment.not_found")
+	resp, body = doAdmin(t, "DELETE", ts.URL+loc+"/hidden", "moderator-secret")
+	checkV1Error(t, resp, body, http.StatusNotFound, "comment.not_found")
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// fieldError describe why one field of a request is invalid. Like
+// apiError, Message is filled from the catalog of ID; arg is substituted
+// for {arg} in it.
+type fieldError struct {
+	Field   string `json:"field"`
+	ID      string `json:"id"`
+	Message string `json:"message"`
+	arg     string
+}
+
+// Request types declare their rules in a validate struct tag, next to the
+// json tag that names the field:
+//
+//	Email string `json:"user_email" validate:"required,email,maxlen=254"`
+//
+// Rules are separated by commas. A field that is not required and holds
+// its zero value is not checked further. The rules of a slice are checked
+// on each of its elements.
+//
+//	required   not empty (strings are trimmed) and not zero
+//	id         a positive integer, or a string holding one
+//	assignee   like id, or "auto" to route the post, see route
+//	min=N      integer >= N
+//	max=N      integer <= N
+//	maxlen=N   at most N characters
+//	email      a bare email address
+//	url        an absolute http(s) URL
+//	slug       lowercase letters, digits and inner dashes, see categorySlug
+//	category   the slug of an active category, any while there is none
+//	tag        holds a letter or digit, see tagName
+//
+// A rule return nil when the value passes, otherwise the ID of the failure.
+var rules = map[string]func(v reflect.Value, arg string) *fieldError{
+	"required": func(v reflect.Value, arg string) *fieldError {
+		if isZero(v) {
+			return &fieldError{ID: "field.required"}
+		}
+		return nil
+	},
+	"id": func(v reflect.Value, arg string) *fieldError {
+		if v.Kind() == reflect.String {
+			if n, err := strconv.Atoi(v.String()); err != nil || n < 1 {
+				return &fieldError{ID: "field.positive_integer"}
+			}
+			return nil
+		}
+		if v.Int() < 1 {
+			return &fieldError{ID: "field.positive_integer"}
+		}
+		return nil
+	},
+	"assignee": func(v reflect.Value, arg string) *fieldError {
+		if v.String() == autoAssign {
+			return nil
+		}
+		if n, err := strconv.Atoi(v.String()); err != nil || n < 1 {
+			return &fieldError{ID: "field.assignee"}
+		}
+		return nil
+	},
+	"min": func(v reflect.Value, arg string) *fieldError {
+		n, _ := strconv.ParseInt(arg, 10, 64)
+		if v.Int() < n {
+			return &fieldError{ID: "field.min", arg: arg}
+		}
+		return nil
+	},
+	"max": func(v reflect.Value, arg string) *fieldError {
+		n, _ := strconv.ParseInt(arg, 10, 64)
+		if v.Int() > n {
+			return &fieldError{ID: "field.max", arg: arg}
+		}
+		return nil
+	},
+	"maxlen": func(v reflect.Value, arg string) *fieldError {
+		n, _ := strconv.Atoi(arg)
+		if utf8.RuneCountInString(v.String()) > n {
+			return &fieldError{ID: "field.maxlen", arg: arg}
+		}
+		return nil
+	},
+	"email": func(v reflect.Value, arg string) *fieldError {
+		a, err := mail.ParseAddress(v.String())
+		if err != nil || a.Address != v.String() {
+			return &fieldError{ID: "field.email"}
+		}
+		return nil
+	},
+	"url": func(v reflect.Value, arg string) *fieldError {
+		u, err := url.Parse(v.String())
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return &fieldError{ID: "field.url"}
+		}
+		return nil
+	},
+	"slug": func(v reflect.Value, arg string) *fieldError {
+		if categorySlug(v.String()) != v.String() {
+			return &fieldError{ID: "field.slug"}
+		}
+		return nil
+	},
+	"category": func(v reflect.Value, arg string) *fieldError {
+		slugs := activeCategories.get()
+		if len(slugs) == 0 {
+			return nil
+		}
+		for _, c := range slugs {
+			if categorySlug(v.String()) == c {
+				return nil
+			}
+		}
+		return &fieldError{ID: "field.category", arg: strings.Join(slugs, ", ")}
+	},
+	"tag": func(v reflect.Value, arg string) *fieldError {
+		if tagName(v.String()) == "" {
+			return &fieldError{ID: "field.tag"}
+		}
+		return nil
+	},
+}
+
+func isZero(v reflect.Value) bool {
+	if v.Kind() == reflect.String {
+		return strings.TrimSpace(v.String()) == ""
+	}
+	return v.IsZero()
+}
+
+// validate check every field of the struct pointed to by v against its
+// rules. It panics on an unknown rule, which is a programming error.
+func validate(v interface{}) []fieldError {
+	var errs []fieldError
+	rv := reflect.Indirect(reflect.ValueOf(v))
+	rt := rv.Type()
+	for i := 0; i < rt.NumField(); i++ {
+		f := rt.Field(i)
+		tag := f.Tag.Get("validate")
+		if tag == "" {
+			continue
+		}
+		name := strings.Split(f.Tag.Get("json"), ",")[0]
+		fv := rv.Field(i)
+		list := strings.Split(tag, ",")
+		if list[0] != "required" && isZero(fv) {
+			continue
+		}
+		if fv.Kind() != reflect.Slice {
+			errs = checkRules(errs, rt, f, list, name, fv)
+			continue
+		}
+		for j := 0; j < fv.Len(); j++ {
+			errs = checkRules(errs, rt, f, list, fmt.Sprintf("%s[%d]", name, j), fv.Index(j))
+		}
+	}
+	return errs
+}
+
+// checkRules append to errs the first rule of list fv fails, as the error
+// of field name.
+func checkRules(errs []fieldError, rt reflect.Type, f reflect.StructField, list []string, name string, fv reflect.Value) []fieldError {
+	for _, rule := range list {
+		ruleName, arg := rule, ""
+		if eq := strings.Index(rule, "="); eq >= 0 {
+			ruleName, arg = rule[:eq], rule[eq+1:]
+		}
+		check, ok := rules[ruleName]
+		if !ok {
+			panic(fmt.Sprintf("validate: %s.%s: unknown rule %q", rt.Name(), f.Name, ruleName))
+		}
+		if fe := check(fv, arg); fe != nil {
+			fe.Field = name
+			return append(errs, *fe)
+		}
+	}
+	return errs
+}
+
+// invalidRequest is the 422 answer listing each invalid field.
+func invalidRequest(tag string, errs []fieldError) *apiError {
+	return &apiError{
+		Tag:    tag,
+		Error:  fmt.Errorf("invalid request: %v", errs),
+		ID:     "request.invalid",
+		Code:   http.StatusUnprocessableEntity,
+		Fields: errs,
+	}
+}
+
+// validateID check that the query parameter name holds a positive integer.
+func validateID(tag, name, value string) *apiError {
+	if n, err := strconv.Atoi(value); err != nil || n < 1 {
+		return invalidRequest(tag, []fieldError{{Field: name, ID: "field.positive_integer"}})
+	}
+	return nil
+}
+
+// decodeJSON strictly decode the request body into v, then validate it.
+// Malformed JSON, unknown fields and trailing data are a 400, a value of
+// the wrong type or failing its rules a 422.
+func decodeJSON(tag string, r *http.Request, v interface{}) *apiError {
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	err := dec.Decode(v)
+	if err == nil {
+		if _, e := dec.Token(); e != io.EOF {
+			err = errors.New("unexpected data after the JSON object")
+		}
+	}
+	if err != nil {
+		return decodeError(tag, err)
+	}
+	if errs := validate(v); len(errs) > 0 {
+		return invalidRequest(tag, errs)
+	}
+	return nil
+}
+
+// decodeError turn an error from json.Decoder into an apiError.
+func decodeError(tag string, err error) *apiError {
+	var typeErr *json.UnmarshalTypeError
+	var maxErr *http.MaxBytesError
+	switch {
+	case errors.As(err, &typeErr):
+		return invalidRequest(tag, []fieldError{{Field: typeErr.Field, ID: "field.type", arg: jsonType(typeErr.Type)}})
+	case errors.As(err, &maxErr):
+		return &apiError{
+			Tag:     tag,
+			Error:   err,
+			ID:      "request.too_large",
+			Code:    http.StatusRequestEntityTooLarge,
+			Details: map[string]interface{}{"limit": maxErr.Limit},
+		}
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		field, _ := strconv.Unquote(strings.TrimPrefix(err.Error(), "json: unknown field "))
+		return &apiError{
+			Tag:    tag,
+			Error:  err,
+			ID:     "request.malformed_json",
+			Code:   http.StatusBadRequest,
+			Fields: []fieldError{{Field: field, ID: "field.unknown"}},
+		}
+	case err == io.EOF:
+		err = errors.New("empty body")
+	}
+	return &apiError{
+		Tag:   tag,
+		Error: err,
+		ID:    "request.malformed_json",
+		Code:  http.StatusBadRequest,
+	}
+}
+
+// jsonType name a Go type the way a client sees it in JSON.
+func jsonType(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.String:
+		return "string"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "integer"
+	case reflect.Float32, reflect.Float64:
+		return "number"
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Slice, reflect.Array:
+		return "array"
+	}
+	return "object"
+}